@@ -0,0 +1,145 @@
+package certstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// acceptableCASelectors holds the selectors derived from a selector with
+// match_acceptable_cas, one per list of acceptable CAs a server has sent.
+// A nil selector records that no identity chains to the CAs of that list.
+type acceptableCASelectors struct {
+	mu        sync.Mutex
+	selectors map[string]*CertSelector
+}
+
+// acceptableCAsKey identifies a server's list of acceptable CAs.
+func acceptableCAsKey(acceptableCAs [][]byte) string {
+	h := sha256.New()
+	for _, ca := range acceptableCAs {
+		writeCacheKeyPart(h, fmt.Sprintf("%x", ca))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// acceptableCAMatch returns the selector choosing, among the identities the
+// selector matches, one whose chain is issued by one of the acceptable CAs,
+// or nil when the store has none. The store is searched the first time a
+// list of acceptable CAs is seen; later handshakes sending the same list use
+// the result, until the selector is released. A search that fails for
+// another reason than finding no identity is retried by the next handshake.
+func (cs *CertSelector) acceptableCAMatch(ctx context.Context, acceptableCAs [][]byte) *CertSelector {
+	matches := cs.acceptableCAs
+	key := acceptableCAsKey(acceptableCAs)
+
+	matches.mu.Lock()
+	defer matches.mu.Unlock()
+
+	if selector, ok := matches.selectors[key]; ok {
+		return selector
+	}
+
+	derived := cs.deriveForAcceptableCAs(acceptableCAs)
+	if _, err := derived.loadCertificate(ctx); err != nil {
+		if cs.logger != nil {
+			cs.logger.Debug("no client certificate issued by a CA the server accepts",
+				zap.Int("acceptable_cas", len(acceptableCAs)),
+				zap.Error(err),
+			)
+		}
+		if !errors.Is(err, errNoMatchingIdentity) {
+			return nil
+		}
+		derived = nil
+	} else if cs.logger != nil {
+		cert, _ := derived.cacheEntry.currentCertificate()
+		cs.logger.Info("selected client certificate issued by a CA the server accepts",
+			zap.String("common_name", cert.Leaf.Subject.CommonName),
+			zap.String("serial_number", cert.Leaf.SerialNumber.String()),
+		)
+	}
+	if matches.selectors == nil {
+		matches.selectors = make(map[string]*CertSelector)
+	}
+	matches.selectors[key] = derived
+	return derived
+}
+
+// deriveForAcceptableCAs returns a copy of the provisioned selector that
+// only matches identities whose chain is issued by one of acceptableCAs. It
+// shares the selector's fallback, which the selector releases.
+func (cs *CertSelector) deriveForAcceptableCAs(acceptableCAs [][]byte) *CertSelector {
+	derived := *cs
+	derived.MatchAcceptableCAs = false
+	derived.DevSelfSigned = ""
+	derived.cacheKey = ""
+	derived.cacheEntry = nil
+	derived.acceptableCAs = nil
+	derived.acceptableCAList = acceptableCAs
+	return &derived
+}
+
+// releaseAcceptableCAMatches drops the cache references of the derived
+// selectors.
+func (cs *CertSelector) releaseAcceptableCAMatches() {
+	if cs.acceptableCAs == nil {
+		return
+	}
+	cs.acceptableCAs.mu.Lock()
+	defer cs.acceptableCAs.mu.Unlock()
+
+	for _, selector := range cs.acceptableCAs.selectors {
+		if selector != nil && selector.cacheKey != "" {
+			selector.cache.release(selector.cacheKey, selector.owner)
+		}
+	}
+	cs.acceptableCAs.selectors = nil
+}
+
+// issuedByAcceptableCA reports whether a certificate of the identity's chain
+// is issued by one of acceptableCAs, the distinguished names a server sends
+// in its certificate request. An empty list accepts every identity.
+func issuedByAcceptableCA(identity Identity, acceptableCAs [][]byte) bool {
+	if len(acceptableCAs) == 0 {
+		return true
+	}
+	chain, err := identity.CertificateChain()
+	if err != nil {
+		return false
+	}
+	for _, cert := range chain {
+		for _, ca := range acceptableCAs {
+			if bytes.Equal(cert.RawIssuer, ca) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptableCACertificate returns the certificate of the selector's
+// identity issued by a CA the server accepts, when the selector's own
+// certificate is not and match_acceptable_cas is set. It returns false when
+// there is none.
+func (cs *CertSelector) acceptableCACertificate(cri *tls.CertificateRequestInfo) (tls.Certificate, bool, error) {
+	if !cs.MatchAcceptableCAs || cs.acceptableCAs == nil || cri == nil || len(cri.AcceptableCAs) == 0 {
+		return tls.Certificate{}, false, nil
+	}
+	ctx := cri.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	selector := cs.acceptableCAMatch(ctx, cri.AcceptableCAs)
+	if selector == nil {
+		return tls.Certificate{}, false, nil
+	}
+	cert, err := selector.handshakeCertificate()
+	return cert, err == nil, err
+}
@@ -0,0 +1,85 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestSelectorClientCertificate_AcceptableCAs(t *testing.T) {
+	resetCertificateCache(t)
+
+	partnerCA := newTestCA(t, "Partner CA")
+	corporateCA := newTestCA(t, "Corporate CA")
+	partnerKey, corporateKey := newTestKey(t), newTestKey(t)
+	partner := newFakeIdentity(partnerCA.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.test"}}, partnerKey.Public()), newFakeSigner(partnerKey.Public(), []byte("partner")))
+	corporate := newFakeIdentity(corporateCA.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.test"}}, corporateKey.Public()), newFakeSigner(corporateKey.Public(), []byte("corporate")))
+	provider := withFakeStoreLoads(t,
+		newFakeStoreLoadWithIdentities(partner, corporate),
+		newFakeStoreLoadWithIdentities(partner, corporate),
+		newFakeStoreLoadWithIdentities(partner, corporate),
+	)
+
+	selector := newTestSelector("^client\\.example\\.test$")
+	selector.MatchAcceptableCAs = true
+	selector.acceptableCAs = new(acceptableCASelectors)
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	cri := supportedCertificateRequestInfo()
+	cert, err := selectorClientCertificate(selector, cri)
+	if err != nil || cert.Leaf == nil || cert.Leaf.Issuer.CommonName != "Corporate CA" {
+		t.Fatalf("expected the selected certificate without a CA list, got %+v, err=%v", cert.Leaf, err)
+	}
+	finishHandshake(*cert)
+
+	cri.AcceptableCAs = [][]byte{partnerCA.cert.RawSubject}
+	for range 2 {
+		cert, err = selectorClientCertificate(selector, cri)
+		if err != nil || cert.Leaf == nil || cert.Leaf.Issuer.CommonName != "Partner CA" {
+			t.Fatalf("expected the certificate issued by the acceptable CA, got %+v, err=%v", cert.Leaf, err)
+		}
+		finishHandshake(*cert)
+	}
+	if provider.openCount() != 2 {
+		t.Fatalf("expected the store to be searched once for the CA list, got %d opens", provider.openCount())
+	}
+
+	cri.AcceptableCAs = [][]byte{newTestCA(t, "Unknown CA").cert.RawSubject}
+	for range 2 {
+		cert, err = selectorClientCertificate(selector, cri)
+		if err != nil || len(cert.Certificate) != 0 {
+			t.Fatalf("expected an empty certificate without an identity for the CA list, got %+v, err=%v", cert.Leaf, err)
+		}
+	}
+	if provider.openCount() != 3 {
+		t.Fatalf("expected a CA list without a match to be remembered, got %d opens", provider.openCount())
+	}
+}
+
+func TestSelectorClientCertificate_AcceptableCAsDisabled(t *testing.T) {
+	resetCertificateCache(t)
+
+	ca := newTestCA(t, "Corporate CA")
+	key := newTestKey(t)
+	identity := newFakeIdentity(ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.test"}}, key.Public()), newFakeSigner(key.Public(), []byte("ok")))
+	provider := withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identity))
+
+	selector := newTestSelector("^client\\.example\\.test$")
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	cri := supportedCertificateRequestInfo()
+	cri.AcceptableCAs = [][]byte{newTestCA(t, "Partner CA").cert.RawSubject}
+	cert, err := selectorClientCertificate(selector, cri)
+	if err != nil || len(cert.Certificate) != 0 {
+		t.Fatalf("expected an empty certificate, got %+v, err=%v", cert.Leaf, err)
+	}
+	if provider.openCount() != 1 {
+		t.Fatalf("expected no store search without match_acceptable_cas, got %d opens", provider.openCount())
+	}
+}
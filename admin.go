@@ -0,0 +1,126 @@
+package certstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI is a module that serves endpoints to inspect the certificates
+// the certstore app has loaded from OS certificate stores.
+type adminAPI struct {
+	app *App
+}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.certstore",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Provision sets up the adminAPI module.
+func (a *adminAPI) Provision(ctx caddy.Context) error {
+	app, err := loadApp(ctx)
+	if err != nil {
+		return err
+	}
+	a.app = app
+	return nil
+}
+
+// Routes returns the admin routes for the certstore app.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/certstore/certificates",
+			Handler: caddy.AdminHandlerFunc(a.handleCertificates),
+		},
+		{
+			Pattern: "/certstore/import",
+			Handler: caddy.AdminHandlerFunc(a.handleImport),
+		},
+	}
+}
+
+// handleCertificates lists the cached certificates.
+func (a *adminAPI) handleCertificates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a.app.cache.info())
+}
+
+// handleImport imports a PFX or PEM identity into an OS certificate store.
+// It is disabled unless the certstore app sets admin_import.
+func (a *adminAPI) handleImport(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	if !a.app.AdminImport {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        fmt.Errorf("import is disabled; set admin_import in the certstore app to enable it"),
+		}
+	}
+
+	var req importRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&req); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decoding request: %w", err),
+		}
+	}
+	location, err := importLocation(req.Location)
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	pfx, leaf, err := req.pfx()
+	if err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+
+	if err := importIdentity(location, pfx, req.Password); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("importing identity: %w", err),
+		}
+	}
+	if a.app.logger != nil {
+		a.app.logger.Info("imported identity into OS certificate store",
+			zap.String("location", string(location)),
+			zap.String("subject", leaf.Subject.String()),
+			zap.String("serial_number", leaf.SerialNumber.String()),
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(importResponse{
+		Location:     string(location),
+		Subject:      leaf.Subject.String(),
+		SerialNumber: leaf.SerialNumber.String(),
+	})
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner = (*adminAPI)(nil)
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
@@ -0,0 +1,147 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestAdminAPI_Certificates(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "admin.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+	selector := newTestSelector("^admin\\.example\\.test$")
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	api := &adminAPI{app: defaultApp}
+
+	rec := httptest.NewRecorder()
+	err := api.handleCertificates(rec, httptest.NewRequest(http.MethodGet, "/certstore/certificates", nil))
+	if err != nil {
+		t.Fatalf("handleCertificates failed: %v", err)
+	}
+
+	var infos []cachedCertInfo
+	if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 cached certificate, got %d", len(infos))
+	}
+	if infos[0].CommonName != "admin.example.test" || infos[0].References != 1 {
+		t.Fatalf("unexpected cache entry: %+v", infos[0])
+	}
+
+	rec = httptest.NewRecorder()
+	err = api.handleCertificates(rec, httptest.NewRequest(http.MethodPost, "/certstore/certificates", nil))
+	if err == nil {
+		t.Fatal("expected error for unsupported method")
+	}
+}
+
+// importingBackend records the identities imported through it.
+type importingBackend struct {
+	location StoreLocation
+	imported [][]byte
+	password string
+}
+
+func (b *importingBackend) OpenStore(StoreLocation) (Store, error) {
+	return nil, errors.New("unexpected read-only open")
+}
+
+func (b *importingBackend) OpenWritableStore(location StoreLocation) (Store, error) {
+	b.location = location
+	return importingStore{backend: b}, nil
+}
+
+type importingStore struct {
+	backend *importingBackend
+}
+
+func (s importingStore) Identities() ([]Identity, error) { return nil, nil }
+func (s importingStore) Close()                          {}
+
+func (s importingStore) Import(pfx []byte, password string) error {
+	s.backend.imported = append(s.backend.imported, pfx)
+	s.backend.password = password
+	return nil
+}
+
+func TestAdminAPI_Import(t *testing.T) {
+	backend := &importingBackend{}
+	t.Cleanup(SetBackend(backend))
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "import.example.test", key)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	identityPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	otherKey := newTestKey(t)
+	otherKeyDER, _ := x509.MarshalPKCS8PrivateKey(otherKey)
+	mismatchedPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: otherKeyDER}))
+
+	post := func(api *adminAPI, req importRequest) (*httptest.ResponseRecorder, error) {
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("marshal request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		return rec, api.handleImport(rec, httptest.NewRequest(http.MethodPost, "/certstore/import", bytes.NewReader(body)))
+	}
+	assertStatus := func(err error, status int) {
+		t.Helper()
+		var apiErr caddy.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatus != status {
+			t.Fatalf("expected HTTP %d error, got %v", status, err)
+		}
+	}
+
+	_, err = post(&adminAPI{app: &App{}}, importRequest{Location: "user", PEM: identityPEM})
+	assertStatus(err, http.StatusForbidden)
+
+	api := &adminAPI{app: &App{AdminImport: true}}
+	rec, err := post(api, importRequest{Location: "machine", PEM: identityPEM, Password: "secret"})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	var resp importResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Location != "system" || resp.Subject != "CN=import.example.test" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if backend.location != LocationSystem || len(backend.imported) != 1 || backend.password != "secret" {
+		t.Fatalf("expected one import into the system store, got %+v", backend)
+	}
+	if _, leaf, err := pkcs12.Decode(backend.imported[0], "secret"); err != nil || !leaf.Equal(cert) {
+		t.Fatalf("expected the imported pfx to hold the certificate: %v", err)
+	}
+
+	_, err = post(api, importRequest{Location: "any", PEM: identityPEM})
+	assertStatus(err, http.StatusBadRequest)
+	_, err = post(api, importRequest{Location: "user", PEM: mismatchedPEM})
+	assertStatus(err, http.StatusBadRequest)
+	_, err = post(api, importRequest{Location: "user"})
+	assertStatus(err, http.StatusBadRequest)
+}
@@ -0,0 +1,312 @@
+package certstore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+}
+
+// leakCheckDelay is how long after the app's cleanup the cache is checked
+// for references the config's modules did not release.
+const leakCheckDelay = 30 * time.Second
+
+// defaultApp backs transports provisioned without a configured certstore app,
+// so their selectors still share cached certificates.
+var defaultApp = &App{cache: newCertificateCache()}
+
+// App is the top-level "certstore" Caddy app. It owns the named certificate
+// selectors and the cache of certificates loaded from OS certificate stores.
+// Transports are thin consumers of the app: they resolve their selectors
+// through it, guaranteeing that multiple handlers share one cached identity
+// instead of each loading the same certificate from the store.
+type App struct {
+	// Selectors maps a name to a certificate selector. Transports use the
+	// name in their client_certificate_ref property.
+	Selectors map[string]*CertSelector `json:"selectors,omitempty"`
+
+	// Defaults are inherited by every selector, named or inline in a
+	// transport, that leaves the option unset, so shared settings are
+	// configured once.
+	Defaults *SelectorDefaults `json:"defaults,omitempty"`
+
+	// ReloadSignal forces re-selection of every store-backed certificate
+	// when triggered, so renewal scripts can poke Caddy without using the
+	// admin API. On macOS and other Unix systems it names a signal, either
+	// "SIGHUP" or "SIGUSR2". On Windows it names an event, such as
+	// "Global\caddy-certstore-reload", that scripts set.
+	ReloadSignal string `json:"reload_signal,omitempty"`
+
+	// ReselectSchedule re-selects every store-backed certificate at the
+	// times matching this five-field cron expression, in local time, such as
+	// "30 2 * * *" to re-read the stores nightly right after the CA's renewal
+	// window.
+	ReselectSchedule string `json:"reselect_schedule,omitempty"`
+
+	// CacheTTL re-selects each cached certificate once the store last
+	// confirmed it longer than this ago, swapping in the certificate the
+	// store now selects, so cached identities do not outlive changes to the
+	// store. Any load, refresh or re-selection confirms a certificate.
+	// Default: 0 (cached until no longer referenced)
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	// AdminImport enables the admin API endpoint that imports PFX or PEM
+	// identities into the OS certificate stores, for fleet bootstrap
+	// automation. It writes to the stores, so only enable it when the admin
+	// endpoint is restricted to trusted callers. Default: false
+	AdminImport bool `json:"admin_import,omitempty"`
+
+	// MaxConcurrentStoreOpens limits how many certificate stores are opened
+	// and enumerated at once, for smart card middleware that fails
+	// sporadically when many transports provision in parallel. Default: 0
+	// (unlimited)
+	MaxConcurrentStoreOpens int `json:"max_concurrent_store_opens,omitempty"`
+
+	cache    *certificateCache
+	schedule *cronSchedule
+	logger   *zap.Logger
+	stop     chan struct{}
+}
+
+// SelectorDefaults holds the selector options inherited by selectors that
+// leave them unset. Each has the meaning of the selector option of the same
+// name.
+type SelectorDefaults struct {
+	Location           string         `json:"location,omitempty"`
+	Prefer             string         `json:"prefer,omitempty"`
+	NotBeforeSkew      caddy.Duration `json:"not_before_skew,omitempty"`
+	MaxCandidates      int            `json:"max_candidates,omitempty"`
+	MaxEnumerationTime caddy.Duration `json:"max_enumeration_time,omitempty"`
+	LogLevel           string         `json:"log_level,omitempty"`
+}
+
+// apply sets the options cs leaves unset to the defaults.
+func (d *SelectorDefaults) apply(cs *CertSelector) {
+	if d == nil {
+		return
+	}
+	cs.Location = cmp.Or(cs.Location, d.Location)
+	cs.Prefer = cmp.Or(cs.Prefer, d.Prefer)
+	cs.NotBeforeSkew = cmp.Or(cs.NotBeforeSkew, d.NotBeforeSkew)
+	cs.MaxCandidates = cmp.Or(cs.MaxCandidates, d.MaxCandidates)
+	cs.MaxEnumerationTime = cmp.Or(cs.MaxEnumerationTime, d.MaxEnumerationTime)
+	cs.LogLevel = cmp.Or(cs.LogLevel, d.LogLevel)
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "certstore",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision loads the certificate of every named selector.
+func (a *App) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+	a.cache = newCertificateCache()
+	a.cache.previous = runningCache()
+
+	if err := registerMetrics(ctx.GetMetricsRegistry()); err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+
+	if a.MaxConcurrentStoreOpens < 0 {
+		return fmt.Errorf("max_concurrent_store_opens must not be negative")
+	}
+
+	if a.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative")
+	}
+
+	if a.ReselectSchedule != "" {
+		schedule, err := parseCronSchedule(a.ReselectSchedule)
+		if err != nil {
+			return fmt.Errorf("reselect_schedule: %w", err)
+		}
+		a.schedule = schedule
+	}
+
+	for name, selector := range a.Selectors {
+		if selector == nil {
+			return fmt.Errorf("selector '%s' is empty", name)
+		}
+		selector.name = name
+		if err := selector.provision(ctx, a); err != nil {
+			return fmt.Errorf("provisioning selector '%s': %w", name, err)
+		}
+	}
+
+	// The limit is process-wide, so it only changes once nothing else can
+	// reject the config; the transports provisioned after the app use it.
+	storeOpens.setLimit(a.MaxConcurrentStoreOpens)
+	return nil
+}
+
+// Start implements caddy.App. It starts watching for the reload signal and
+// the re-selection schedule.
+func (a *App) Start() error {
+	// Provisioning is over, so the previous config's cache can go away.
+	a.cache.mu.Lock()
+	a.cache.previous = nil
+	a.cache.mu.Unlock()
+
+	a.stop = make(chan struct{})
+	if a.schedule != nil {
+		go a.runSchedule(a.stop)
+	}
+	if a.CacheTTL > 0 {
+		go a.revalidate(a.stop)
+	}
+	if a.ReloadSignal == "" {
+		return nil
+	}
+	return watchReloadSignal(a.ReloadSignal, a.reselectAll, a.stop)
+}
+
+// Stop implements caddy.App.
+func (a *App) Stop() error {
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+	return nil
+}
+
+// reselectAll re-runs the selectors of all cached certificates, swapping in
+// any certificate the store now selects instead.
+func (a *App) reselectAll() {
+	a.reselect(a.ReloadSignal)
+}
+
+// reselect is reselectAll, logging trigger as the cause.
+func (a *App) reselect(trigger string) {
+	a.logger.Info("re-selecting client certificates", zap.String("trigger", trigger))
+	rotated := a.cache.reselectAll(context.Background(), a.logger)
+	a.logger.Info("re-selected client certificates", zap.Int("rotated", rotated))
+}
+
+// runSchedule re-selects all certificates at the times of the re-selection
+// schedule until stop is closed.
+func (a *App) runSchedule(stop <-chan struct{}) {
+	for {
+		next := a.schedule.next(time.Now())
+		if next.IsZero() {
+			a.logger.Warn("reselect_schedule matches no upcoming time", zap.String("schedule", a.ReselectSchedule))
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			a.reselect("schedule " + a.ReselectSchedule)
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// revalidate re-selects each cached certificate once it is older than the
+// cache TTL until stop is closed. A failed re-selection is logged and retried
+// a TTL later, keeping the cached certificate meanwhile.
+func (a *App) revalidate(stop <-chan struct{}) {
+	ttl := time.Duration(a.CacheTTL)
+	attempts := make(map[*cachedCert]time.Time)
+	for {
+		stale, wait := a.cache.staleEntries(ttl, attempts, time.Now())
+		for _, cached := range stale {
+			attempts[cached] = time.Now()
+			changed, err := cached.reselect(context.Background())
+			if err != nil {
+				a.logger.Error("revalidating cached client certificate",
+					zap.String("cache_key", thumbprintPrefix(cached.cacheKey)),
+					zap.Error(err),
+				)
+				continue
+			}
+			a.logger.Debug("revalidated cached client certificate",
+				zap.String("cache_key", thumbprintPrefix(cached.cacheKey)),
+				zap.Bool("rotated", changed),
+			)
+		}
+		if len(stale) > 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Cleanup implements caddy.CleanerUpper. It releases the cached certificates
+// held by the named selectors. The other modules of the config are cleaned up
+// around the same time, so once leakCheckDelay has passed any reference still
+// held by the config's cache has leaked and is reported.
+func (a *App) Cleanup() error {
+	for _, selector := range a.Selectors {
+		if selector != nil {
+			selector.release()
+		}
+	}
+	if a.cache != nil && a.logger != nil {
+		cache, logger := a.cache, a.logger
+		time.AfterFunc(leakCheckDelay, func() { cache.reportLeaks(logger) })
+	}
+	return nil
+}
+
+// selector returns the provisioned selector registered under name.
+func (a *App) selector(name string) (*CertSelector, error) {
+	selector, ok := a.Selectors[name]
+	if !ok || selector == nil {
+		return nil, fmt.Errorf("no selector named '%s' in certstore app", name)
+	}
+	return selector, nil
+}
+
+// runningCache returns the certificate cache of the running config, from which
+// a new config adopts the certificates of unchanged selectors on reload.
+func runningCache() *certificateCache {
+	appIface, err := caddy.ActiveContext().AppIfConfigured("certstore")
+	if err != nil {
+		return defaultApp.cache
+	}
+	return appIface.(*App).cache
+}
+
+// loadApp returns the certstore app of the current config, or the default
+// app when the certstore app is not configured.
+func loadApp(ctx caddy.Context) (*App, error) {
+	appIface, err := ctx.AppIfConfigured("certstore")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		if err := registerMetrics(ctx.GetMetricsRegistry()); err != nil {
+			return nil, fmt.Errorf("registering metrics: %w", err)
+		}
+		return defaultApp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return appIface.(*App), nil
+}
+
+// Interface guards
+var (
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
+)
@@ -0,0 +1,529 @@
+package certstore
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+func TestApp_NamedSelectors(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "named.example.test", key)
+	load := newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok")))
+	provider := withFakeStoreLoads(t, load)
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"banking": {Pattern: "^named\\.example\\.test$", Location: "user"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+
+	first, err := app.selector("banking")
+	if err != nil {
+		t.Fatalf("selector lookup failed: %v", err)
+	}
+	second, err := app.selector("banking")
+	if err != nil {
+		t.Fatalf("selector lookup failed: %v", err)
+	}
+	if first != second {
+		t.Fatal("expected references to share one selector instance")
+	}
+	if provider.openCount() != 1 {
+		t.Fatalf("expected named selector to load once, got %d store opens", provider.openCount())
+	}
+
+	if _, err := app.selector("missing"); err == nil {
+		t.Fatal("expected error for unknown selector name")
+	}
+
+	app.cache.mu.Lock()
+	refCount := atomic.LoadInt32(&app.cache.entries[first.cacheKey].refCount)
+	app.cache.mu.Unlock()
+	if refCount != 1 {
+		t.Fatalf("expected named selector to hold one reference, got %d", refCount)
+	}
+
+	if err := app.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if infos := app.cache.info(); len(infos) != 0 {
+		t.Fatalf("expected empty app cache after cleanup, got %d entries", len(infos))
+	}
+	if load.identity.closeCount() != 1 || load.store.closeCount() != 1 {
+		t.Fatalf("expected resources to close on app cleanup, got identity=%d store=%d", load.identity.closeCount(), load.store.closeCount())
+	}
+}
+
+func TestHTTPTransport_ClientCertRef(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	t.Run("inline and ref are mutually exclusive", func(t *testing.T) {
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCert:    newTestSelector("^ref\\.example\\.test$"),
+			ClientCertRef: "banking",
+		}
+		assertErrorContains(t, h.provisionSelector(ctx), "mutually exclusive")
+	})
+
+	t.Run("ref requires a named selector", func(t *testing.T) {
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCertRef: "banking",
+		}
+		assertErrorContains(t, h.provisionSelector(ctx), "no selector named 'banking'")
+	})
+}
+
+func TestHTTPTransport_ClientCerts(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	t.Run("first matching selector is used", func(t *testing.T) {
+		key := newTestKey(t)
+		cert := newTestCertificate(t, "second.example.test", key)
+		withFakeStoreLoads(t,
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("first"))),
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("second"))),
+		)
+
+		first := newTestSelector("^first\\.example\\.test$")
+		second := newTestSelector("^second\\.example\\.test$")
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCerts:   []*CertSelector{first, second},
+		}
+		if err := h.provisionSelector(ctx); err != nil {
+			t.Fatalf("provisionSelector failed: %v", err)
+		}
+		defer h.Cleanup()
+		if h.selector != second {
+			t.Fatal("expected the second selector to be used")
+		}
+		if first.cacheKey != "" {
+			t.Fatal("expected the unmatched selector to be released")
+		}
+	})
+
+	t.Run("no selector matches", func(t *testing.T) {
+		key := newTestKey(t)
+		cert := newTestCertificate(t, "other.example.test", key)
+		withFakeStoreLoads(t,
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("first"))),
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("second"))),
+		)
+
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCerts: []*CertSelector{
+				newTestSelector("^first\\.example\\.test$"),
+				newTestSelector("^second\\.example\\.test$"),
+			},
+		}
+		err := h.provisionSelector(ctx)
+		assertErrorContains(t, err, "client_certificates[0]")
+		assertErrorContains(t, err, "client_certificates[1]")
+	})
+
+	t.Run("optional selectors", func(t *testing.T) {
+		key := newTestKey(t)
+		cert := newTestCertificate(t, "other.example.test", key)
+		withFakeStoreLoads(t,
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("first"))),
+			newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("second"))),
+		)
+
+		first := newTestSelector("^first\\.example\\.test$")
+		first.Optional = true
+		last := newTestSelector("^second\\.example\\.test$")
+		last.Optional = true
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCerts:   []*CertSelector{first, last},
+		}
+		if err := h.provisionSelector(ctx); err != nil {
+			t.Fatalf("provisionSelector failed: %v", err)
+		}
+		defer h.Cleanup()
+		if h.selector != last || h.selector.loaded() {
+			t.Fatal("expected the last optional selector to be kept without a certificate")
+		}
+	})
+
+	t.Run("exclusive with client_certificate", func(t *testing.T) {
+		h := &HTTPTransport{
+			HTTPTransport: &reverseproxy.HTTPTransport{},
+			ClientCert:    newTestSelector("^first\\.example\\.test$"),
+			ClientCerts:   []*CertSelector{newTestSelector("^second\\.example\\.test$")},
+		}
+		assertErrorContains(t, h.provisionSelector(ctx), "mutually exclusive")
+	})
+}
+
+func TestApp_ReselectAll(t *testing.T) {
+	initialKey := newTestKey(t)
+	renewedKey := newTestKey(t)
+	initialCert := newTestCertificate(t, "reselect.example.test", initialKey)
+	renewedCert := newTestCertificate(t, "reselect.example.test", renewedKey)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("initial"))),
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("unchanged"))),
+		newFakeStoreLoad(renewedCert, newFakeSigner(renewedKey.Public(), []byte("renewed"))),
+	}
+	withFakeStoreLoads(t, loads...)
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"renewing": {Pattern: "^reselect\\.example\\.test$", Location: "user"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+
+	if rotated := app.cache.reselectAll(t.Context(), app.logger); rotated != 0 {
+		t.Fatalf("expected unchanged store not to rotate, got %d", rotated)
+	}
+	if loads[1].identity.closeCount() != 1 || loads[1].store.closeCount() != 1 {
+		t.Fatal("expected unchanged re-selection resources to be closed")
+	}
+
+	if rotated := app.cache.reselectAll(t.Context(), app.logger); rotated != 1 {
+		t.Fatalf("expected renewed certificate to rotate, got %d", rotated)
+	}
+	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
+		t.Fatal("expected replaced resources to be closed")
+	}
+
+	selector, err := app.selector("renewing")
+	if err != nil {
+		t.Fatalf("selector lookup failed: %v", err)
+	}
+	current, err := selector.currentCertificate()
+	if err != nil {
+		t.Fatalf("current certificate failed: %v", err)
+	}
+	if current.Leaf.SerialNumber.Cmp(renewedCert.SerialNumber) != 0 {
+		t.Fatalf("expected renewed serial %s, got %s", renewedCert.SerialNumber, current.Leaf.SerialNumber)
+	}
+}
+
+func TestApp_NamedSelectorsKeepTheirLoggers(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "shared.example.test", key)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("banking"))),
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("payroll"))),
+	)
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"banking": {Pattern: "^shared\\.example\\.test$", Location: "user"},
+			"payroll": {Pattern: "^shared\\.example\\.test$", Location: "user", LogLevel: "warn"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+
+	banking, payroll := app.Selectors["banking"], app.Selectors["payroll"]
+	if banking.cacheKey == payroll.cacheKey {
+		t.Fatal("expected selectors with different names to use separate cache entries")
+	}
+	for name, selector := range app.Selectors {
+		if got := selector.cacheEntry.selector.logger.Name(); !strings.HasSuffix(got, "selectors."+name) {
+			t.Fatalf("expected the %s entry to log through its selector's logger, got %q", name, got)
+		}
+	}
+}
+
+func TestApp_CacheTTL(t *testing.T) {
+	initialKey := newTestKey(t)
+	renewedKey := newTestKey(t)
+	initialCert := newTestCertificate(t, "ttl.example.test", initialKey)
+	renewedCert := newTestCertificate(t, "ttl.example.test", renewedKey)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("initial"))),
+		newFakeStoreLoad(renewedCert, newFakeSigner(renewedKey.Public(), []byte("renewed"))),
+	}
+	withFakeStoreLoads(t, loads...)
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"expiring": {Pattern: "^ttl\\.example\\.test$", Location: "user"},
+		},
+		CacheTTL: caddy.Duration(20 * time.Millisecond),
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+	if err := app.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer app.Stop()
+
+	selector, err := app.selector("expiring")
+	if err != nil {
+		t.Fatalf("selector lookup failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := selector.currentCertificate()
+		if err != nil {
+			t.Fatalf("current certificate failed: %v", err)
+		}
+		if current.Leaf.SerialNumber.Cmp(renewedCert.SerialNumber) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected renewed serial %s, still got %s", renewedCert.SerialNumber, current.Leaf.SerialNumber)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
+		t.Fatal("expected replaced resources to be closed")
+	}
+}
+
+func TestCertificateCache_StaleEntries(t *testing.T) {
+	now := time.Now()
+	fresh := &cachedCert{validatedAt: now.Add(-time.Minute)}
+	stale := &cachedCert{validatedAt: now.Add(-time.Hour)}
+	retried := &cachedCert{validatedAt: now.Add(-time.Hour)}
+	cache := newCertificateCache()
+	cache.entries = map[string]*cachedCert{"fresh": fresh, "stale": stale, "retried": retried}
+	gone := &cachedCert{}
+	attempts := map[*cachedCert]time.Time{retried: now.Add(-10 * time.Minute), gone: now}
+
+	due, wait := cache.staleEntries(30*time.Minute, attempts, now)
+	if len(due) != 1 || due[0] != stale {
+		t.Fatalf("expected only the stale entry, got %d entries", len(due))
+	}
+	if wait != 20*time.Minute {
+		t.Fatalf("expected to wait for the retried entry, got %v", wait)
+	}
+	if _, ok := attempts[gone]; ok {
+		t.Fatal("expected the attempt of an uncached entry to be forgotten")
+	}
+}
+
+func TestApp_NegativeCacheTTL(t *testing.T) {
+	app := &App{CacheTTL: caddy.Duration(-time.Second)}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	assertErrorContains(t, app.Provision(ctx), "cache_ttl must not be negative")
+}
+
+func TestApp_RejectedConfigKeepsStoreOpenLimit(t *testing.T) {
+	t.Cleanup(func() { storeOpens.setLimit(0) })
+
+	app := &App{MaxConcurrentStoreOpens: 2, ReselectSchedule: "not a schedule"}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	assertErrorContains(t, app.Provision(ctx), "reselect_schedule")
+	if storeOpens.slots != nil {
+		t.Fatalf("expected a rejected config to leave the store open limit unset, got %d", cap(storeOpens.slots))
+	}
+}
+
+func TestApp_ReloadReusesUnchangedSelectors(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "reload.example.test", key)
+	otherCert := newTestCertificate(t, "other.example.test", key)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))),
+		newFakeStoreLoad(otherCert, newFakeSigner(key.Public(), []byte("ok"))),
+	}
+	provider := withFakeStoreLoads(t, loads...)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	oldApp := &App{
+		Selectors: map[string]*CertSelector{
+			"kept": {Pattern: "^reload\\.example\\.test$", Location: "user"},
+		},
+	}
+	if err := oldApp.Provision(ctx); err != nil {
+		t.Fatalf("Provision of old config failed: %v", err)
+	}
+
+	newApp := &App{
+		Selectors: map[string]*CertSelector{
+			"kept":  {Pattern: "^reload\\.example\\.test$", Location: "user"},
+			"added": {Pattern: "^other\\.example\\.test$", Location: "user"},
+		},
+	}
+	newApp.cache = newCertificateCache()
+	newApp.cache.previous = oldApp.cache
+	for name, selector := range newApp.Selectors {
+		selector.name = name
+		if err := selector.provision(ctx, newApp); err != nil {
+			t.Fatalf("provisioning selector '%s' failed: %v", name, err)
+		}
+	}
+
+	if provider.openCount() != 2 {
+		t.Fatalf("expected unchanged selector to be adopted without opening the store, got %d opens", provider.openCount())
+	}
+	if newApp.Selectors["kept"].cacheEntry != oldApp.Selectors["kept"].cacheEntry {
+		t.Fatal("expected unchanged selector to share the old config's cache entry")
+	}
+
+	if err := oldApp.Cleanup(); err != nil {
+		t.Fatalf("Cleanup of old config failed: %v", err)
+	}
+	if loads[0].identity.closeCount() != 0 || loads[0].store.closeCount() != 0 {
+		t.Fatal("adopted resources closed when the old config was cleaned up")
+	}
+	if _, err := newApp.Selectors["kept"].currentCertificate(); err != nil {
+		t.Fatalf("adopted certificate unusable after old cleanup: %v", err)
+	}
+
+	if err := newApp.Cleanup(); err != nil {
+		t.Fatalf("Cleanup of new config failed: %v", err)
+	}
+	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
+		t.Fatalf("expected adopted resources to close once, got identity=%d store=%d", loads[0].identity.closeCount(), loads[0].store.closeCount())
+	}
+}
+
+func TestApp_DisableCache(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "uncached.example.test", key)
+	var loads []*fakeStoreLoad
+	for range 3 {
+		loads = append(loads, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+	}
+	provider := withFakeStoreLoads(t, loads...)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	newSelector := func() *CertSelector {
+		return &CertSelector{Pattern: "^uncached\\.example\\.test$", Location: "user", DisableCache: true}
+	}
+	oldApp := &App{Selectors: map[string]*CertSelector{"a": newSelector(), "b": newSelector()}}
+	if err := oldApp.Provision(ctx); err != nil {
+		t.Fatalf("Provision of old config failed: %v", err)
+	}
+	defer oldApp.Cleanup()
+	if oldApp.Selectors["a"].cacheEntry == oldApp.Selectors["b"].cacheEntry {
+		t.Fatal("expected selectors with disable_cache not to share a cache entry")
+	}
+
+	newApp := &App{Selectors: map[string]*CertSelector{"a": newSelector()}}
+	newApp.cache = newCertificateCache()
+	newApp.cache.previous = oldApp.cache
+	if err := newApp.Selectors["a"].provision(ctx, newApp); err != nil {
+		t.Fatalf("provisioning selector failed: %v", err)
+	}
+	defer newApp.Cleanup()
+
+	if provider.openCount() != 3 {
+		t.Fatalf("expected every provision to open the store, got %d opens", provider.openCount())
+	}
+}
+
+func TestApp_SelectorLoggers(t *testing.T) {
+	key := newTestKey(t)
+	primary := newTestCertificate(t, "logged.example.test", key)
+	backup := newTestCertificate(t, "backup.example.test", key)
+	load := newFakeStoreLoadWithIdentities(
+		newFakeIdentity(primary, newFakeSigner(key.Public(), []byte("ok"))),
+		newFakeIdentity(backup, newFakeSigner(key.Public(), []byte("ok"))),
+	)
+	withFakeStoreLoads(t, load, load)
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"banking": {
+				Pattern:  "^logged\\.example\\.test$",
+				Location: "user",
+				LogLevel: "warn",
+				Fallback: &CertSelector{Pattern: "^backup\\.example\\.test$", Location: "user"},
+			},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+
+	selector := app.Selectors["banking"]
+	if !strings.HasSuffix(selector.logger.Name(), "selectors.banking") {
+		t.Fatalf("expected a logger named after the selector, got %q", selector.logger.Name())
+	}
+	if selector.logger.Core().Enabled(zapcore.InfoLevel) {
+		t.Fatal("expected log_level to drop entries below warn")
+	}
+	if !strings.HasSuffix(selector.Fallback.logger.Name(), "selectors.banking.fallback") {
+		t.Fatalf("expected the fallback logger to be named after its selector, got %q", selector.Fallback.logger.Name())
+	}
+
+	invalid := &CertSelector{Pattern: "^x$", LogLevel: "loud"}
+	assertErrorContains(t, invalid.validate(), "invalid log_level")
+}
+
+func TestApp_SelectorDefaults(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "defaults.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))), newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	app := &App{
+		Defaults: &SelectorDefaults{Location: "user", MaxCandidates: 5, LogLevel: "warn"},
+		Selectors: map[string]*CertSelector{
+			"inherits":  {Pattern: "^defaults\\.example\\.test$"},
+			"overrides": {Pattern: "^defaults\\.example\\.test$", Location: "machine", DisableCache: true},
+		},
+	}
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+
+	inherits, overrides := app.Selectors["inherits"], app.Selectors["overrides"]
+	if inherits.Location != "user" || inherits.MaxCandidates != 5 || inherits.LogLevel != "warn" {
+		t.Fatalf("expected the selector to inherit the defaults: %+v", inherits)
+	}
+	if overrides.Location != "machine" || overrides.MaxCandidates != 5 {
+		t.Fatalf("expected the selector's own location to win: %+v", overrides)
+	}
+}
@@ -0,0 +1,208 @@
+package certstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// StoreLocation identifies the certificate store a Backend opens.
+type StoreLocation string
+
+// Store locations.
+const (
+	// LocationUser is the store of the user running Caddy (CurrentUser on
+	// Windows, the login keychain on macOS).
+	LocationUser StoreLocation = "user"
+
+	// LocationSystem is the machine-wide store (LocalMachine on Windows,
+	// the System keychain on macOS).
+	LocationSystem StoreLocation = "system"
+
+	// LocationNSS is an NSS shared database, by default the user's
+	// ~/.pki/nssdb used by Chrome, Firefox and many Linux distributions.
+	// Named stores of this location are database directories.
+	LocationNSS StoreLocation = "nssdb"
+
+	// LocationPIV is the PIV application of the YubiKeys connected. Named
+	// stores of this location are slots, such as "9a".
+	LocationPIV StoreLocation = "piv"
+
+	// LocationDirectory is a directory of PEM and PKCS#12 files, available
+	// in every build. Named stores of this location are the directory.
+	LocationDirectory StoreLocation = "directory"
+)
+
+// Store is an opened certificate store.
+type Store interface {
+	// Identities returns the certificates in the store that have a private
+	// key. The caller closes every returned identity.
+	Identities() ([]Identity, error)
+
+	// Close releases the store.
+	Close()
+}
+
+// Identity is a certificate together with its private key.
+type Identity interface {
+	// Certificate returns the leaf certificate.
+	Certificate() (*x509.Certificate, error)
+
+	// CertificateChain returns the leaf certificate followed by its
+	// intermediates.
+	CertificateChain() ([]*x509.Certificate, error)
+
+	// Signer returns the private key. It stays usable until Close.
+	Signer() (crypto.Signer, error)
+
+	// Close releases the identity and its private key.
+	Close()
+}
+
+// PINIdentity is implemented by identities whose private key is on a token
+// that can be unlocked with a configured PIN instead of prompting for it.
+type PINIdentity interface {
+	SetPIN(pin string) error
+}
+
+// IntermediateStore is implemented by stores that can list the intermediate
+// CA certificates installed alongside their identities.
+type IntermediateStore interface {
+	Intermediates() ([]*x509.Certificate, error)
+}
+
+// ChainBuilder is implemented by stores that can build the chain of a
+// certificate from the CA certificates of the OS, for identities stored
+// without their chain.
+type ChainBuilder interface {
+	BuildChain(leaf *x509.Certificate) ([]*x509.Certificate, error)
+}
+
+// Importer is implemented by stores that can import a PKCS#12 (PFX)
+// identity.
+type Importer interface {
+	Import(pfx []byte, password string) error
+}
+
+// WritableBackend is implemented by backends that can open a store for
+// writing, as needed to import identities.
+type WritableBackend interface {
+	OpenWritableStore(location StoreLocation) (Store, error)
+}
+
+// NamedStoreBackend is implemented by backends that can open a store other
+// than the default one of a location: on Windows a logical store such as
+// WebHosting, on macOS a keychain file given by its path.
+type NamedStoreBackend interface {
+	OpenNamedStore(location StoreLocation, name string) (Store, error)
+}
+
+// Backend opens certificate stores. The default backend uses the OS stores;
+// tests install a fake one with SetBackend, for example from the
+// certstoretest package.
+type Backend interface {
+	OpenStore(location StoreLocation) (Store, error)
+}
+
+// BackendFunc adapts a function to the Backend interface.
+type BackendFunc func(location StoreLocation) (Store, error)
+
+// OpenStore calls f(location).
+func (f BackendFunc) OpenStore(location StoreLocation) (Store, error) {
+	return f(location)
+}
+
+var (
+	backendMu sync.RWMutex
+	backend   Backend = osBackend{}
+)
+
+// SetBackend replaces the backend used to open certificate stores and returns
+// a function restoring the previous one. It is intended for tests.
+func SetBackend(b Backend) (restore func()) {
+	backendMu.Lock()
+	previous := backend
+	backend = b
+	backendMu.Unlock()
+
+	return func() {
+		backendMu.Lock()
+		backend = previous
+		backendMu.Unlock()
+	}
+}
+
+// openCertStore opens the store at location with the current backend, or the
+// logical store name of location when name is set.
+func openCertStore(location StoreLocation, name string) (Store, error) {
+	backendMu.RLock()
+	b := backend
+	backendMu.RUnlock()
+
+	if name == "" {
+		return b.OpenStore(location)
+	}
+	named, ok := b.(NamedStoreBackend)
+	if !ok {
+		return nil, fmt.Errorf("certificate store backend cannot open logical store '%s'", name)
+	}
+	return named.OpenNamedStore(location, name)
+}
+
+// openPortableStore opens the stores of the locations available on every
+// platform, NSS databases, PIV tokens and file directories, and reports
+// whether location is one of them. name is the database directory, the slot
+// or the file directory.
+func openPortableStore(location StoreLocation, name string, writable bool) (Store, bool, error) {
+	switch location {
+	case LocationNSS:
+		store, err := openNSSStore(name, writable)
+		return store, true, err
+	case LocationPIV:
+		if writable {
+			return nil, true, fmt.Errorf("cannot import identities into PIV tokens")
+		}
+		store, err := openPIVStore(name)
+		return store, true, err
+	case LocationDirectory:
+		if writable {
+			return nil, true, fmt.Errorf("cannot import identities into a directory; copy their files into it")
+		}
+		store, err := openDirectoryStore(name)
+		return store, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// importIdentity imports a PKCS#12 identity into the store at location with
+// the current backend.
+func importIdentity(location StoreLocation, pfx []byte, password string) error {
+	backendMu.RLock()
+	b := backend
+	backendMu.RUnlock()
+
+	writable, ok := b.(WritableBackend)
+	if !ok {
+		return fmt.Errorf("certificate store backend cannot import identities")
+	}
+	release, err := storeOpens.acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	store, err := writable.OpenWritableStore(location)
+	if err != nil {
+		return translatePlatformError(err)
+	}
+	defer store.Close()
+
+	importer, ok := store.(Importer)
+	if !ok {
+		return fmt.Errorf("certificate store cannot import identities")
+	}
+	return translatePlatformError(importer.Import(pfx, password))
+}
@@ -0,0 +1,94 @@
+//go:build windows || (darwin && cgo)
+
+package certstore
+
+import (
+	"crypto/x509"
+	"runtime"
+
+	"github.com/tailscale/certstore"
+)
+
+// systemKeychainPath is the macOS System keychain, which the system location
+// searches on its own instead of the keychain search list.
+const systemKeychainPath = "/Library/Keychains/System.keychain"
+
+// osBackend opens the Windows certificate stores or the macOS keychains.
+type osBackend struct{}
+
+func (osBackend) OpenStore(location StoreLocation) (Store, error) {
+	if store, ok, err := openPortableStore(location, "", false); ok {
+		return store, err
+	}
+	if runtime.GOOS == "darwin" && location == LocationSystem {
+		return openNamedOSStore(location, systemKeychainPath)
+	}
+	return openOSStore(location, certstore.ReadOnly)
+}
+
+func (osBackend) OpenWritableStore(location StoreLocation) (Store, error) {
+	if store, ok, err := openPortableStore(location, "", true); ok {
+		return store, err
+	}
+	return openOSStore(location)
+}
+
+// OpenNamedStore opens a Windows logical store by name, a macOS keychain
+// file by path, an NSS database by directory or a PIV slot.
+func (osBackend) OpenNamedStore(location StoreLocation, name string) (Store, error) {
+	if store, ok, err := openPortableStore(location, name, false); ok {
+		return store, err
+	}
+	return openNamedOSStore(location, name)
+}
+
+func openOSStore(location StoreLocation, permissions ...certstore.StorePermission) (Store, error) {
+	storeLocation := certstore.System
+	if location == LocationUser {
+		storeLocation = certstore.User
+	}
+	store, err := certstore.Open(storeLocation, permissions...)
+	if err != nil {
+		return nil, err
+	}
+	return osStore{store: store, location: location}, nil
+}
+
+// osStore adapts a certstore.Store. Its identities are returned unwrapped so
+// that the platform code can reach their OS handles.
+type osStore struct {
+	store    certstore.Store
+	location StoreLocation
+}
+
+func (s osStore) Identities() ([]Identity, error) {
+	identities, err := s.store.Identities()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Identity, len(identities))
+	for i, identity := range identities {
+		out[i] = identity
+	}
+	return out, nil
+}
+
+func (s osStore) Intermediates() ([]*x509.Certificate, error) {
+	return osIntermediates(s.location)
+}
+
+func (s osStore) Import(pfx []byte, password string) error {
+	return s.store.Import(pfx, password)
+}
+
+func (s osStore) Close() {
+	s.store.Close()
+}
+
+// Interface guards
+var (
+	_ IntermediateStore = osStore{}
+	_ Importer          = osStore{}
+	_ WritableBackend   = osBackend{}
+	_ NamedStoreBackend = osBackend{}
+)
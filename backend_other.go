@@ -0,0 +1,46 @@
+//go:build !windows && !(darwin && cgo)
+
+package certstore
+
+import (
+	"errors"
+	"runtime"
+)
+
+// errUnsupportedPlatform is returned when opening an OS store on a platform
+// without one, or on macOS in a build without cgo, which the keychain needs.
+var errUnsupportedPlatform = errors.New(unsupportedPlatformMessage())
+
+func unsupportedPlatformMessage() string {
+	if runtime.GOOS == "darwin" {
+		return "macOS keychains need a build with cgo enabled; use the directory location instead"
+	}
+	return "OS certificate stores are only supported on macOS and Windows, not " + runtime.GOOS + "; use the directory location instead"
+}
+
+// osBackend has no OS store to open on this platform, only the locations
+// available everywhere.
+type osBackend struct{}
+
+func (osBackend) OpenStore(location StoreLocation) (Store, error) {
+	if store, ok, err := openPortableStore(location, "", false); ok {
+		return store, err
+	}
+	return nil, errUnsupportedPlatform
+}
+
+func (osBackend) OpenWritableStore(location StoreLocation) (Store, error) {
+	if store, ok, err := openPortableStore(location, "", true); ok {
+		return store, err
+	}
+	return nil, errUnsupportedPlatform
+}
+
+// OpenNamedStore opens the NSS database or file directory name, or the PIV
+// slot name.
+func (osBackend) OpenNamedStore(location StoreLocation, name string) (Store, error) {
+	if store, ok, err := openPortableStore(location, name, false); ok {
+		return store, err
+	}
+	return nil, errUnsupportedPlatform
+}
@@ -2,23 +2,61 @@ package certstore
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/tailscale/certstore"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var (
-	certCache  = make(map[string]*cachedCert)
-	cacheMutex sync.Mutex
-)
+// certificateCache holds the certificates loaded from OS certificate stores,
+// keyed by a hash of the selector configuration. It is owned by the certstore
+// app.
+type certificateCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedCert
+
+	// refs counts the references this cache holds on each entry. An entry
+	// may also be referenced by the cache of another config while Caddy
+	// reloads, so its own refCount can be higher.
+	refs map[string]int
+
+	// owners lists, for each entry, the module path of the selector that
+	// took each of this cache's references, to diagnose references left
+	// behind after a config is cleaned up.
+	owners map[string][]string
+
+	// previous is the cache of the running config while a new config is
+	// provisioned. Entries for unchanged selectors are adopted from it.
+	previous *certificateCache
+
+	// reuseLogs throttles the debug log written each time an entry is
+	// reused.
+	reuseLogs *logThrottle
+}
+
+func newCertificateCache() *certificateCache {
+	return &certificateCache{
+		entries:   make(map[string]*cachedCert),
+		refs:      make(map[string]int),
+		owners:    make(map[string][]string),
+		reuseLogs: newLogThrottle(reuseLogInterval),
+	}
+}
 
 // cachedCert holds a cached certificate along with its OS resources
 // and a reference count for tracking active users.
@@ -27,12 +65,114 @@ type cachedCert struct {
 
 	cert     tls.Certificate
 	signer   crypto.Signer
-	identity certstore.Identity
-	store    certstore.Store
+	identity Identity
+	store    Store
 	selector selectorSnapshot
 
 	refCount int32
 	cacheKey string
+
+	// loadedAt is when the cached certificate was loaded from the store,
+	// and validatedAt when the store last confirmed it by a load, refresh
+	// or re-selection.
+	loadedAt    time.Time
+	validatedAt time.Time
+
+	// generation counts the times the cached certificate was replaced by a
+	// different one.
+	generation atomic.Uint64
+
+	// interactionDeniedAt is when the keychain last refused access to the
+	// private key because it would need user interaction.
+	interactionDeniedAt time.Time
+
+	// ocsp tracks the stapled OCSP response when the selector fetches one.
+	ocsp        ocspState
+	ocspRefresh chan struct{}
+
+	// done is closed when the entry is removed from the cache, stopping
+	// its background maintenance.
+	done chan struct{}
+
+	// handshakes counts the handshakes given the current certificate whose
+	// signer has not signed yet. retired keeps the resources of replaced
+	// certificates open until their handshakes have signed or
+	// retiredCertGrace has passed.
+	handshakes *atomic.Int32
+	retired    []*retiredCert
+
+	// events emits the entry's lifecycle events, when the events app is
+	// configured.
+	events atomic.Pointer[eventEmitter]
+}
+
+// retiredCertGrace bounds how long the resources of a replaced certificate
+// are kept open for handshakes that were given it before the swap.
+const retiredCertGrace = time.Minute
+
+// errCertificateReplaced is returned when signing for a certificate that was
+// replaced and whose resources were released.
+var errCertificateReplaced = errors.New("client certificate was replaced and its private key released")
+
+// retiredCert holds the resources of a certificate swapped out of a cache
+// entry while handshakes given it may still sign.
+type retiredCert struct {
+	leafThumbprint string
+	signer         crypto.Signer
+	identity       Identity
+	store          Store
+	handshakes     *atomic.Int32
+}
+
+func newCachedCert(cacheKey string, selector selectorSnapshot, cert tls.Certificate, signer crypto.Signer, identity Identity, store Store) *cachedCert {
+	now := time.Now()
+	cached := &cachedCert{
+		loadedAt:    now,
+		validatedAt: now,
+		cert:        cert,
+		signer:      signer,
+		identity:    identity,
+		store:       store,
+		selector:    selector,
+		refCount:    1,
+		cacheKey:    cacheKey,
+		done:        make(chan struct{}),
+		handshakes:  new(atomic.Int32),
+	}
+	if selector.fetchOCSP {
+		cached.ocspRefresh = make(chan struct{}, 1)
+	}
+	cached.setEvents(selector.events)
+	cached.recordTimes()
+	return cached
+}
+
+// recordTimes exports the load and validation times of the entry. The caller
+// must hold cached.mu unless the entry is not shared yet.
+func (cached *cachedCert) recordTimes() {
+	labels := cached.metricLabels()
+	certstoreMetrics.entryLoaded.With(labels).Set(float64(cached.loadedAt.Unix()))
+	certstoreMetrics.entryValidated.With(labels).Set(float64(cached.validatedAt.Unix()))
+}
+
+func (cached *cachedCert) metricLabels() prometheus.Labels {
+	return prometheus.Labels{"cache_key": thumbprintPrefix(cached.cacheKey), "location": cached.selector.location}
+}
+
+// start fetches the initial OCSP staple and starts background maintenance
+// for a newly cached certificate.
+func (cached *cachedCert) start() {
+	if cached.selector.fetchOCSP {
+		cached.updateOCSP()
+		go cached.maintainOCSP()
+	}
+	if cached.selector.refresh > 0 {
+		go cached.maintainSelection()
+	}
+	if cached.selector.rollover > 0 {
+		go cached.maintainRollover()
+	}
+	go cached.watchExpiry()
 }
 
 func makeLeafThumbprint(cert *x509.Certificate) string {
@@ -40,14 +180,69 @@ func makeLeafThumbprint(cert *x509.Certificate) string {
 	return fmt.Sprintf("%x", thumbprint)
 }
 
-// makeCacheKey generates a selector-aware cache key from the resolved selector
-// and the initially loaded certificate thumbprint.
-func makeCacheKey(selector selectorSnapshot, cert *x509.Certificate) string {
+// makeCacheKey hashes the resolved selector configuration. Selectors with the
+// same configuration select the same certificate, so they share a cache entry
+// without opening the store again, including across config reloads. The
+// selector name and log level are part of the key, so that an entry logs
+// through the logger of the selectors sharing it.
+func makeCacheKey(selector selectorSnapshot) string {
 	h := sha256.New()
 	writeCacheKeyPart(h, selector.patternString)
+	writeCacheKeyPart(h, selector.matchMode)
 	writeCacheKeyPart(h, selector.field)
+	for _, matcher := range selector.allOf {
+		writeCacheKeyPart(h, "all_of "+matcher.field+" "+matcher.pattern.String())
+	}
+	for _, matcher := range selector.anyOf {
+		writeCacheKeyPart(h, "any_of "+matcher.field+" "+matcher.pattern.String())
+	}
+	writeCacheKeyPart(h, selector.template.String())
+	writeCacheKeyPart(h, fmt.Sprintf("%x", selector.thumbprint))
+	for _, usage := range selector.extKeyUsages {
+		writeCacheKeyPart(h, usage.String())
+	}
+	writeCacheKeyPart(h, strconv.FormatBool(selector.ekuChaining))
+	writeCacheKeyPart(h, selector.keyType)
+	writeCacheKeyPart(h, strconv.Itoa(selector.minKeyBits))
+	writeCacheKeyPart(h, selector.keyProvider)
+	writeCacheKeyPart(h, selector.keyContainer)
 	writeCacheKeyPart(h, selector.location)
-	writeCacheKeyPart(h, makeLeafThumbprint(cert))
+	writeCacheKeyPart(h, selector.storeName)
+	writeCacheKeyPart(h, selector.pin)
+	if selector.impersonate != nil {
+		writeCacheKeyPart(h, selector.impersonate.User)
+		writeCacheKeyPart(h, selector.impersonate.Password)
+	}
+	writeCacheKeyPart(h, strconv.FormatBool(selector.fetchOCSP))
+	writeCacheKeyPart(h, selector.refresh.String())
+	writeCacheKeyPart(h, selector.prefer)
+	writeCacheKeyPart(h, selector.selection)
+	writeCacheKeyPart(h, selector.notBeforeSkew.String())
+	writeCacheKeyPart(h, selector.rollover.String())
+	writeCacheKeyPart(h, selector.devCommonName)
+	writeCacheKeyPart(h, selector.fallbackFile)
+	writeCacheKeyPart(h, selector.fallbackKey)
+	writeCacheKeyPart(h, selector.requireSCT)
+	for _, ca := range selector.acceptableCAs {
+		writeCacheKeyPart(h, fmt.Sprintf("acceptable_ca %x", ca))
+	}
+	writeCacheKeyPart(h, selector.cacheNonce)
+	writeCacheKeyPart(h, selector.name)
+	writeCacheKeyPart(h, selector.logLevel)
+	writeCacheKeyPart(h, selector.interactionPolicy)
+	writeCacheKeyPart(h, selector.interactionRetryTimeout.String())
+	writeCacheKeyPart(h, strconv.FormatBool(selector.intermediatesFromStore))
+	writeCacheKeyPart(h, strconv.FormatBool(selector.verifyChainLinkage))
+	writeCacheKeyPart(h, strconv.FormatBool(selector.sendRoot))
+	for _, intermediate := range selector.intermediateFiles {
+		writeCacheKeyPart(h, makeLeafThumbprint(intermediate))
+	}
+	writeCacheKeyPart(h, selector.chainPolicy)
+	for _, name := range selector.chainRootCommonNames {
+		writeCacheKeyPart(h, name)
+	}
+	writeCacheKeyPart(h, selector.pinnedRootName)
+	writeCacheKeyPart(h, fmt.Sprintf("%x", selector.pinnedRootSPKI))
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
@@ -59,83 +254,186 @@ func writeCacheKeyPart(w io.Writer, part string) {
 // getCachedCertificate attempts to retrieve a cached certificate or loads it
 // if not present. It increments the reference count for the certificate.
 // Returns the certificate, its cache key, and any error encountered.
-func (cs *CertSelector) getCachedCertificate() (tls.Certificate, string, error) {
+func (cs *CertSelector) getCachedCertificate(ctx context.Context) (tls.Certificate, string, error) {
 	var emptyCert tls.Certificate
 
 	selector := cs.snapshot()
+	cacheKey := makeCacheKey(selector)
 
-	// Load the certificate to determine its selector-aware cache key.
-	cert, store, identity, err := selector.loadCertificateWithResources()
+	cached, err := cs.cache.acquire(ctx, cacheKey, selector, cs.owner)
 	if err != nil {
 		return emptyCert, "", err
 	}
 
-	signer, err := extractCertificateSigner(cert)
+	cs.cacheKey = cacheKey
+	cs.cacheEntry = cached
+
+	currentCert, err := cached.currentCertificate()
 	if err != nil {
-		closeCertificateResources(identity, store)
 		return emptyCert, "", err
 	}
-	cert.PrivateKey = nil
 
-	cacheKey := makeCacheKey(selector, cert.Leaf)
+	return currentCert, cacheKey, nil
+}
 
-	cacheMutex.Lock()
-	cached, exists := certCache[cacheKey]
-	if exists {
-		// Certificate already cached - close the newly loaded resources.
+// acquire returns the cache entry for cacheKey and takes a reference to it on
+// behalf of owner. An entry already held by this cache, or by the cache of the
+// config being replaced, is reused without opening the store. Otherwise the
+// certificate is loaded from the store and cached.
+func (c *certificateCache) acquire(ctx context.Context, cacheKey string, selector selectorSnapshot, owner string) (*cachedCert, error) {
+	if cached := c.reuse(cacheKey, owner, selector.logger); cached != nil {
+		cached.setEvents(selector.events)
+		return cached, nil
+	}
+
+	cert, store, identity, err := selector.loadCertificateWithResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := extractCertificateSigner(cert)
+	if err != nil {
 		closeCertificateResources(identity, store)
+		return nil, err
+	}
+	cert.PrivateKey = nil
 
-		// Increment reference count and return cached certificate.
+	c.mu.Lock()
+	if cached, exists := c.entries[cacheKey]; exists {
+		// Another selector with the same configuration was cached while
+		// loading - close the newly loaded resources.
 		atomic.AddInt32(&cached.refCount, 1)
+		c.refs[cacheKey]++
+		c.owners[cacheKey] = append(c.owners[cacheKey], owner)
+		c.mu.Unlock()
+		closeCertificateResources(identity, store)
+		cached.setEvents(selector.events)
+		return cached, nil
+	}
+	cached := newCachedCert(cacheKey, selector, cert, signer, identity, store)
+	c.entries[cacheKey] = cached
+	c.refs[cacheKey] = 1
+	c.owners[cacheKey] = []string{owner}
+	c.mu.Unlock()
+
+	if selector.logger != nil {
+		selector.logger.Debug(
+			"cached new certificate",
+			zap.String("cache_key", cacheKey[:16]),
+			zap.String("common_name", cert.Leaf.Subject.CommonName),
+		)
+	}
 
-		if selector.logger != nil {
-			selector.logger.Debug(
-				"reusing cached certificate",
-				zap.String("cache_key", cacheKey[:16]),
-				zap.Int32("ref_count", atomic.LoadInt32(&cached.refCount)),
-			)
-		}
+	cached.mu.RLock()
+	data := cached.eventData()
+	cached.mu.RUnlock()
+	cached.emit(eventLoaded, data)
+
+	cached.start()
+	return cached, nil
+}
+
+// reuse takes a reference to an existing entry for cacheKey on behalf of
+// owner, adopting it from the previous cache when this cache does not hold it
+// yet. It returns nil when the certificate must be loaded from the store.
+func (c *certificateCache) reuse(cacheKey, owner string, logger *zap.Logger) *cachedCert {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, exists := c.entries[cacheKey]
+	if exists {
+		atomic.AddInt32(&cached.refCount, 1)
 	} else {
-		cached = &cachedCert{
-			cert:     cert,
-			signer:   signer,
-			identity: identity,
-			store:    store,
-			selector: selector,
-			refCount: 1,
-			cacheKey: cacheKey,
+		cached = c.previous.retain(cacheKey)
+		if cached == nil {
+			return nil
 		}
-		certCache[cacheKey] = cached
+		c.entries[cacheKey] = cached
+	}
+	c.refs[cacheKey]++
+	c.owners[cacheKey] = append(c.owners[cacheKey], owner)
 
-		if selector.logger != nil {
-			selector.logger.Debug(
-				"cached new certificate",
-				zap.String("cache_key", cacheKey[:16]),
-				zap.String("common_name", cert.Leaf.Subject.CommonName),
-			)
-		}
+	if logger == nil || !logger.Core().Enabled(zapcore.DebugLevel) {
+		return cached
 	}
-	cacheMutex.Unlock()
+	if ok, suppressed := c.reuseLogs.allow(cacheKey); ok {
+		logger.Debug(
+			"reusing cached certificate",
+			zap.String("cache_key", cacheKey[:16]),
+			zap.Bool("from_previous_config", !exists),
+			zap.Int32("ref_count", atomic.LoadInt32(&cached.refCount)),
+			zap.Int("suppressed", suppressed),
+		)
+	}
+	return cached
+}
 
-	cs.cacheKey = cacheKey
-	cs.cacheEntry = cached
+// retain takes a reference to the live entry for cacheKey, or returns nil if
+// there is none. It is safe to call on a nil cache.
+func (c *certificateCache) retain(cacheKey string) *cachedCert {
+	if c == nil {
+		return nil
+	}
 
-	currentCert, err := cached.currentCertificate()
-	if err != nil {
-		return emptyCert, "", err
+	c.mu.Lock()
+	cached, exists := c.entries[cacheKey]
+	c.mu.Unlock()
+	if !exists {
+		return nil
 	}
 
-	return currentCert, cacheKey, nil
+	// Never resurrect an entry whose last reference is being released.
+	for {
+		count := atomic.LoadInt32(&cached.refCount)
+		if count <= 0 {
+			return nil
+		}
+		if atomic.CompareAndSwapInt32(&cached.refCount, count, count+1) {
+			return cached
+		}
+	}
 }
 
 func (cs *CertSelector) currentCertificate() (tls.Certificate, error) {
 	if cs.cacheEntry == nil {
 		return tls.Certificate{}, fmt.Errorf("client certificate cache entry is not initialized")
 	}
+	if cs.interactionPolicy() == interactionFallback && cs.cacheEntry.interactionDeniedRecently() {
+		return cs.Fallback.currentCertificate()
+	}
 	return cs.cacheEntry.currentCertificate()
 }
 
+// handshakeCertificate is currentCertificate for a TLS handshake: if the
+// certificate is replaced before the handshake signs, its private key stays
+// available to the handshake.
+func (cs *CertSelector) handshakeCertificate() (tls.Certificate, error) {
+	if cs.cacheEntry == nil {
+		return tls.Certificate{}, fmt.Errorf("client certificate cache entry is not initialized")
+	}
+	if cs.interactionPolicy() == interactionFallback && cs.cacheEntry.interactionDeniedRecently() {
+		return cs.Fallback.handshakeCertificate()
+	}
+	return cs.cacheEntry.certificate(true)
+}
+
+// certificateGeneration changes whenever the selector's cached certificate
+// is replaced by a different one.
+func (cs *CertSelector) certificateGeneration() uint64 {
+	if cs.cacheEntry == nil {
+		return 0
+	}
+	return cs.cacheEntry.generation.Load()
+}
+
 func (cached *cachedCert) currentCertificate() (tls.Certificate, error) {
+	return cached.certificate(false)
+}
+
+// certificate returns a copy of the cached certificate whose private key
+// signs with the cached signer. With handshake set, the signer counts as a
+// pending handshake of the certificate until it signs or is finished.
+func (cached *cachedCert) certificate(handshake bool) (tls.Certificate, error) {
 	cached.mu.RLock()
 	defer cached.mu.RUnlock()
 
@@ -145,12 +443,17 @@ func (cached *cachedCert) currentCertificate() (tls.Certificate, error) {
 		return tls.Certificate{}, err
 	}
 
-	cert.PrivateKey = &refreshingSigner{
+	signer := &refreshingSigner{
 		entry:             cached,
 		expectedPublicKey: expectedPublicKey,
 		leafSerial:        cert.Leaf.SerialNumber.String(),
 		leafThumbprint:    makeLeafThumbprint(cert.Leaf),
 	}
+	if handshake {
+		signer.handshakes = cached.handshakes
+		signer.handshakes.Add(1)
+	}
+	cert.PrivateKey = signer
 	return cert, nil
 }
 
@@ -198,6 +501,30 @@ type refreshingSigner struct {
 	expectedPublicKey crypto.PublicKey
 	leafSerial        string
 	leafThumbprint    string
+
+	// handshakes, when set, counts the signer as a pending handshake of its
+	// certificate until finished.
+	handshakes *atomic.Int32
+	finished   atomic.Bool
+}
+
+// finish ends the signer's handshake. When its certificate was replaced and
+// this was the last pending handshake, the certificate's resources are closed.
+func (s *refreshingSigner) finish() {
+	if s.handshakes == nil || !s.finished.CompareAndSwap(false, true) {
+		return
+	}
+	if s.handshakes.Add(-1) == 0 {
+		s.entry.closeRetired(s.handshakes, false)
+	}
+}
+
+// finishHandshake finishes the handshake of a certificate that will not be
+// presented.
+func finishHandshake(cert tls.Certificate) {
+	if signer, ok := cert.PrivateKey.(*refreshingSigner); ok {
+		signer.finish()
+	}
 }
 
 func (s *refreshingSigner) Public() crypto.PublicKey {
@@ -205,10 +532,23 @@ func (s *refreshingSigner) Public() crypto.PublicKey {
 }
 
 func (s *refreshingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	defer s.finish()
+
 	sig, err := s.signCurrent(rand, digest, opts)
 	if err == nil {
 		return sig, nil
 	}
+	if errors.Is(err, errCertificateReplaced) {
+		// Reloading the store cannot bring back the replaced key.
+		return nil, err
+	}
+	recordAccessDenied(s.entry.selector.logger, s.entry.selector.location, "sign", err)
+	if errors.Is(err, ErrInteractionNotAllowed) {
+		// Reloading the identity cannot help; apply the interaction policy.
+		return s.entry.handleInteractionDenied(func() ([]byte, error) {
+			return s.signCurrent(rand, digest, opts)
+		}, err)
+	}
 	originalErr := err
 
 	canRetry, err := s.entry.refresh(s.expectedPublicKey, s.leafSerial, s.leafThumbprint, originalErr)
@@ -232,17 +572,38 @@ func (s *refreshingSigner) signCurrent(rand io.Reader, digest []byte, opts crypt
 	s.entry.mu.RLock()
 	defer s.entry.mu.RUnlock()
 
-	if s.entry.signer == nil {
+	signer, err := s.entry.signerFor(s.expectedPublicKey, s.leafThumbprint)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(rand, digest, opts)
+	return sig, translatePlatformError(err)
+}
+
+// signerFor returns the signer for the certificate with publicKey and
+// leafThumbprint: the cached signer while the cached certificate has that
+// key, or else the signer of the retired certificate. The caller must hold
+// cached.mu.
+func (cached *cachedCert) signerFor(publicKey crypto.PublicKey, leafThumbprint string) (crypto.Signer, error) {
+	if cached.signer == nil {
 		return nil, fmt.Errorf("client certificate signer is closed")
 	}
-	return s.entry.signer.Sign(rand, digest, opts)
+	if current, err := publicKeysEqual(cached.cert.Leaf.PublicKey, publicKey); err == nil && current {
+		return cached.signer, nil
+	}
+	for _, retired := range cached.retired {
+		if retired.leafThumbprint == leafThumbprint {
+			return retired.signer, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: thumbprint %s", errCertificateReplaced, thumbprintPrefix(leafThumbprint))
 }
 
 func (cached *cachedCert) refresh(expectedPublicKey crypto.PublicKey, oldSerial, oldThumbprint string, originalErr error) (bool, error) {
 	cached.mu.Lock()
 	defer cached.mu.Unlock()
 
-	freshCert, freshStore, freshIdentity, err := cached.selector.loadCertificateWithResources()
+	freshCert, freshStore, freshIdentity, err := cached.selector.loadCertificateWithResources(context.Background())
 	if err != nil {
 		return false, fmt.Errorf("certstore signer failed for certificate serial %s thumbprint %s: refresh failed: original signing error: %w; refresh error: %v",
 			oldSerial, thumbprintPrefix(oldThumbprint), originalErr, err)
@@ -264,13 +625,7 @@ func (cached *cachedCert) refresh(expectedPublicKey crypto.PublicKey, oldSerial,
 	}
 
 	oldCert := cached.cert
-	oldIdentity := cached.identity
-	oldStore := cached.store
-
-	cached.cert = freshCert
-	cached.signer = freshSigner
-	cached.identity = freshIdentity
-	cached.store = freshStore
+	cached.swapResources(freshCert, freshSigner, freshIdentity, freshStore)
 
 	if cached.selector.logger != nil {
 		cached.selector.logger.Warn(
@@ -285,11 +640,201 @@ func (cached *cachedCert) refresh(expectedPublicKey crypto.PublicKey, oldSerial,
 		)
 	}
 
-	closeCertificateResources(oldIdentity, oldStore)
-
 	return mayRetry, nil
 }
 
+// reselect runs the selector against the store again and swaps in the selected
+// certificate when it differs from the cached one. It reports whether the
+// certificate changed.
+func (cached *cachedCert) reselect(ctx context.Context) (bool, error) {
+	freshCert, freshStore, freshIdentity, err := cached.selector.loadCertificateWithResources(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	freshSigner, err := extractCertificateSigner(freshCert)
+	if err != nil {
+		closeCertificateResources(freshIdentity, freshStore)
+		return false, err
+	}
+	freshCert.PrivateKey = nil
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	// The entry was closed while loading, or the store still selects the
+	// cached certificate.
+	if cached.signer == nil || makeLeafThumbprint(freshCert.Leaf) == makeLeafThumbprint(cached.cert.Leaf) {
+		closeCertificateResources(freshIdentity, freshStore)
+		if cached.signer != nil {
+			cached.validatedAt = time.Now()
+			cached.recordTimes()
+		}
+		return false, nil
+	}
+
+	oldCert := cached.cert
+	cached.swapResources(freshCert, freshSigner, freshIdentity, freshStore)
+
+	if cached.selector.logger != nil {
+		cached.selector.logger.Info(
+			"rotated client certificate after re-selection",
+			zap.String("cache_key", thumbprintPrefix(cached.cacheKey)),
+			zap.String("old_serial_number", certificateSerial(oldCert)),
+			zap.String("new_serial_number", certificateSerial(freshCert)),
+			zap.String("old_leaf_thumbprint", thumbprintPrefix(makeLeafThumbprint(oldCert.Leaf))),
+			zap.String("new_leaf_thumbprint", thumbprintPrefix(makeLeafThumbprint(freshCert.Leaf))),
+		)
+	}
+
+	return true, nil
+}
+
+// maintainSelection re-selects the cached certificate every refresh interval
+// of its selector, logging failures, until the cache entry is closed.
+func (cached *cachedCert) maintainSelection() {
+	ticker := time.NewTicker(cached.selector.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cached.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := cached.reselect(context.Background()); err != nil && cached.selector.logger != nil {
+			cached.selector.logger.Error("refreshing client certificate",
+				zap.String("cache_key", thumbprintPrefix(cached.cacheKey)),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// swapResources replaces the cached certificate and its OS resources,
+// retiring the previous ones. The caller must hold cached.mu for writing.
+func (cached *cachedCert) swapResources(cert tls.Certificate, signer crypto.Signer, identity Identity, store Store) {
+	old := &retiredCert{
+		leafThumbprint: makeLeafThumbprint(cached.cert.Leaf),
+		signer:         cached.signer,
+		identity:       cached.identity,
+		store:          cached.store,
+		handshakes:     cached.handshakes,
+	}
+
+	now := time.Now()
+	if makeLeafThumbprint(cert.Leaf) != makeLeafThumbprint(cached.cert.Leaf) {
+		cached.generation.Add(1)
+		cached.loadedAt = now
+		defer cached.emitRotated(old)
+	}
+	cached.validatedAt = now
+	cached.recordTimes()
+	cached.cert = cert
+	cached.signer = signer
+	cached.identity = identity
+	cached.store = store
+	cached.ocsp = ocspState{}
+	cached.scheduleOCSPUpdate()
+
+	cached.retire(old)
+}
+
+// emitRotated emits eventRotated for the replacement of old, from another
+// goroutine since the caller holds cached.mu and may be in a handshake. The
+// caller must hold cached.mu.
+func (cached *cachedCert) emitRotated(old *retiredCert) {
+	data := cached.eventData()
+	data["old_thumbprint"] = old.leafThumbprint
+	go cached.emit(eventRotated, data)
+}
+
+// retire keeps the resources of a replaced certificate open until the
+// handshakes given it have signed, for at most retiredCertGrace, instead of
+// closing them under handshakes still under way. Without pending handshakes
+// they are closed right away. The caller must hold cached.mu for writing.
+func (cached *cachedCert) retire(old *retiredCert) {
+	cached.handshakes = new(atomic.Int32)
+	if old.handshakes.Load() == 0 {
+		closeCertificateResources(old.identity, old.store)
+		return
+	}
+	cached.retired = append(cached.retired, old)
+	time.AfterFunc(retiredCertGrace, func() { cached.closeRetired(old.handshakes, true) })
+}
+
+// closeRetired closes the retired resources whose handshakes are counted by
+// handshakes once none is pending or, with graceOver, regardless.
+func (cached *cachedCert) closeRetired(handshakes *atomic.Int32, graceOver bool) {
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	i := slices.IndexFunc(cached.retired, func(retired *retiredCert) bool {
+		return retired.handshakes == handshakes
+	})
+	if i < 0 || !graceOver && handshakes.Load() > 0 {
+		return
+	}
+	retired := cached.retired[i]
+	cached.retired = slices.Delete(cached.retired, i, i+1)
+	closeCertificateResources(retired.identity, retired.store)
+}
+
+// reselectAll re-runs the selector of every cached certificate, logging
+// failures, and returns how many certificates changed.
+func (c *certificateCache) reselectAll(ctx context.Context, logger *zap.Logger) int {
+	c.mu.Lock()
+	entries := make([]*cachedCert, 0, len(c.entries))
+	for _, cached := range c.entries {
+		entries = append(entries, cached)
+	}
+	c.mu.Unlock()
+
+	rotated := 0
+	for _, cached := range entries {
+		changed, err := cached.reselect(ctx)
+		if err != nil {
+			logger.Error("re-selecting client certificate",
+				zap.String("cache_key", thumbprintPrefix(cached.cacheKey)),
+				zap.Error(err),
+			)
+			continue
+		}
+		if changed {
+			rotated++
+		}
+	}
+	return rotated
+}
+
+// staleEntries returns the entries confirmed by the store, or last attempted
+// as recorded in attempts, at least ttl before now, and how long until the
+// next entry goes stale. Attempts of entries no longer cached are forgotten.
+func (c *certificateCache) staleEntries(ttl time.Duration, attempts map[*cachedCert]time.Time, now time.Time) ([]*cachedCert, time.Duration) {
+	c.mu.Lock()
+	entries := slices.Collect(maps.Values(c.entries))
+	c.mu.Unlock()
+
+	var stale []*cachedCert
+	wait := ttl
+	for _, cached := range entries {
+		cached.mu.RLock()
+		last := cached.validatedAt
+		cached.mu.RUnlock()
+		if attempt := attempts[cached]; attempt.After(last) {
+			last = attempt
+		}
+		if until := last.Add(ttl).Sub(now); until > 0 {
+			wait = min(wait, until)
+		} else {
+			stale = append(stale, cached)
+		}
+	}
+	maps.DeleteFunc(attempts, func(cached *cachedCert, _ time.Time) bool {
+		return !slices.Contains(entries, cached)
+	})
+	return stale, wait
+}
+
 func publicKeysEqual(a, b crypto.PublicKey) (bool, error) {
 	encodedA, err := x509.MarshalPKIXPublicKey(a)
 	if err != nil {
@@ -316,39 +861,158 @@ func thumbprintPrefix(thumbprint string) string {
 	return thumbprint[:16]
 }
 
-// releaseCachedCertificate decrements the reference count for a cached certificate.
-// When the reference count reaches zero, it closes the associated OS resources
-// and removes the certificate from the cache.
-func releaseCachedCertificate(cacheKey string) {
+// release drops owner's reference to a cached certificate and removes it from
+// the cache once the cache holds no more references. When no cache references
+// the certificate anymore, it closes the associated OS resources.
+func (c *certificateCache) release(cacheKey, owner string) {
 	var toClose *cachedCert
 
-	cacheMutex.Lock()
-	cached, exists := certCache[cacheKey]
+	c.mu.Lock()
+	cached, exists := c.entries[cacheKey]
 	if exists {
-		newCount := atomic.AddInt32(&cached.refCount, -1)
-		if newCount <= 0 {
-			delete(certCache, cacheKey)
+		c.refs[cacheKey]--
+		if i := slices.Index(c.owners[cacheKey], owner); i >= 0 {
+			c.owners[cacheKey] = slices.Delete(c.owners[cacheKey], i, i+1)
+		}
+		if c.refs[cacheKey] <= 0 {
+			delete(c.entries, cacheKey)
+			delete(c.refs, cacheKey)
+			delete(c.owners, cacheKey)
+			c.reuseLogs.forget(cacheKey)
+		}
+		if atomic.AddInt32(&cached.refCount, -1) <= 0 {
 			toClose = cached
 		}
 	}
-	cacheMutex.Unlock()
+	c.mu.Unlock()
 
 	if toClose != nil {
 		toClose.close()
 	}
 }
 
+// reportLeaks warns about the references this cache still holds, which all
+// modules should have released once their config was cleaned up, and counts
+// them in the leaked references metric. It returns the number of leaked
+// references.
+func (c *certificateCache) reportLeaks(logger *zap.Logger) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	leaked := 0
+	for cacheKey, refs := range c.refs {
+		if refs <= 0 {
+			continue
+		}
+		location := c.entries[cacheKey].selector.location
+		certstoreMetrics.leakedReferences.WithLabelValues(location).Add(float64(refs))
+		leaked += refs
+		logger.Warn(
+			"certificate cache references not released after config cleanup",
+			zap.String("cache_key", thumbprintPrefix(cacheKey)),
+			zap.String("location", location),
+			zap.Int("references", refs),
+			zap.Strings("owners", c.owners[cacheKey]),
+		)
+	}
+	return leaked
+}
+
+// cachedCertInfo describes a cache entry as reported by the admin API.
+type cachedCertInfo struct {
+	CacheKey     string    `json:"cache_key"`
+	Pattern      string    `json:"pattern"`
+	Field        string    `json:"field"`
+	Location     string    `json:"location"`
+	CommonName   string    `json:"common_name"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotAfter     time.Time `json:"not_after"`
+	References   int32     `json:"references"`
+	LoadedAt     time.Time `json:"loaded_at"`
+	ValidatedAt  time.Time `json:"last_validated"`
+
+	OCSPStatus     string     `json:"ocsp_status,omitempty"`
+	OCSPNextUpdate *time.Time `json:"ocsp_next_update,omitempty"`
+	OCSPError      string     `json:"ocsp_error,omitempty"`
+}
+
+// info returns a description of every cache entry, ordered by cache key.
+func (c *certificateCache) info() []cachedCertInfo {
+	c.mu.Lock()
+	entries := make([]*cachedCert, 0, len(c.entries))
+	for _, cached := range c.entries {
+		entries = append(entries, cached)
+	}
+	c.mu.Unlock()
+
+	infos := make([]cachedCertInfo, 0, len(entries))
+	for _, cached := range entries {
+		infos = append(infos, cached.info())
+	}
+	slices.SortFunc(infos, func(a, b cachedCertInfo) int {
+		return strings.Compare(a.CacheKey, b.CacheKey)
+	})
+	return infos
+}
+
+func (cached *cachedCert) info() cachedCertInfo {
+	cached.mu.RLock()
+	defer cached.mu.RUnlock()
+
+	leaf := cached.cert.Leaf
+	info := cachedCertInfo{
+		CacheKey:     thumbprintPrefix(cached.cacheKey),
+		Pattern:      cached.selector.patternString,
+		Field:        cached.selector.field,
+		Location:     cached.selector.location,
+		CommonName:   leaf.Subject.CommonName,
+		Issuer:       leaf.Issuer.String(),
+		SerialNumber: certificateSerial(cached.cert),
+		NotAfter:     leaf.NotAfter,
+		References:   atomic.LoadInt32(&cached.refCount),
+		LoadedAt:     cached.loadedAt,
+		ValidatedAt:  cached.validatedAt,
+	}
+	if cached.selector.fetchOCSP {
+		info.addOCSPState(cached.ocsp)
+	}
+	return info
+}
+
+func (info *cachedCertInfo) addOCSPState(state ocspState) {
+	if state.err != nil {
+		info.OCSPError = state.err.Error()
+	}
+	if state.thisUpdate.IsZero() {
+		return
+	}
+	info.OCSPStatus = ocspStatusString(state.status)
+	if !state.nextUpdate.IsZero() {
+		nextUpdate := state.nextUpdate
+		info.OCSPNextUpdate = &nextUpdate
+	}
+}
+
 func (cached *cachedCert) close() {
+	close(cached.done)
+
 	cached.mu.Lock()
 	defer cached.mu.Unlock()
 
+	certstoreMetrics.entryLoaded.Delete(cached.metricLabels())
+	certstoreMetrics.entryValidated.Delete(cached.metricLabels())
 	closeCertificateResources(cached.identity, cached.store)
 	cached.identity = nil
 	cached.store = nil
 	cached.signer = nil
+	for _, retired := range cached.retired {
+		closeCertificateResources(retired.identity, retired.store)
+	}
+	cached.retired = nil
 }
 
-func closeCertificateResources(identity certstore.Identity, store certstore.Store) {
+func closeCertificateResources(identity Identity, store Store) {
 	if identity != nil {
 		identity.Close()
 	}
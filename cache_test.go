@@ -7,6 +7,7 @@ import (
 	crand "crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -16,8 +17,6 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
-
-	"github.com/tailscale/certstore"
 )
 
 func TestCertificateCache_SelectorAwareReuseAndRefCounting(t *testing.T) {
@@ -27,7 +26,6 @@ func TestCertificateCache_SelectorAwareReuseAndRefCounting(t *testing.T) {
 	cert := newTestCertificate(t, "cache.example.test", key)
 	loads := []*fakeStoreLoad{
 		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("first"))),
-		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("reused"))),
 		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("separate"))),
 	}
 	provider := withFakeStoreLoads(t, loads...)
@@ -36,15 +34,15 @@ func TestCertificateCache_SelectorAwareReuseAndRefCounting(t *testing.T) {
 	selectorB := newTestSelector("^cache\\.example\\.test$")
 	selectorC := newTestSelector("cache\\.example\\..*")
 
-	_, cacheKeyA, err := selectorA.getCachedCertificate()
+	_, cacheKeyA, err := selectorA.getCachedCertificate(t.Context())
 	if err != nil {
 		t.Fatalf("first selector load failed: %v", err)
 	}
-	_, cacheKeyB, err := selectorB.getCachedCertificate()
+	_, cacheKeyB, err := selectorB.getCachedCertificate(t.Context())
 	if err != nil {
 		t.Fatalf("identical selector load failed: %v", err)
 	}
-	_, cacheKeyC, err := selectorC.getCachedCertificate()
+	_, cacheKeyC, err := selectorC.getCachedCertificate(t.Context())
 	if err != nil {
 		t.Fatalf("different selector load failed: %v", err)
 	}
@@ -55,18 +53,16 @@ func TestCertificateCache_SelectorAwareReuseAndRefCounting(t *testing.T) {
 	if cacheKeyA == cacheKeyC {
 		t.Fatal("different selectors matching the same leaf should not share mutable cache entries")
 	}
-	if provider.openCount() != 3 {
-		t.Fatalf("expected each lookup to load once for cache-key calculation, got %d opens", provider.openCount())
-	}
-	if loads[1].identity.closeCount() != 1 || loads[1].store.closeCount() != 1 {
-		t.Fatalf("reused lookup resources should be closed immediately, got identity=%d store=%d", loads[1].identity.closeCount(), loads[1].store.closeCount())
+	if provider.openCount() != 2 {
+		t.Fatalf("expected identical selectors to reuse the cached entry without opening the store, got %d opens", provider.openCount())
 	}
 
-	cacheMutex.Lock()
-	cacheSize := len(certCache)
-	sharedRefCount := atomic.LoadInt32(&certCache[cacheKeyA].refCount)
-	separateRefCount := atomic.LoadInt32(&certCache[cacheKeyC].refCount)
-	cacheMutex.Unlock()
+	cache := defaultApp.cache
+	cache.mu.Lock()
+	cacheSize := len(cache.entries)
+	sharedRefCount := atomic.LoadInt32(&cache.entries[cacheKeyA].refCount)
+	separateRefCount := atomic.LoadInt32(&cache.entries[cacheKeyC].refCount)
+	cache.mu.Unlock()
 
 	if cacheSize != 2 {
 		t.Fatalf("expected 2 selector-aware cache entries, got %d", cacheSize)
@@ -78,29 +74,63 @@ func TestCertificateCache_SelectorAwareReuseAndRefCounting(t *testing.T) {
 		t.Fatalf("expected separate refCount=1, got %d", separateRefCount)
 	}
 
-	releaseCachedCertificate(cacheKeyA)
+	cache.release(cacheKeyA, "")
 	if loads[0].identity.closeCount() != 0 || loads[0].store.closeCount() != 0 {
 		t.Fatal("active shared resources closed before final release")
 	}
 
-	releaseCachedCertificate(cacheKeyB)
+	cache.release(cacheKeyB, "")
 	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
 		t.Fatalf("shared resources should close exactly once after final release, got identity=%d store=%d", loads[0].identity.closeCount(), loads[0].store.closeCount())
 	}
 
-	releaseCachedCertificate(cacheKeyC)
-	if loads[2].identity.closeCount() != 1 || loads[2].store.closeCount() != 1 {
-		t.Fatalf("separate resources should close exactly once, got identity=%d store=%d", loads[2].identity.closeCount(), loads[2].store.closeCount())
+	cache.release(cacheKeyC, "")
+	if loads[1].identity.closeCount() != 1 || loads[1].store.closeCount() != 1 {
+		t.Fatalf("separate resources should close exactly once, got identity=%d store=%d", loads[1].identity.closeCount(), loads[1].store.closeCount())
 	}
 
-	cacheMutex.Lock()
-	cacheSize = len(certCache)
-	cacheMutex.Unlock()
+	cache.mu.Lock()
+	cacheSize = len(cache.entries)
+	cache.mu.Unlock()
 	if cacheSize != 0 {
 		t.Fatalf("expected empty cache after cleanup, got %d entries", cacheSize)
 	}
 }
 
+func TestCertificateCache_LocationsDoNotShareEntries(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "stores.example.test", key)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("user"))),
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("system"))),
+	}
+	withFakeStoreLoads(t, loads...)
+
+	userSelector := newTestSelector("^stores\\.example\\.test$")
+	systemSelector := newTestSelector("^stores\\.example\\.test$")
+	systemSelector.Location = "system"
+
+	for _, selector := range []*CertSelector{userSelector, systemSelector} {
+		if _, err := selector.loadCertificate(t.Context()); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+	}
+	if userSelector.cacheEntry == systemSelector.cacheEntry {
+		t.Fatal("selectors of different stores holding the same certificate should not share an identity handle")
+	}
+
+	userSelector.release()
+	if loads[0].identity.closeCount() != 1 || loads[1].identity.closeCount() != 0 {
+		t.Fatal("releasing one store's selector should close only its own identity")
+	}
+	systemSelector.release()
+	if loads[1].identity.closeCount() != 1 || loads[1].store.closeCount() != 1 {
+		t.Fatal("expected the other store's resources to close on its release")
+	}
+}
+
 func TestCachedCertificateRefresh_SameKeySwapsResources(t *testing.T) {
 	resetCertificateCache(t)
 
@@ -114,7 +144,7 @@ func TestCachedCertificateRefresh_SameKeySwapsResources(t *testing.T) {
 	withFakeStoreLoads(t, loads...)
 
 	selector := newTestSelector("^refresh\\.example\\.test$")
-	cert, cacheKey, err := selector.getCachedCertificate()
+	cert, cacheKey, err := selector.getCachedCertificate(t.Context())
 	if err != nil {
 		t.Fatalf("load failed: %v", err)
 	}
@@ -141,7 +171,7 @@ func TestCachedCertificateRefresh_SameKeySwapsResources(t *testing.T) {
 		t.Fatalf("expected current leaf serial %s, got %s", refreshedCert.SerialNumber, current.Leaf.SerialNumber)
 	}
 
-	releaseCachedCertificate(cacheKey)
+	defaultApp.cache.release(cacheKey, "")
 	if loads[1].identity.closeCount() != 1 || loads[1].store.closeCount() != 1 {
 		t.Fatalf("refreshed resources should close exactly once on release, got identity=%d store=%d", loads[1].identity.closeCount(), loads[1].store.closeCount())
 	}
@@ -156,7 +186,7 @@ func TestRefreshingSigner(t *testing.T) {
 		provider := withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
 
 		selector := newTestSelector("^sign\\.example\\.test$")
-		loadedCert, cacheKey, err := selector.getCachedCertificate()
+		loadedCert, cacheKey, err := selector.getCachedCertificate(t.Context())
 		if err != nil {
 			t.Fatalf("load failed: %v", err)
 		}
@@ -172,7 +202,7 @@ func TestRefreshingSigner(t *testing.T) {
 			t.Fatalf("expected no refresh loads, got %d opens", provider.openCount())
 		}
 
-		releaseCachedCertificate(cacheKey)
+		defaultApp.cache.release(cacheKey, "")
 	})
 
 	t.Run("refresh load failure preserves original signing error", func(t *testing.T) {
@@ -187,7 +217,7 @@ func TestRefreshingSigner(t *testing.T) {
 		withFakeStoreLoads(t, loads...)
 
 		selector := newTestSelector("^refresh-failure\\.example\\.test$")
-		loadedCert, cacheKey, err := selector.getCachedCertificate()
+		loadedCert, cacheKey, err := selector.getCachedCertificate(t.Context())
 		if err != nil {
 			t.Fatalf("load failed: %v", err)
 		}
@@ -195,7 +225,7 @@ func TestRefreshingSigner(t *testing.T) {
 		_, err = loadedCert.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256)
 		assertErrorContains(t, err, "refresh failed", errStaleSigner.Error(), errRefreshLoad.Error())
 
-		releaseCachedCertificate(cacheKey)
+		defaultApp.cache.release(cacheKey, "")
 	})
 
 	t.Run("retry failure preserves original and retry errors", func(t *testing.T) {
@@ -211,7 +241,7 @@ func TestRefreshingSigner(t *testing.T) {
 		withFakeStoreLoads(t, loads...)
 
 		selector := newTestSelector("^retry-failure\\.example\\.test$")
-		loadedCert, cacheKey, err := selector.getCachedCertificate()
+		loadedCert, cacheKey, err := selector.getCachedCertificate(t.Context())
 		if err != nil {
 			t.Fatalf("load failed: %v", err)
 		}
@@ -219,7 +249,7 @@ func TestRefreshingSigner(t *testing.T) {
 		_, err = loadedCert.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256)
 		assertErrorContains(t, err, "retry failed", errStaleSigner.Error(), errRetrySigner.Error())
 
-		releaseCachedCertificate(cacheKey)
+		defaultApp.cache.release(cacheKey, "")
 	})
 
 	t.Run("different key rotation refreshes cache for future handshakes", func(t *testing.T) {
@@ -236,7 +266,7 @@ func TestRefreshingSigner(t *testing.T) {
 		withFakeStoreLoads(t, loads...)
 
 		selector := newTestSelector("^rotation\\.example\\.test$")
-		loadedCert, cacheKey, err := selector.getCachedCertificate()
+		loadedCert, cacheKey, err := selector.getCachedCertificate(t.Context())
 		if err != nil {
 			t.Fatalf("load failed: %v", err)
 		}
@@ -252,10 +282,112 @@ func TestRefreshingSigner(t *testing.T) {
 			t.Fatalf("expected future handshakes to see refreshed serial %s, got %s", refreshedCert.SerialNumber, current.Leaf.SerialNumber)
 		}
 
-		releaseCachedCertificate(cacheKey)
+		defaultApp.cache.release(cacheKey, "")
 	})
 }
 
+func TestCachedCertificateSwap_KeepsResourcesForPendingHandshakes(t *testing.T) {
+	resetCertificateCache(t)
+
+	initialKey := newTestKey(t)
+	renewedKey := newTestKey(t)
+	initialCert := newTestCertificate(t, "hot-swap.example.test", initialKey)
+	renewedCert := newTestCertificate(t, "hot-swap.example.test", renewedKey)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("initial"))),
+		newFakeStoreLoad(renewedCert, newFakeSigner(renewedKey.Public(), []byte("renewed"))),
+	}
+	withFakeStoreLoads(t, loads...)
+
+	selector := newTestSelector("^hot-swap\\.example\\.test$")
+	_, cacheKey, err := selector.getCachedCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer defaultApp.cache.release(cacheKey, "")
+
+	signing, err := selector.handshakeCertificate()
+	if err != nil {
+		t.Fatalf("handshake certificate failed: %v", err)
+	}
+	abandoned, err := selector.handshakeCertificate()
+	if err != nil {
+		t.Fatalf("handshake certificate failed: %v", err)
+	}
+	unused, err := selector.currentCertificate()
+	if err != nil {
+		t.Fatalf("current certificate failed: %v", err)
+	}
+
+	if changed, err := selector.cacheEntry.reselect(t.Context()); err != nil || !changed {
+		t.Fatalf("expected the renewed certificate to be swapped in, got changed=%t err=%v", changed, err)
+	}
+	if loads[0].identity.closeCount() != 0 || loads[0].store.closeCount() != 0 {
+		t.Fatal("replaced resources closed under pending handshakes")
+	}
+
+	sig, err := signing.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("sign with replaced certificate failed: %v", err)
+	}
+	if string(sig) != "initial" {
+		t.Fatalf("expected the replaced key to sign, got %q", sig)
+	}
+	if loads[0].identity.closeCount() != 0 {
+		t.Fatal("replaced resources closed before the last pending handshake finished")
+	}
+
+	finishHandshake(abandoned)
+	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
+		t.Fatalf("replaced resources should close once handshakes finish, got identity=%d store=%d", loads[0].identity.closeCount(), loads[0].store.closeCount())
+	}
+
+	_, err = unused.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256)
+	if !errors.Is(err, errCertificateReplaced) {
+		t.Fatalf("expected errCertificateReplaced, got %v", err)
+	}
+}
+
+func TestCachedCertificateSwap_ClosesIdleResources(t *testing.T) {
+	resetCertificateCache(t)
+
+	initialKey := newTestKey(t)
+	renewedKey := newTestKey(t)
+	initialCert := newTestCertificate(t, "idle-swap.example.test", initialKey)
+	renewedCert := newTestCertificate(t, "idle-swap.example.test", renewedKey)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("initial"))),
+		newFakeStoreLoad(renewedCert, newFakeSigner(renewedKey.Public(), []byte("renewed"))),
+	}
+	withFakeStoreLoads(t, loads...)
+
+	selector := newTestSelector("^idle-swap\\.example\\.test$")
+	_, cacheKey, err := selector.getCachedCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	handshake, err := selector.handshakeCertificate()
+	if err != nil {
+		t.Fatalf("handshake certificate failed: %v", err)
+	}
+	if _, err := handshake.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+
+	if _, err := selector.cacheEntry.reselect(t.Context()); err != nil {
+		t.Fatalf("reselect failed: %v", err)
+	}
+	if loads[0].identity.closeCount() != 1 || loads[0].store.closeCount() != 1 {
+		t.Fatal("expected replaced resources without pending handshakes to close right away")
+	}
+
+	defaultApp.cache.release(cacheKey, "")
+	if loads[1].identity.closeCount() != 1 {
+		t.Fatal("expected current resources to close on release")
+	}
+}
+
 var (
 	errStaleSigner = fmt.Errorf("stale signer")
 	errRefreshLoad = fmt.Errorf("refresh load failed")
@@ -266,19 +398,28 @@ var (
 func resetCertificateCache(t *testing.T) {
 	t.Helper()
 
-	cacheMutex.Lock()
-	certCache = make(map[string]*cachedCert)
-	cacheMutex.Unlock()
+	defaultApp.cache = newCertificateCache()
 }
 
 func withFakeStoreLoads(t *testing.T, loads ...*fakeStoreLoad) *fakeStoreProvider {
 	t.Helper()
 
 	provider := &fakeStoreProvider{loads: loads}
-	oldOpen := openCertStore
-	openCertStore = provider.open
+	restoreBackend := SetBackend(BackendFunc(provider.open))
+	oldHardwareBacked, oldKeyStorageOf := keyHardwareBacked, keyStorageOf
+	keyHardwareBacked = func(identity Identity) bool {
+		fake, ok := identity.(*fakeIdentity)
+		return ok && fake.hardware
+	}
+	keyStorageOf = func(identity Identity) keyStorage {
+		if fake, ok := identity.(*fakeIdentity); ok {
+			return fake.keyStorage
+		}
+		return keyStorage{}
+	}
 	t.Cleanup(func() {
-		openCertStore = oldOpen
+		restoreBackend()
+		keyHardwareBacked, keyStorageOf = oldHardwareBacked, oldKeyStorageOf
 	})
 	return provider
 }
@@ -289,7 +430,7 @@ type fakeStoreProvider struct {
 	opens int
 }
 
-func (p *fakeStoreProvider) open(certstore.StoreLocation, ...certstore.StorePermission) (certstore.Store, error) {
+func (p *fakeStoreProvider) open(StoreLocation) (Store, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -317,35 +458,83 @@ type fakeStoreLoad struct {
 }
 
 func newFakeStoreLoad(cert *x509.Certificate, signer crypto.Signer) *fakeStoreLoad {
-	identity := &fakeIdentity{cert: cert, signer: signer}
-	store := &fakeStore{identities: []certstore.Identity{identity}}
+	return newFakeStoreLoadWithChain([]*x509.Certificate{cert}, signer)
+}
+
+func newFakeStoreLoadWithChain(chain []*x509.Certificate, signer crypto.Signer) *fakeStoreLoad {
+	identity := &fakeIdentity{cert: chain[0], chain: chain, signer: signer}
+	store := &fakeStore{identities: []Identity{identity}}
 	return &fakeStoreLoad{store: store, identity: identity}
 }
 
+func newFakeStoreLoadWithIdentities(identities ...*fakeIdentity) *fakeStoreLoad {
+	store := &fakeStore{}
+	for _, identity := range identities {
+		store.identities = append(store.identities, identity)
+	}
+	return &fakeStoreLoad{store: store, identity: identities[0]}
+}
+
+func newFakeIdentity(cert *x509.Certificate, signer crypto.Signer) *fakeIdentity {
+	return &fakeIdentity{cert: cert, chain: []*x509.Certificate{cert}, signer: signer}
+}
+
 type fakeStore struct {
-	identities []certstore.Identity
-	closed     int32
+	identities    []Identity
+	intermediates []*x509.Certificate
+	closed        int32
+
+	// entered, when set, is closed once Identities is called, and block
+	// then delays Identities until it is closed.
+	entered chan struct{}
+	block   chan struct{}
 }
 
-func (s *fakeStore) Identities() ([]certstore.Identity, error) { return s.identities, nil }
-func (s *fakeStore) Import([]byte, string) error               { return nil }
-func (s *fakeStore) Close()                                    { atomic.AddInt32(&s.closed, 1) }
-func (s *fakeStore) closeCount() int32                         { return atomic.LoadInt32(&s.closed) }
+func (s *fakeStore) Identities() ([]Identity, error) {
+	if s.block != nil {
+		close(s.entered)
+		<-s.block
+	}
+	return s.identities, nil
+}
+func (s *fakeStore) Import([]byte, string) error { return nil }
+func (s *fakeStore) Close()                      { atomic.AddInt32(&s.closed, 1) }
+func (s *fakeStore) Intermediates() ([]*x509.Certificate, error) {
+	return s.intermediates, nil
+}
+
+func (s *fakeStore) closeCount() int32 { return atomic.LoadInt32(&s.closed) }
 
 type fakeIdentity struct {
 	cert   *x509.Certificate
+	chain  []*x509.Certificate
 	signer crypto.Signer
 	closed int32
+
+	// hardware reports the key as hardware-backed to prefer: hardware.
+	hardware bool
+
+	// keyStorage is where the key is reported to be kept.
+	keyStorage keyStorage
+
+	// signerErr, when set, is returned by Signer, as for a key the process
+	// may not use.
+	signerErr error
 }
 
 func (i *fakeIdentity) Certificate() (*x509.Certificate, error) { return i.cert, nil }
 func (i *fakeIdentity) CertificateChain() ([]*x509.Certificate, error) {
-	return []*x509.Certificate{i.cert}, nil
+	return i.chain, nil
 }
-func (i *fakeIdentity) Signer() (crypto.Signer, error) { return i.signer, nil }
-func (i *fakeIdentity) Delete() error                  { return nil }
-func (i *fakeIdentity) Close()                         { atomic.AddInt32(&i.closed, 1) }
-func (i *fakeIdentity) closeCount() int32              { return atomic.LoadInt32(&i.closed) }
+func (i *fakeIdentity) Signer() (crypto.Signer, error) {
+	if i.signerErr != nil {
+		return nil, i.signerErr
+	}
+	return i.signer, nil
+}
+func (i *fakeIdentity) Delete() error     { return nil }
+func (i *fakeIdentity) Close()            { atomic.AddInt32(&i.closed, 1) }
+func (i *fakeIdentity) closeCount() int32 { return atomic.LoadInt32(&i.closed) }
 
 type fakeSigner struct {
 	public crypto.PublicKey
@@ -384,6 +573,7 @@ func newTestSelector(pattern string) *CertSelector {
 		Pattern:  pattern,
 		Location: "user",
 		pattern:  regexp.MustCompile(pattern),
+		cache:    defaultApp.cache,
 	}
 }
 
@@ -422,6 +612,61 @@ func newTestCertificate(t *testing.T, commonName string, key *ecdsa.PrivateKey)
 	return cert
 }
 
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, commonName string) *testCA {
+	t.Helper()
+
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&testSerial, 1)),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return &testCA{cert: createTestCertificate(t, template, template, key.Public(), key), key: key}
+}
+
+// issue signs a leaf certificate for template. SerialNumber and validity are
+// filled in when the template leaves them empty.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate, public crypto.PublicKey) *x509.Certificate {
+	t.Helper()
+
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(atomic.AddInt64(&testSerial, 1))
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	if template.KeyUsage == 0 {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	return createTestCertificate(t, template, ca.cert, public, ca.key)
+}
+
+func createTestCertificate(t *testing.T, template, parent *x509.Certificate, public crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	der, err := x509.CreateCertificate(crand.Reader, template, parent, public, signer)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert
+}
+
 func assertErrorContains(t *testing.T, err error, parts ...string) {
 	t.Helper()
 
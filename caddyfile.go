@@ -0,0 +1,368 @@
+package certstore
+
+import (
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// UnmarshalCaddyfile sets up the selector from Caddyfile tokens, so every
+// module embedding a selector parses the same syntax:
+//
+//	<directive> [<pattern>] {
+//	    pattern <regex>
+//	    thumbprint <hex>
+//	    template <name|oid>
+//	    all_of <field> <pattern> [<match_mode>]
+//	    any_of <field> <pattern> [<match_mode>]
+//	    match_mode regex|exact|glob
+//	    field subject|issuer|issuer_dn|serial|dns_names|ou|organization
+//	    extended_key_usage <name|oid>...
+//	    validate_eku_chain
+//	    key_type rsa|ecdsa|ed25519
+//	    min_key_bits <n>
+//	    key_provider <name>
+//	    key_container <name>
+//	    location user|system|machine|any|nssdb|piv|directory
+//	    store_name <name>
+//	    keychain <path>
+//	    nss_database <path>
+//	    directory <path>
+//	    piv_slot 9a|9c|9d|9e
+//	    pin <pin>
+//	    impersonate <user> [<password>]
+//	    prefer hardware
+//	    selection newest|longest_validity
+//	    require_sct warn|fail
+//	    fetch_ocsp
+//	    refresh_interval <duration>
+//	    match_acceptable_cas
+//	    disable_cache
+//	    optional
+//	    fallback_file <path>
+//	    fallback_key <path>
+//	    intermediates_file <path>...
+//	    intermediates_from_store
+//	    verify_chain_linkage
+//	    send_root
+//	    chain_preference shortest|newest_root|root_common_name [<root_cn>...]
+//	    pinned_root common_name|spki_sha256 <value>
+//	    max_candidates <n>
+//	    max_enumeration_time <duration>
+//	    not_before_skew <duration>
+//	    rollover_window <duration>
+//	    dev_self_signed <common_name>
+//	    log_level <level>
+//	    on_interaction_denied fail|retry|fallback
+//	    interaction_retry_timeout <duration>
+//	    fallback [<pattern>] {
+//	        ...
+//	    }
+//	}
+func (cs *CertSelector) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+
+	if d.NextArg() {
+		cs.Pattern = d.Val()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		if d.Val() == "fallback" {
+			cs.Fallback = new(CertSelector)
+			if err := cs.Fallback.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		parse, ok := selectorSubdirectives[d.Val()]
+		if !ok {
+			return d.Errf("unrecognized certificate selector option '%s'", d.Val())
+		}
+		if err := parse(cs, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectorSubdirectives parses the selector options other than fallback, with
+// the dispenser on the option name.
+var selectorSubdirectives = map[string]func(cs *CertSelector, d *caddyfile.Dispenser) error{
+	"pattern": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Pattern)
+	},
+	"thumbprint": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Thumbprint)
+	},
+	"template": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Template)
+	},
+	"all_of": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseFieldMatch(d, &cs.AllOf)
+	},
+	"any_of": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseFieldMatch(d, &cs.AnyOf)
+	},
+	"match_mode": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.MatchMode)
+	},
+	"field": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Field)
+	},
+	"extended_key_usage": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		usages := d.RemainingArgs()
+		if len(usages) == 0 {
+			return d.ArgErr()
+		}
+		cs.ExtendedKeyUsages = append(cs.ExtendedKeyUsages, usages...)
+		return nil
+	},
+	"validate_eku_chain": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.ValidateEKUChain = true
+		return nil
+	},
+	"key_type": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.KeyType)
+	},
+	"key_provider": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.KeyProvider)
+	},
+	"key_container": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.KeyContainer)
+	},
+	"min_key_bits": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		var value string
+		if err := parseStringArg(d, &value); err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return d.Errf("invalid min_key_bits '%s': %v", value, err)
+		}
+		cs.MinKeyBits = n
+		return nil
+	},
+	"verify_chain_linkage": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.VerifyChainLinkage = true
+		return nil
+	},
+	"send_root": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.SendRoot = true
+		return nil
+	},
+	"location": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Location)
+	},
+	"store_name": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.StoreName)
+	},
+	"keychain": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Keychain)
+	},
+	"nss_database": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.NSSDatabase)
+	},
+	"directory": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Directory)
+	},
+	"piv_slot": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.PIVSlot)
+	},
+	"pin": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.PIN)
+	},
+	"impersonate": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		args := d.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return d.ArgErr()
+		}
+		cs.Impersonate = &Impersonation{User: args[0]}
+		if len(args) == 2 {
+			cs.Impersonate.Password = args[1]
+		}
+		return nil
+	},
+	"prefer": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Prefer)
+	},
+	"selection": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.Selection)
+	},
+	"require_sct": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.RequireSCT)
+	},
+	"chain_preference": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.ChainPreference = &ChainPreference{Policy: d.Val(), RootCommonNames: d.RemainingArgs()}
+		return nil
+	},
+	"pinned_root": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		kind := d.Val()
+		if cs.PinnedRoot == nil {
+			cs.PinnedRoot = new(PinnedRoot)
+		}
+		switch kind {
+		case "common_name":
+			return parseStringArg(d, &cs.PinnedRoot.CommonName)
+		case "spki_sha256":
+			return parseStringArg(d, &cs.PinnedRoot.SPKISHA256)
+		default:
+			return d.Errf("unknown pinned_root kind '%s': must be 'common_name' or 'spki_sha256'", kind)
+		}
+	},
+	"intermediates_file": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		files := d.RemainingArgs()
+		if len(files) == 0 {
+			return d.ArgErr()
+		}
+		if cs.ExtraIntermediates == nil {
+			cs.ExtraIntermediates = new(ExtraIntermediates)
+		}
+		cs.ExtraIntermediates.Files = append(cs.ExtraIntermediates.Files, files...)
+		return nil
+	},
+	"intermediates_from_store": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		if cs.ExtraIntermediates == nil {
+			cs.ExtraIntermediates = new(ExtraIntermediates)
+		}
+		cs.ExtraIntermediates.FromStore = true
+		return nil
+	},
+	"match_acceptable_cas": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.MatchAcceptableCAs = true
+		return nil
+	},
+	"fetch_ocsp": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.FetchOCSP = true
+		return nil
+	},
+	"refresh_interval": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseDurationArg(d, &cs.RefreshInterval)
+	},
+	"disable_cache": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.DisableCache = true
+		return nil
+	},
+	"optional": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		cs.Optional = true
+		return nil
+	},
+	"max_candidates": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		var value string
+		if err := parseStringArg(d, &value); err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return d.Errf("invalid max_candidates '%s': %v", value, err)
+		}
+		cs.MaxCandidates = n
+		return nil
+	},
+	"max_enumeration_time": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseDurationArg(d, &cs.MaxEnumerationTime)
+	},
+	"not_before_skew": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseDurationArg(d, &cs.NotBeforeSkew)
+	},
+	"rollover_window": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseDurationArg(d, &cs.RolloverWindow)
+	},
+	"fallback_file": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.FallbackFile)
+	},
+	"fallback_key": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.FallbackKey)
+	},
+	"dev_self_signed": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.DevSelfSigned)
+	},
+	"log_level": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.LogLevel)
+	},
+	"on_interaction_denied": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseStringArg(d, &cs.OnInteractionDenied)
+	},
+	"interaction_retry_timeout": func(cs *CertSelector, d *caddyfile.Dispenser) error {
+		return parseDurationArg(d, &cs.InteractionRetryTimeout)
+	},
+}
+
+// parseStringArg reads the single argument of the current option into dst.
+func parseStringArg(d *caddyfile.Dispenser, dst *string) error {
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	*dst = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// parseFieldMatch reads a composite criterion, given as a field, a pattern
+// and an optional match mode, and appends it to dst.
+func parseFieldMatch(d *caddyfile.Dispenser, dst *[]*FieldMatch) error {
+	args := d.RemainingArgs()
+	if len(args) < 2 || len(args) > 3 {
+		return d.ArgErr()
+	}
+	match := &FieldMatch{Field: args[0], Pattern: args[1]}
+	if len(args) == 3 {
+		match.MatchMode = args[2]
+	}
+	*dst = append(*dst, match)
+	return nil
+}
+
+// parseDurationArg reads the single duration argument of the current option
+// into dst.
+func parseDurationArg(d *caddyfile.Dispenser, dst *caddy.Duration) error {
+	var value string
+	if err := parseStringArg(d, &value); err != nil {
+		return err
+	}
+	dur, err := caddy.ParseDuration(value)
+	if err != nil {
+		return d.Errf("invalid duration '%s': %v", value, err)
+	}
+	*dst = caddy.Duration(dur)
+	return nil
+}
+
+// Interface guards
+var _ caddyfile.Unmarshaler = (*CertSelector)(nil)
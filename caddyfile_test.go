@@ -0,0 +1,200 @@
+package certstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestCertSelector_UnmarshalCaddyfile(t *testing.T) {
+	input := `client_certificate {
+		pattern ^client\.example\.com$
+		match_mode regex
+		field issuer
+		all_of issuer "ACME Issuing CA *" glob
+		any_of dns_names ^a\.example\.com$
+		any_of dns_names ^b\.example\.com$
+		extended_key_usage "Client Authentication" "Smart Card Logon"
+		validate_eku_chain
+		location any
+		store_name WebHosting
+		keychain caddy.keychain-db
+		nss_database /etc/pki/nssdb
+		directory /etc/caddy/certs
+		piv_slot 9a
+		pin {env.PIV_PIN}
+		impersonate CORP\svc-caddy {env.SVC_PASSWORD}
+		prefer hardware
+		require_sct fail
+		fetch_ocsp
+		refresh_interval 1h
+		match_acceptable_cas
+		disable_cache
+		optional
+		fallback_file /etc/caddy/dev-client.pem
+		fallback_key /etc/caddy/dev-client.key
+		intermediates_file /etc/pki/cross.pem
+		intermediates_from_store
+		verify_chain_linkage
+		send_root
+		chain_preference root_common_name "Root B" "Root A"
+		pinned_root common_name "Root B"
+		max_candidates 50
+		max_enumeration_time 2s
+		not_before_skew 1m
+		rollover_window 720h
+		on_interaction_denied fallback
+		fallback ^backup\.example\.com$ {
+			location user
+		}
+	}`
+
+	var cs CertSelector
+	if err := cs.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+
+	if cs.Pattern != `^client\.example\.com$` || cs.MatchMode != "regex" || cs.Field != "issuer" || cs.Location != "any" || cs.StoreName != "WebHosting" || cs.Keychain != "caddy.keychain-db" || cs.NSSDatabase != "/etc/pki/nssdb" || cs.Directory != "/etc/caddy/certs" || cs.PIVSlot != "9a" || cs.PIN != "{env.PIV_PIN}" || cs.Impersonate == nil || cs.Impersonate.User != `CORP\svc-caddy` || cs.Prefer != "hardware" {
+		t.Fatalf("unexpected selector: %+v", cs)
+	}
+	if len(cs.AllOf) != 1 || *cs.AllOf[0] != (FieldMatch{Field: "issuer", Pattern: "ACME Issuing CA *", MatchMode: "glob"}) ||
+		len(cs.AnyOf) != 2 || cs.AnyOf[1].Field != "dns_names" || cs.AnyOf[1].Pattern != `^b\.example\.com$` {
+		t.Fatalf("unexpected composite criteria: all_of=%v any_of=%v", cs.AllOf, cs.AnyOf)
+	}
+	if !cs.ValidateEKUChain || len(cs.ExtendedKeyUsages) != 2 || cs.ExtendedKeyUsages[1] != "Smart Card Logon" {
+		t.Fatalf("unexpected extended key usages: %v", cs.ExtendedKeyUsages)
+	}
+	if !cs.FetchOCSP || cs.RefreshInterval != caddy.Duration(time.Hour) || !cs.MatchAcceptableCAs || cs.RolloverWindow != caddy.Duration(720*time.Hour) || !cs.DisableCache || cs.RequireSCT != "fail" || cs.MaxCandidates != 50 || cs.MaxEnumerationTime != caddy.Duration(2*time.Second) {
+		t.Fatalf("unexpected enumeration options: %+v", cs)
+	}
+	if cs.FallbackFile != "/etc/caddy/dev-client.pem" || cs.FallbackKey != "/etc/caddy/dev-client.key" || !cs.Optional {
+		t.Fatalf("unexpected fallback file: %q %q", cs.FallbackFile, cs.FallbackKey)
+	}
+	if cs.ChainPreference == nil || cs.ChainPreference.Policy != "root_common_name" ||
+		len(cs.ChainPreference.RootCommonNames) != 2 || cs.ChainPreference.RootCommonNames[0] != "Root B" {
+		t.Fatalf("unexpected chain preference: %+v", cs.ChainPreference)
+	}
+	if !cs.VerifyChainLinkage || !cs.SendRoot || cs.ExtraIntermediates == nil || !cs.ExtraIntermediates.FromStore ||
+		len(cs.ExtraIntermediates.Files) != 1 || cs.ExtraIntermediates.Files[0] != "/etc/pki/cross.pem" {
+		t.Fatalf("unexpected extra intermediates: %+v", cs.ExtraIntermediates)
+	}
+	if cs.NotBeforeSkew != caddy.Duration(time.Minute) {
+		t.Fatalf("unexpected not_before_skew: %v", cs.NotBeforeSkew)
+	}
+	if cs.PinnedRoot == nil || cs.PinnedRoot.CommonName != "Root B" {
+		t.Fatalf("unexpected pinned root: %+v", cs.PinnedRoot)
+	}
+	if cs.OnInteractionDenied != "fallback" || cs.Fallback == nil {
+		t.Fatalf("expected fallback policy with a fallback selector: %+v", cs)
+	}
+	if cs.Fallback.Pattern != `^backup\.example\.com$` || cs.Fallback.Location != "user" {
+		t.Fatalf("unexpected fallback selector: %+v", cs.Fallback)
+	}
+}
+
+func TestCertSelector_UnmarshalCaddyfileErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown option":         "client_certificate {\n\tcolor blue\n}",
+		"missing argument":       "client_certificate {\n\tfield\n}",
+		"all_of without pattern": "client_certificate {\n\tall_of issuer\n}",
+		"extra argument":         "client_certificate {\n\tlocation user system\n}",
+		"invalid count":          "client_certificate {\n\tmax_candidates many\n}",
+		"invalid duration":       "client_certificate {\n\tmax_enumeration_time soon\n}",
+		"too many patterns":      "client_certificate a b",
+		"flag with argument":     "client_certificate {\n\tfetch_ocsp yes\n}",
+		"unknown pin kind":       "client_certificate {\n\tpinned_root serial 1\n}",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cs CertSelector
+			if err := cs.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestHTTPTransport_UnmarshalCaddyfile(t *testing.T) {
+	input := `certstore {
+		dial_timeout 5s
+		client_certificate ^client\.example\.com$ {
+			location user
+		}
+		client_certificate_upstreams *.partner.example:8443
+		upstream_client_certificate api.partner.example ^api\.example\.com$ {
+			location system
+		}
+		tls_server_name upstream.example.com
+		health_check_no_client_certificate
+		trust os
+		versions 1.1
+	}`
+
+	var h HTTPTransport
+	if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+
+	if h.ClientCert == nil || h.ClientCert.Pattern != `^client\.example\.com$` || h.ClientCert.Location != "user" {
+		t.Fatalf("unexpected client certificate: %+v", h.ClientCert)
+	}
+	if len(h.ClientCertUpstreams) != 1 || h.ClientCertUpstreams[0] != "*.partner.example:8443" || !h.HealthCheckNoClientCert || h.Trust != "os" {
+		t.Fatalf("unexpected transport options: %+v", h)
+	}
+	if upstream := h.UpstreamClientCerts["api.partner.example"]; len(h.UpstreamClientCerts) != 1 || upstream == nil || upstream.Pattern != `^api\.example\.com$` || upstream.Location != "system" {
+		t.Fatalf("unexpected upstream client certificates: %+v", h.UpstreamClientCerts)
+	}
+	if h.DialTimeout != caddy.Duration(5*time.Second) || h.TLS == nil || h.TLS.ServerName != "upstream.example.com" {
+		t.Fatalf("http transport options were not parsed: %+v", h.HTTPTransport)
+	}
+	if len(h.Versions) != 1 || h.Versions[0] != "1.1" {
+		t.Fatalf("unexpected versions: %v", h.Versions)
+	}
+}
+
+func TestHTTPTransport_UnmarshalCaddyfileClientCerts(t *testing.T) {
+	input := `certstore {
+		client_certificate ^new\.example\.com$
+		client_certificate ^old\.example\.com$
+		client_certificate ^legacy\.example\.com$
+	}`
+
+	var h HTTPTransport
+	if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+
+	if h.ClientCert != nil || len(h.ClientCerts) != 3 {
+		t.Fatalf("expected three ordered selectors, got client_certificate=%+v client_certificates=%d", h.ClientCert, len(h.ClientCerts))
+	}
+	for i, want := range []string{`^new\.example\.com$`, `^old\.example\.com$`, `^legacy\.example\.com$`} {
+		if h.ClientCerts[i].Pattern != want {
+			t.Fatalf("selector %d: expected pattern %q, got %q", i, want, h.ClientCerts[i].Pattern)
+		}
+	}
+}
+
+func TestHTTPTransport_UnmarshalCaddyfileErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown option":       "certstore {\n\tcolor blue\n}",
+		"missing ref":          "certstore {\n\tclient_certificate_ref\n}",
+		"missing upstreams":    "certstore {\n\tclient_certificate_upstreams\n}",
+		"flag with argument":   "certstore {\n\thealth_check_no_client_certificate yes\n}",
+		"missing upstream":     "certstore {\n\tupstream_client_certificate\n}",
+		"duplicate upstream":   "certstore {\n\tupstream_client_certificate a.example\n\tupstream_client_certificate a.example\n}",
+		"bad selector option":  "certstore {\n\tclient_certificate {\n\t\tcolor blue\n\t}\n}",
+		"bad transport option": "certstore {\n\tdial_timeout soon\n}",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			var h HTTPTransport
+			if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Package certstoretest provides fake certificate stores, identities and
+// signers implementing the certstore backend interfaces, so code built on the
+// certstore module can run handshake-level tests on any platform without
+// touching the OS certificate stores.
+//
+// A typical test creates identities, places them in a Backend and installs it:
+//
+//	identity := certstoretest.NewSelfSignedIdentity(t, "client.example.com")
+//	backend := certstoretest.NewBackend()
+//	backend.SetStore(certstore.LocationUser, certstoretest.NewStore(identity))
+//	certstoretest.Install(t, backend)
+//
+// InstallStore does the same in one call, and NewDeterministicIdentity
+// returns identities whose certificates and signatures are the same on every
+// run, for golden files and CI runners without any OS store.
+package certstoretest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	certstore "github.com/hurricanehrndz/caddy-certstore"
+)
+
+// Install makes backend the certstore backend for the duration of the test.
+// Tests installing a backend must not run in parallel.
+func Install(tb testing.TB, backend certstore.Backend) {
+	tb.Helper()
+	tb.Cleanup(certstore.SetBackend(backend))
+}
+
+// InstallStore installs a Backend holding a Store with identities at location
+// for the duration of the test and returns the store.
+func InstallStore(tb testing.TB, location certstore.StoreLocation, identities ...*Identity) *Store {
+	tb.Helper()
+
+	store := NewStore(identities...)
+	backend := NewBackend()
+	backend.SetStore(location, store)
+	Install(tb, backend)
+	return store
+}
+
+// Backend is a fake certstore.Backend holding one Store per location.
+type Backend struct {
+	mu     sync.Mutex
+	stores map[certstore.StoreLocation]*Store
+	opens  int
+}
+
+// NewBackend returns a Backend without stores.
+func NewBackend() *Backend {
+	return &Backend{stores: make(map[certstore.StoreLocation]*Store)}
+}
+
+// SetStore places store at location.
+func (b *Backend) SetStore(location certstore.StoreLocation, store *Store) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stores[location] = store
+}
+
+// OpenStore implements certstore.Backend.
+func (b *Backend) OpenStore(location certstore.StoreLocation) (certstore.Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.opens++
+	store, ok := b.stores[location]
+	if !ok {
+		return nil, fmt.Errorf("no fake store at location %s", location)
+	}
+	if store.OpenErr != nil {
+		return nil, store.OpenErr
+	}
+	return store, nil
+}
+
+// Opens returns how many times a store was opened.
+func (b *Backend) Opens() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opens
+}
+
+// Store is a fake certstore.Store.
+type Store struct {
+	// OpenErr, when set, is returned by Backend.OpenStore for this store.
+	OpenErr error
+
+	mu         sync.Mutex
+	identities []*Identity
+	closed     atomic.Int32
+}
+
+// NewStore returns a Store containing identities.
+func NewStore(identities ...*Identity) *Store {
+	return &Store{identities: identities}
+}
+
+// Add adds identities to the store, as when a certificate is renewed.
+func (s *Store) Add(identities ...*Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities = append(s.identities, identities...)
+}
+
+// Identities implements certstore.Store.
+func (s *Store) Identities() ([]certstore.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]certstore.Identity, len(s.identities))
+	for i, identity := range s.identities {
+		out[i] = identity
+	}
+	return out, nil
+}
+
+// Close implements certstore.Store.
+func (s *Store) Close() { s.closed.Add(1) }
+
+// Closes returns how many times the store was closed.
+func (s *Store) Closes() int { return int(s.closed.Load()) }
+
+// Identity is a fake certstore.Identity.
+type Identity struct {
+	chain  []*x509.Certificate
+	signer crypto.Signer
+	closed atomic.Int32
+}
+
+// NewIdentity returns an Identity presenting chain, leaf first, and signing
+// with signer.
+func NewIdentity(chain []*x509.Certificate, signer crypto.Signer) *Identity {
+	return &Identity{chain: chain, signer: signer}
+}
+
+// NewSelfSignedIdentity returns an Identity with a freshly generated ECDSA
+// key and a self-signed client certificate for commonName, valid for a day.
+func NewSelfSignedIdentity(tb testing.TB, commonName string) *Identity {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		tb.Fatalf("generate serial number: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		tb.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("parse certificate: %v", err)
+	}
+
+	return NewIdentity([]*x509.Certificate{cert}, NewSigner(key))
+}
+
+// deterministicValidity is the validity window of deterministic certificates,
+// fixed so their bytes do not depend on when the test runs.
+var deterministicValidity = [2]time.Time{
+	time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// NewDeterministicIdentity returns an Identity whose Ed25519 key, self-signed
+// client certificate and signatures are derived from commonName alone, so
+// every run produces the same bytes. Ed25519 signatures involve no
+// randomness, which keeps recorded handshakes and signed payloads stable.
+func NewDeterministicIdentity(tb testing.TB, commonName string) *Identity {
+	tb.Helper()
+
+	seed := sha256.Sum256([]byte("certstoretest deterministic identity: " + commonName))
+	key := ed25519.NewKeyFromSeed(seed[:])
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetBytes(seed[:8]),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    deterministicValidity[0],
+		NotAfter:     deterministicValidity[1],
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		tb.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("parse certificate: %v", err)
+	}
+
+	return NewIdentity([]*x509.Certificate{cert}, NewSigner(key))
+}
+
+// Certificate implements certstore.Identity.
+func (i *Identity) Certificate() (*x509.Certificate, error) { return i.chain[0], nil }
+
+// CertificateChain implements certstore.Identity.
+func (i *Identity) CertificateChain() ([]*x509.Certificate, error) { return i.chain, nil }
+
+// Signer implements certstore.Identity.
+func (i *Identity) Signer() (crypto.Signer, error) { return i.signer, nil }
+
+// Close implements certstore.Identity.
+func (i *Identity) Close() { i.closed.Add(1) }
+
+// Closes returns how many times the identity was closed.
+func (i *Identity) Closes() int { return int(i.closed.Load()) }
+
+// Signer is a crypto.Signer that signs with a real key, so handshakes verify,
+// and can be told to fail, as an OS key whose handle went stale would.
+type Signer struct {
+	key crypto.Signer
+
+	mu    sync.Mutex
+	fails []error
+	signs int
+}
+
+// NewSigner returns a Signer signing with key.
+func NewSigner(key crypto.Signer) *Signer {
+	return &Signer{key: key}
+}
+
+// FailNext makes the next len(errs) signatures fail with errs, in order.
+func (s *Signer) FailNext(errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fails = append(s.fails, errs...)
+}
+
+// Signs returns how many signatures were attempted.
+func (s *Signer) Signs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signs
+}
+
+// Public implements crypto.Signer.
+func (s *Signer) Public() crypto.PublicKey { return s.key.Public() }
+
+// Sign implements crypto.Signer.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	s.signs++
+	if len(s.fails) > 0 {
+		err := s.fails[0]
+		s.fails = s.fails[1:]
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Unlock()
+	return s.key.Sign(rand, digest, opts)
+}
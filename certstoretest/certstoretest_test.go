@@ -0,0 +1,142 @@
+package certstoretest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+
+	certstore "github.com/hurricanehrndz/caddy-certstore"
+	"github.com/hurricanehrndz/caddy-certstore/certstoretest"
+)
+
+func TestHandshakeWithFakeStore(t *testing.T) {
+	identity := certstoretest.NewSelfSignedIdentity(t, "harness.example.test")
+	backend := certstoretest.NewBackend()
+	store := certstoretest.NewStore(identity)
+	backend.SetStore(certstore.LocationUser, store)
+	certstoretest.Install(t, backend)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	transport := &certstore.HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{
+			TLS: &reverseproxy.TLSConfig{InsecureSkipVerify: true},
+		},
+		ClientCert: &certstore.CertSelector{
+			Pattern:  "^harness\\.example\\.test$",
+			Location: "user",
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := transport.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "harness.example.test" {
+		t.Fatalf("expected server to see the store certificate, got %q", body)
+	}
+
+	if err := transport.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if identity.Closes() != 1 || store.Closes() != 1 {
+		t.Fatalf("expected identity and store to be closed once, got identity=%d store=%d", identity.Closes(), store.Closes())
+	}
+}
+
+func TestDeterministicIdentity(t *testing.T) {
+	first := certstoretest.NewDeterministicIdentity(t, "ci.example.test")
+	second := certstoretest.NewDeterministicIdentity(t, "ci.example.test")
+
+	firstCert, _ := first.Certificate()
+	secondCert, _ := second.Certificate()
+	if !bytes.Equal(firstCert.Raw, secondCert.Raw) {
+		t.Fatal("expected identical certificates for the same common name")
+	}
+	other, _ := certstoretest.NewDeterministicIdentity(t, "other.example.test").Certificate()
+	if bytes.Equal(firstCert.Raw, other.Raw) {
+		t.Fatal("expected different certificates for different common names")
+	}
+
+	message := []byte("payload")
+	signatures := make([][]byte, 0, 2)
+	for _, identity := range []*certstoretest.Identity{first, second} {
+		signer, _ := identity.Signer()
+		signature, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+		if err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		signatures = append(signatures, signature)
+	}
+	if !bytes.Equal(signatures[0], signatures[1]) {
+		t.Fatal("expected identical signatures")
+	}
+}
+
+func TestVerifiedHandshakeWithDeterministicIdentity(t *testing.T) {
+	identity := certstoretest.NewDeterministicIdentity(t, "ci.example.test")
+	certstoretest.InstallStore(t, certstore.LocationUser, identity)
+
+	leaf, _ := identity.Certificate()
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(leaf)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	transport := &certstore.HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{
+			TLS: &reverseproxy.TLSConfig{InsecureSkipVerify: true},
+		},
+		ClientCert: &certstore.CertSelector{Pattern: "^ci\\.example\\.test$", Location: "user"},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := transport.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer transport.Cleanup()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ci.example.test" {
+		t.Fatalf("expected server to verify the deterministic certificate, got %q", body)
+	}
+}
@@ -0,0 +1,62 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Chain engines and flags of CertGetCertificateChain missing from
+// x/sys/windows.
+const (
+	hcceCurrentUser  = windows.Handle(0)
+	hcceLocalMachine = windows.Handle(1)
+
+	certChainCacheOnlyURLRetrieval = 0x00000004
+)
+
+// BuildChain builds the chain of leaf with CertGetCertificateChain, from the
+// Intermediate Certification Authorities and Trusted Root Certification
+// Authorities stores of the store's location. Nothing is fetched from the
+// network, so issuers missing from the stores end the chain early.
+func (s osStore) BuildChain(leaf *x509.Certificate) ([]*x509.Certificate, error) {
+	leafCtx, err := windows.CertCreateCertificateContext(windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, &leaf.Raw[0], uint32(len(leaf.Raw)))
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate context: %w", err)
+	}
+	defer windows.CertFreeCertificateContext(leafCtx)
+
+	engine := hcceLocalMachine
+	if s.location == LocationUser {
+		engine = hcceCurrentUser
+	}
+	para := &windows.CertChainPara{Size: uint32(unsafe.Sizeof(windows.CertChainPara{}))}
+	var chainCtx *windows.CertChainContext
+	if err := windows.CertGetCertificateChain(engine, leafCtx, nil, 0, para, certChainCacheOnlyURLRetrieval, 0, &chainCtx); err != nil {
+		return nil, fmt.Errorf("building certificate chain: %w", err)
+	}
+	defer windows.CertFreeCertificateChain(chainCtx)
+
+	if chainCtx.ChainCount < 1 {
+		return nil, errors.New("building certificate chain: no chain found")
+	}
+	simpleChain := *chainCtx.Chains
+	elements := unsafe.Slice(simpleChain.Elements, simpleChain.NumElements)
+	chain := make([]*x509.Certificate, 0, len(elements))
+	for _, element := range elements {
+		der := unsafe.Slice(element.CertContext.EncodedCert, element.CertContext.Length)
+		cert, err := x509.ParseCertificate(bytes.Clone(der))
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate chain: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// Interface guards
+var _ ChainBuilder = osStore{}
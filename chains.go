@@ -0,0 +1,288 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// Chain preference policies.
+const (
+	chainShortest       = "shortest"
+	chainRootCommonName = "root_common_name"
+	chainNewestRoot     = "newest_root"
+)
+
+// maxChainPaths bounds path building for chains with many cross-signs.
+const maxChainPaths = 64
+
+// ChainPreference chooses which path is presented when the certificates of
+// the chain, including extra intermediates, can build several paths from the
+// leaf, as with cross-signed roots. Only the certificates of the chosen path
+// are presented.
+type ChainPreference struct {
+	// Policy is "shortest" for the path with the fewest certificates,
+	// "root_common_name" for the first path ending at a root named in
+	// RootCommonNames, or "newest_root" for the path whose topmost
+	// certificate was issued most recently. Ties, and root names matching
+	// no path, keep the path of the stored chain.
+	Policy string `json:"policy,omitempty"`
+
+	// RootCommonNames are the root common names in order of preference,
+	// used by the "root_common_name" policy. A path ends at a root when its
+	// topmost certificate is that root or was issued by it.
+	RootCommonNames []string `json:"root_common_names,omitempty"`
+}
+
+func (p *ChainPreference) validate() error {
+	switch p.Policy {
+	case chainShortest, chainNewestRoot:
+		return nil
+	case chainRootCommonName:
+		if len(p.RootCommonNames) == 0 {
+			return fmt.Errorf("chain_preference policy '%s' requires root_common_names", p.Policy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported chain_preference policy '%s': must be '%s', '%s' or '%s'",
+			p.Policy, chainShortest, chainRootCommonName, chainNewestRoot)
+	}
+}
+
+// PinnedRoot requires the presented path to end at one root, for upstreams
+// that trust only one of the CA's cross-signs. When both fields are set the
+// root must match both.
+type PinnedRoot struct {
+	// CommonName is the common name of the root. A path ends at the root
+	// when its topmost certificate is the root or was issued by it.
+	CommonName string `json:"common_name,omitempty"`
+
+	// SPKISHA256 is the base64 SHA-256 hash of the root's
+	// SubjectPublicKeyInfo. The root certificate must be part of the chain,
+	// for example through extra_intermediates files, for the path to match.
+	SPKISHA256 string `json:"spki_sha256,omitempty"`
+}
+
+// decodeSPKI validates the pin and returns the decoded SPKI hash, or nil when
+// the root is pinned by name only.
+func (p *PinnedRoot) decodeSPKI() ([]byte, error) {
+	if p.CommonName == "" && p.SPKISHA256 == "" {
+		return nil, fmt.Errorf("pinned_root must set 'common_name' or 'spki_sha256'")
+	}
+	if p.SPKISHA256 == "" {
+		return nil, nil
+	}
+	hash, err := base64.StdEncoding.DecodeString(p.SPKISHA256)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid pinned_root spki_sha256 '%s': must be a base64 SHA-256 hash", p.SPKISHA256)
+	}
+	return hash, nil
+}
+
+// presentChain prepares the chain of cert for handshakes: a bare leaf gets
+// the chain the store builds, extra intermediates are appended, the
+// preferred path is chosen, roots are removed unless sendRoot is set and the
+// SCT policy is applied to the leaf.
+func (s selectorSnapshot) presentChain(cert *tls.Certificate, store Store) error {
+	s.completeChain(cert, store)
+	if err := s.appendIntermediates(cert, store); err != nil {
+		return err
+	}
+	if err := s.selectChain(cert); err != nil {
+		return err
+	}
+	if !s.sendRoot {
+		cert.Certificate = stripRoots(cert.Certificate)
+	}
+	return s.checkSCTs(cert.Leaf)
+}
+
+// completeChain replaces the chain of cert, when it is only a leaf that is
+// not self-signed, with the chain the store builds from the CA certificates
+// of the OS, so the identity's issuers are presented. When the store cannot
+// build one, the bare leaf is kept and the failure logged.
+func (s selectorSnapshot) completeChain(cert *tls.Certificate, store Store) {
+	builder, ok := store.(ChainBuilder)
+	if !ok || len(cert.Certificate) != 1 || cert.Leaf == nil || isSelfSigned(cert.Leaf) {
+		return
+	}
+	chain, err := builder.BuildChain(cert.Leaf)
+	if err != nil || len(chain) == 0 || !chain[0].Equal(cert.Leaf) {
+		if s.logger != nil {
+			s.logger.Warn("identity has no certificate chain and the store could not build one, presenting the leaf only",
+				zap.String("common_name", cert.Leaf.Subject.CommonName),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+	cert.Certificate = serializeCertificateChain(chain)
+}
+
+// stripRoots removes the self-signed certificates following the leaf from a
+// DER chain. A self-signed leaf is kept.
+func stripRoots(chain [][]byte) [][]byte {
+	if len(chain) < 2 {
+		return chain
+	}
+	stripped := chain[:1:1]
+	for _, der := range chain[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err == nil && isSelfSigned(cert) {
+			continue
+		}
+		stripped = append(stripped, der)
+	}
+	return stripped
+}
+
+// selectChain replaces the chain of cert with the path chosen by the
+// selector's chain preference among the paths ending at the pinned root.
+func (s selectorSnapshot) selectChain(cert *tls.Certificate) error {
+	if s.chainPolicy == "" && s.pinnedRootName == "" && s.pinnedRootSPKI == nil {
+		return nil
+	}
+
+	chain, err := parseCertificateChain(cert.Certificate)
+	if err != nil {
+		return err
+	}
+	paths := buildChainPaths(chain)
+	if s.pinnedRootName != "" || s.pinnedRootSPKI != nil {
+		paths = slices.DeleteFunc(paths, func(path []*x509.Certificate) bool {
+			return !s.endsAtPinnedRoot(path)
+		})
+		if len(paths) == 0 {
+			return fmt.Errorf("no certificate chain ends at the pinned root")
+		}
+	}
+	path := preferChainPath(paths, s.chainPolicy, s.chainRootCommonNames)
+	cert.Certificate = serializeCertificateChain(path)
+	return nil
+}
+
+// endsAtPinnedRoot reports whether path ends at the selector's pinned root.
+func (s selectorSnapshot) endsAtPinnedRoot(path []*x509.Certificate) bool {
+	if s.pinnedRootName != "" && pathRootName(path) != s.pinnedRootName {
+		return false
+	}
+	if s.pinnedRootSPKI != nil {
+		top := path[len(path)-1]
+		spki := sha256.Sum256(top.RawSubjectPublicKeyInfo)
+		return isSelfSigned(top) && bytes.Equal(spki[:], s.pinnedRootSPKI)
+	}
+	return true
+}
+
+// buildChainPaths returns the paths from the leaf, the first certificate of
+// chain, through issuers found in the rest of chain. Paths end at a
+// self-signed certificate or where no issuer is available, and are listed in
+// the order of chain so the first path follows the stored chain.
+func buildChainPaths(chain []*x509.Certificate) [][]*x509.Certificate {
+	var paths [][]*x509.Certificate
+	var extend func(path []*x509.Certificate)
+	extend = func(path []*x509.Certificate) {
+		if len(paths) >= maxChainPaths {
+			return
+		}
+		top := path[len(path)-1]
+		extended := false
+		if !isSelfSigned(top) {
+			for _, candidate := range chain[1:] {
+				if containsCertificate(path, candidate) || !issuesAny(candidate, []*x509.Certificate{top}) {
+					continue
+				}
+				extended = true
+				extend(append(slices.Clip(path), candidate))
+			}
+		}
+		if !extended {
+			paths = append(paths, path)
+		}
+	}
+	extend(chain[:1:1])
+	return paths
+}
+
+// preferChainPath returns the path chosen by policy, defaulting to the first.
+func preferChainPath(paths [][]*x509.Certificate, policy string, rootNames []string) []*x509.Certificate {
+	best := paths[0]
+	switch policy {
+	case chainShortest:
+		for _, path := range paths[1:] {
+			if len(path) < len(best) {
+				best = path
+			}
+		}
+	case chainNewestRoot:
+		for _, path := range paths[1:] {
+			if path[len(path)-1].NotBefore.After(best[len(best)-1].NotBefore) {
+				best = path
+			}
+		}
+	case chainRootCommonName:
+		for _, name := range rootNames {
+			for _, path := range paths {
+				if pathRootName(path) == name {
+					return path
+				}
+			}
+		}
+	}
+	return best
+}
+
+// pathRootName returns the common name of the root a path ends at: its
+// topmost certificate when self-signed, otherwise that certificate's issuer.
+func pathRootName(path []*x509.Certificate) string {
+	top := path[len(path)-1]
+	if isSelfSigned(top) {
+		return top.Subject.CommonName
+	}
+	return top.Issuer.CommonName
+}
+
+// normalizeChain returns the chain stored with an identity in leaf-first
+// order, each certificate followed by the certificates that issue it, without
+// duplicates. Some Windows providers return chains unordered or with repeated
+// intermediates, which strict validators reject. Certificates are linked by
+// name, and also by signature when verify is set. Certificates that do not
+// chain to the leaf are kept at the end in their stored order, or dropped
+// when verify is set.
+func normalizeChain(leaf *x509.Certificate, chain []*x509.Certificate, verify bool) []*x509.Certificate {
+	normalized := []*x509.Certificate{leaf}
+	for added := true; added; {
+		added = false
+		for _, candidate := range chain {
+			if containsCertificate(normalized, candidate) || !linksToChain(candidate, normalized, verify) {
+				continue
+			}
+			normalized = append(normalized, candidate)
+			added = true
+		}
+	}
+	if verify {
+		return normalized
+	}
+	return appendMissing(normalized, chain...)
+}
+
+// linksToChain reports whether issuer is named as the issuer of one of the
+// certificates, and with verify also signed it.
+func linksToChain(issuer *x509.Certificate, certs []*x509.Certificate, verify bool) bool {
+	if verify {
+		return issuesAny(issuer, certs)
+	}
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package certstore
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+// crossSignedHierarchy is a leaf issued by an intermediate of Root A, where
+// Root A is also cross-signed by Root B.
+type crossSignedHierarchy struct {
+	leaf, intermediate, rootA, crossA, rootB *x509.Certificate
+}
+
+func newCrossSignedHierarchy(t *testing.T) crossSignedHierarchy {
+	t.Helper()
+
+	rootA := newTestCA(t, "Root A")
+	rootB := newTestCA(t, "Root B")
+	intermediateKey := newTestKey(t)
+	intermediate := &testCA{
+		cert: rootA.issue(t, &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "Intermediate"},
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, intermediateKey.Public()),
+		key: intermediateKey,
+	}
+	crossA := rootB.issue(t, &x509.Certificate{
+		Subject:               rootA.cert.Subject,
+		NotBefore:             time.Now().Add(-10 * time.Minute),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, rootA.key.Public())
+	leaf := intermediate.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "leaf.example.test"}}, newTestKey(t).Public())
+
+	return crossSignedHierarchy{leaf: leaf, intermediate: intermediate.cert, rootA: rootA.cert, crossA: crossA, rootB: rootB.cert}
+}
+
+func TestPreferChainPath(t *testing.T) {
+	h := newCrossSignedHierarchy(t)
+	long := []*x509.Certificate{h.leaf, h.intermediate, h.crossA, h.rootB}
+	short := []*x509.Certificate{h.leaf, h.intermediate, h.rootA}
+
+	tests := []struct {
+		name      string
+		chain     []*x509.Certificate
+		policy    string
+		rootNames []string
+		want      []*x509.Certificate
+	}{
+		{"default keeps stored path", []*x509.Certificate{h.leaf, h.intermediate, h.crossA, h.rootB, h.rootA}, "", nil, long},
+		{"shortest", []*x509.Certificate{h.leaf, h.intermediate, h.crossA, h.rootB, h.rootA}, chainShortest, nil, short},
+		{"root common name", []*x509.Certificate{h.leaf, h.intermediate, h.rootA, h.crossA, h.rootB}, chainRootCommonName, []string{"Root C", "Root B"}, long},
+		{"unknown root keeps stored path", []*x509.Certificate{h.leaf, h.intermediate, h.rootA, h.crossA, h.rootB}, chainRootCommonName, []string{"Root C"}, short},
+		{"newest root", []*x509.Certificate{h.leaf, h.intermediate, h.rootA, h.crossA}, chainNewestRoot, nil, []*x509.Certificate{h.leaf, h.intermediate, h.crossA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preferChainPath(buildChainPaths(tt.chain), tt.policy, tt.rootNames)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected path of %d certificates, got %d", len(tt.want), len(got))
+			}
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Fatalf("unexpected certificate %q at path position %d", got[i].Subject.CommonName, i)
+				}
+			}
+		})
+	}
+}
+
+func TestChainPreference_Validate(t *testing.T) {
+	valid := []ChainPreference{
+		{Policy: chainShortest},
+		{Policy: chainNewestRoot},
+		{Policy: chainRootCommonName, RootCommonNames: []string{"Root A"}},
+	}
+	for _, p := range valid {
+		if err := p.validate(); err != nil {
+			t.Fatalf("expected %+v to be valid: %v", p, err)
+		}
+	}
+
+	assertErrorContains(t, (&ChainPreference{Policy: "longest"}).validate(), "unsupported chain_preference policy")
+	assertErrorContains(t, (&ChainPreference{Policy: chainRootCommonName}).validate(), "requires root_common_names")
+}
+
+func TestSelectorSnapshot_SelectChainPinnedRoot(t *testing.T) {
+	h := newCrossSignedHierarchy(t)
+	stored := []*x509.Certificate{h.leaf, h.intermediate, h.rootA, h.crossA, h.rootB}
+	rootASPKI := sha256.Sum256(h.rootA.RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name     string
+		snapshot selectorSnapshot
+		wantTop  *x509.Certificate
+	}{
+		{"common name", selectorSnapshot{pinnedRootName: "Root B"}, h.rootB},
+		{"spki", selectorSnapshot{pinnedRootSPKI: rootASPKI[:]}, h.rootA},
+		{"pin with preference", selectorSnapshot{pinnedRootName: "Root B", chainPolicy: chainShortest}, h.rootB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := tls.Certificate{Certificate: serializeCertificateChain(stored)}
+			if err := tt.snapshot.selectChain(&cert); err != nil {
+				t.Fatalf("selectChain failed: %v", err)
+			}
+			top := cert.Certificate[len(cert.Certificate)-1]
+			if string(top) != string(tt.wantTop.Raw) {
+				t.Fatal("expected the path to end at the pinned root")
+			}
+		})
+	}
+
+	cert := tls.Certificate{Certificate: serializeCertificateChain(stored)}
+	err := selectorSnapshot{pinnedRootName: "Root C"}.selectChain(&cert)
+	assertErrorContains(t, err, "no certificate chain ends at the pinned root")
+}
+
+func TestPinnedRoot_DecodeSPKI(t *testing.T) {
+	hash := sha256.Sum256([]byte("spki"))
+	spki, err := (&PinnedRoot{SPKISHA256: base64.StdEncoding.EncodeToString(hash[:])}).decodeSPKI()
+	if err != nil || len(spki) != sha256.Size {
+		t.Fatalf("expected decoded hash, got %x, %v", spki, err)
+	}
+
+	_, err = (&PinnedRoot{}).decodeSPKI()
+	assertErrorContains(t, err, "must set 'common_name' or 'spki_sha256'")
+	_, err = (&PinnedRoot{SPKISHA256: "c2hvcnQ="}).decodeSPKI()
+	assertErrorContains(t, err, "invalid pinned_root spki_sha256")
+}
+
+func TestNormalizeChain(t *testing.T) {
+	h := newCrossSignedHierarchy(t)
+	impostor := newTestCA(t, "Other").issue(t, &x509.Certificate{
+		Subject:               h.intermediate.Subject,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, newTestKey(t).Public())
+	unrelated := newTestCA(t, "Unrelated").cert
+
+	tests := map[string]struct {
+		chain  []*x509.Certificate
+		verify bool
+		want   []*x509.Certificate
+	}{
+		"ordered": {
+			chain: []*x509.Certificate{h.leaf, h.intermediate, h.rootA},
+			want:  []*x509.Certificate{h.leaf, h.intermediate, h.rootA},
+		},
+		"unordered with duplicates": {
+			chain: []*x509.Certificate{h.rootA, h.intermediate, h.leaf, h.intermediate, h.rootA},
+			want:  []*x509.Certificate{h.leaf, h.intermediate, h.rootA},
+		},
+		"missing leaf": {
+			chain: []*x509.Certificate{h.intermediate},
+			want:  []*x509.Certificate{h.leaf, h.intermediate},
+		},
+		"unlinked kept last": {
+			chain: []*x509.Certificate{unrelated, h.leaf, h.intermediate},
+			want:  []*x509.Certificate{h.leaf, h.intermediate, unrelated},
+		},
+		"unlinked dropped when verifying": {
+			chain:  []*x509.Certificate{unrelated, h.leaf, h.intermediate},
+			verify: true,
+			want:   []*x509.Certificate{h.leaf, h.intermediate},
+		},
+		"bad signature dropped when verifying": {
+			chain:  []*x509.Certificate{h.leaf, impostor, h.intermediate, h.rootA},
+			verify: true,
+			want:   []*x509.Certificate{h.leaf, h.intermediate, h.rootA},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := normalizeChain(h.leaf, tt.chain, tt.verify)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d certificates, got %d", len(tt.want), len(got))
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Fatalf("certificate %d: expected %q, got %q", i, tt.want[i].Subject.CommonName, got[i].Subject.CommonName)
+				}
+			}
+		})
+	}
+}
+
+func TestCertSelector_SendRoot(t *testing.T) {
+	h := newCrossSignedHierarchy(t)
+
+	tests := map[string]struct {
+		sendRoot bool
+		want     []*x509.Certificate
+	}{
+		"root stripped by default": {want: []*x509.Certificate{h.leaf, h.intermediate}},
+		"root sent on request":     {sendRoot: true, want: []*x509.Certificate{h.leaf, h.intermediate, h.rootA}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithChain([]*x509.Certificate{h.leaf, h.intermediate, h.rootA}, newFakeSigner(h.leaf.PublicKey, []byte("ok"))))
+
+			selector := newTestSelector("^leaf\\.example\\.test$")
+			selector.SendRoot = tt.sendRoot
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+
+			if len(cert.Certificate) != len(tt.want) {
+				t.Fatalf("expected chain of %d certificates, got %d", len(tt.want), len(cert.Certificate))
+			}
+			for i, want := range tt.want {
+				if string(cert.Certificate[i]) != string(want.Raw) {
+					t.Fatalf("unexpected certificate at chain position %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStripRoots_KeepsSelfSignedLeaf(t *testing.T) {
+	leaf := newTestCA(t, "self-signed.example.test").cert
+
+	chain := stripRoots([][]byte{leaf.Raw})
+	if len(chain) != 1 || string(chain[0]) != string(leaf.Raw) {
+		t.Fatalf("expected the self-signed leaf to be kept, got %d certificates", len(chain))
+	}
+}
+
+// chainBuildingStore builds the chain of a leaf as an OS store would.
+type chainBuildingStore struct {
+	fakeStore
+	chain []*x509.Certificate
+	err   error
+}
+
+func (s *chainBuildingStore) BuildChain(*x509.Certificate) ([]*x509.Certificate, error) {
+	return s.chain, s.err
+}
+
+func TestSelectorSnapshot_CompleteChain(t *testing.T) {
+	h := newCrossSignedHierarchy(t)
+	builtChain := []*x509.Certificate{h.leaf, h.intermediate, h.rootA}
+
+	tests := map[string]struct {
+		chain []*x509.Certificate
+		store Store
+		want  []*x509.Certificate
+	}{
+		"bare leaf":                {chain: []*x509.Certificate{h.leaf}, store: &chainBuildingStore{chain: builtChain}, want: builtChain},
+		"stored chain kept":        {chain: []*x509.Certificate{h.leaf, h.intermediate}, store: &chainBuildingStore{chain: builtChain}, want: []*x509.Certificate{h.leaf, h.intermediate}},
+		"build failure":            {chain: []*x509.Certificate{h.leaf}, store: &chainBuildingStore{err: errors.New("no issuer")}, want: []*x509.Certificate{h.leaf}},
+		"chain of another leaf":    {chain: []*x509.Certificate{h.leaf}, store: &chainBuildingStore{chain: []*x509.Certificate{h.intermediate, h.rootA}}, want: []*x509.Certificate{h.leaf}},
+		"store without a builder":  {chain: []*x509.Certificate{h.leaf}, store: &fakeStore{}, want: []*x509.Certificate{h.leaf}},
+		"self-signed leaf skipped": {chain: []*x509.Certificate{h.rootB}, store: &chainBuildingStore{chain: builtChain}, want: []*x509.Certificate{h.rootB}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cert := tls.Certificate{Leaf: tt.chain[0], Certificate: serializeCertificateChain(tt.chain)}
+			selectorSnapshot{}.completeChain(&cert, tt.store)
+
+			if len(cert.Certificate) != len(tt.want) {
+				t.Fatalf("expected chain of %d certificates, got %d", len(tt.want), len(cert.Certificate))
+			}
+			for i, want := range tt.want {
+				if string(cert.Certificate[i]) != string(want.Raw) {
+					t.Fatalf("unexpected certificate at chain position %d", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(ClientCertificate{})
+}
+
+// ClientCertificateProvider supplies an outbound TLS client certificate. It
+// is implemented by the certstore.client_cert module, so that any module
+// needing a client certificate, not just reverse proxy transports, can load
+// it as a guest module.
+type ClientCertificateProvider interface {
+	// GetClientCertificate is suitable for tls.Config.GetClientCertificate.
+	GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+}
+
+// ClientCertificate is a guest module providing a client certificate from
+// the OS certificate store to host modules that load it, for example in a
+// field with the caddy:"namespace=certstore inline_key=provider" tag:
+//
+//	"client_certificate": {
+//		"provider": "client_cert",
+//		"selector": {"pattern": "^client\\.example\\.com$"}
+//	}
+type ClientCertificate struct {
+	// Selector chooses the certificate from the OS certificate store.
+	Selector *CertSelector `json:"selector,omitempty"`
+
+	// Ref references a selector defined by name in the certstore app. It is
+	// mutually exclusive with Selector.
+	Ref string `json:"ref,omitempty"`
+
+	selector *CertSelector
+}
+
+// CaddyModule returns the Caddy module information.
+func (ClientCertificate) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "certstore.client_cert",
+		New: func() caddy.Module { return new(ClientCertificate) },
+	}
+}
+
+// Provision loads the selected certificate into the app's cache.
+func (c *ClientCertificate) Provision(ctx caddy.Context) error {
+	if c.Selector == nil && c.Ref == "" {
+		return fmt.Errorf("client_cert must set 'selector' or 'ref'")
+	}
+	if c.Selector != nil && c.Ref != "" {
+		return fmt.Errorf("selector and ref are mutually exclusive")
+	}
+
+	selector, err := resolveSelector(ctx, c.Selector, c.Ref)
+	if err != nil {
+		return err
+	}
+	c.selector = selector
+	return nil
+}
+
+// GetClientCertificate implements ClientCertificateProvider.
+func (c *ClientCertificate) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return selectorClientCertificate(c.selector, cri)
+}
+
+// ConfigureTLS sets cfg to present the certificate during handshakes.
+func (c *ClientCertificate) ConfigureTLS(cfg *tls.Config) {
+	cfg.GetClientCertificate = c.GetClientCertificate
+}
+
+// Cleanup implements caddy.CleanerUpper. Named selectors are owned and
+// released by the certstore app.
+func (c *ClientCertificate) Cleanup() error {
+	if c.Selector != nil {
+		c.Selector.release()
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up an inline selector from Caddyfile tokens, with
+// the same syntax as the transport's client_certificate block.
+func (c *ClientCertificate) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	c.Selector = new(CertSelector)
+	return c.Selector.UnmarshalCaddyfile(d)
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner         = (*ClientCertificate)(nil)
+	_ caddy.CleanerUpper        = (*ClientCertificate)(nil)
+	_ caddyfile.Unmarshaler     = (*ClientCertificate)(nil)
+	_ ClientCertificateProvider = (*ClientCertificate)(nil)
+)
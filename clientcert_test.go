@@ -0,0 +1,52 @@
+package certstore
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestClientCertificate_Provision(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "guest.example.test", key)
+	load := newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok")))
+	withFakeStoreLoads(t, load)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	c := &ClientCertificate{Selector: &CertSelector{Pattern: "^guest\\.example\\.test$", Location: "user"}}
+	if err := c.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+
+	var cfg tls.Config
+	c.ConfigureTLS(&cfg)
+	got, err := cfg.GetClientCertificate(supportedCertificateRequestInfo())
+	if err != nil {
+		t.Fatalf("GetClientCertificate failed: %v", err)
+	}
+	if got.Leaf == nil || !got.Leaf.Equal(cert) {
+		t.Fatal("expected the selected certificate")
+	}
+
+	if err := c.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if load.identity.closeCount() != 1 || load.store.closeCount() != 1 {
+		t.Fatalf("expected resources to close on cleanup, got identity=%d store=%d", load.identity.closeCount(), load.store.closeCount())
+	}
+}
+
+func TestClientCertificate_ProvisionErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	assertErrorContains(t, (&ClientCertificate{}).Provision(ctx), "must set 'selector' or 'ref'")
+	both := &ClientCertificate{Selector: newTestSelector("^guest$"), Ref: "banking"}
+	assertErrorContains(t, both.Provision(ctx), "mutually exclusive")
+}
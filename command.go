@@ -0,0 +1,209 @@
+package certstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+)
+
+// commandOutputVersion is the schema version of the JSON written by the
+// certstore commands. It changes only when fields are removed or change
+// meaning; new fields may be added without a version change.
+const commandOutputVersion = 1
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "certstore",
+		Short: "Inspects OS certificate stores",
+		Long: `
+Inspects the OS certificate stores the certstore module selects client
+certificates from.
+
+Every subcommand accepts --json to write a stable, versioned JSON document
+instead of human-readable output, for use by automation and configuration
+generation tooling.`,
+		CobraFunc: func(cmd *cobra.Command) {
+			list := &cobra.Command{
+				Use:   "list [--location <location>] [--store-name <name>] [--pattern <pattern>] [--match-mode <mode>] [--field <field>] [--thumbprint <hex>] [--json]",
+				Short: "Lists the identities in a certificate store",
+				Long: `
+Lists the identities (certificates with a private key) in a certificate
+store, optionally only those a selector with the given pattern, field and
+thumbprint would match. With --location any, a store that fails to open is
+reported and the other stores are still listed.`,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdList),
+			}
+			list.Flags().StringP("location", "l", "user", "Certificate store location: user, system (or machine), any, nssdb, piv or directory")
+			list.Flags().String("store-name", "", "Windows logical store to list instead of Personal, such as WebHosting, the NSS database directory, the PIV slot or the directory of PEM and PKCS#12 files")
+			list.Flags().StringP("pattern", "p", "", "Only list identities whose field matches this pattern")
+			list.Flags().String("match-mode", "regex", "How the pattern matches: regex, exact or glob")
+			list.Flags().StringP("field", "f", "subject", "Field the pattern matches: subject, issuer, issuer_dn, serial, dns_names, ou or organization")
+			list.Flags().String("thumbprint", "", "Only list the identity with this SHA-1 or SHA-256 thumbprint")
+			addJSONFlag(list)
+			cmd.AddCommand(list)
+		},
+	})
+}
+
+// addJSONFlag adds the --json flag shared by all certstore subcommands.
+func addJSONFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("json", false, "Write machine-readable JSON output")
+}
+
+// identityListing describes a store identity in the output of
+// 'caddy certstore list'.
+type identityListing struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	DNSNames     []string  `json:"dns_names"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	Thumbprint   string    `json:"sha256_thumbprint"`
+}
+
+// storeError describes a store that could not be listed in the output of
+// 'caddy certstore list'.
+type storeError struct {
+	Location string `json:"location"`
+	Error    string `json:"error"`
+}
+
+// listOutput is the JSON document written by 'caddy certstore list --json'.
+type listOutput struct {
+	Version    int               `json:"version"`
+	Location   string            `json:"location"`
+	Identities []identityListing `json:"identities"`
+	Errors     []storeError      `json:"errors,omitempty"`
+}
+
+func cmdList(fl caddycmd.Flags) (int, error) {
+	location := normalizeStoreLocation(fl.String("location"))
+
+	criteria, err := listCriteria(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	identities, storeErrors, err := listIdentities(location, fl.String("store-name"), criteria)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	output := listOutput{Version: commandOutputVersion, Location: location, Identities: identities, Errors: storeErrors}
+	if fl.Bool("json") {
+		return writeJSONOutput(os.Stdout, output)
+	}
+	for _, storeErr := range storeErrors {
+		fmt.Fprintf(os.Stderr, "skipping %s store: %s\n", storeErr.Location, storeErr.Error)
+	}
+	return writeListTable(os.Stdout, output)
+}
+
+// listCriteria returns the criteria of the list command's filter flags, or
+// nil when none is set.
+func listCriteria(fl caddycmd.Flags) (*matchCriteria, error) {
+	pattern, thumbprint := fl.String("pattern"), fl.String("thumbprint")
+	if pattern == "" && thumbprint == "" {
+		return nil, nil
+	}
+
+	field := normalizeSelectorField(fl.String("field"))
+	re, err := compileFieldPattern(field, pattern, fl.String("match-mode"))
+	if err != nil {
+		return nil, err
+	}
+	criteria := &matchCriteria{pattern: re, field: field}
+	if thumbprint != "" {
+		if criteria.thumbprint, err = parseThumbprint(thumbprint); err != nil {
+			return nil, err
+		}
+	}
+	return criteria, nil
+}
+
+// listIdentities describes the identities in the stores of location, or in
+// their logical store storeName when set. When criteria is set, only the
+// matching identities are listed. As in selector enumeration, a store that
+// fails to open is skipped and reported in storeErrors; an error is only
+// returned when no store opened.
+func listIdentities(location, storeName string, criteria *matchCriteria) (listings []identityListing, storeErrors []storeError, err error) {
+	listings = []identityListing{}
+	var errs []error
+	locations := storeLocations(location)
+	for _, storeLocation := range locations {
+		store, identities, err := openStoreIdentities(context.Background(), storeLocation, storeName)
+		if err != nil {
+			errs = append(errs, err)
+			storeErrors = append(storeErrors, storeError{Location: string(storeLocation), Error: err.Error()})
+			continue
+		}
+		for _, identity := range identities {
+			if criteria == nil || criteria.matches(identity) {
+				if listing, err := describeIdentity(identity); err == nil {
+					listings = append(listings, listing)
+				}
+			}
+			identity.Close()
+		}
+		store.Close()
+	}
+	if len(errs) == len(locations) {
+		return nil, nil, errors.Join(errs...)
+	}
+	return listings, storeErrors, nil
+}
+
+func describeIdentity(identity Identity) (identityListing, error) {
+	cert, err := identity.Certificate()
+	if err != nil {
+		return identityListing{}, err
+	}
+	return identityListing{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		DNSNames:     append([]string{}, cert.DNSNames...),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Thumbprint:   makeLeafThumbprint(cert),
+	}, nil
+}
+
+// writeJSONOutput writes v as indented JSON, the output format of --json.
+func writeJSONOutput(w io.Writer, v any) (int, error) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("writing JSON output: %w", err)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func writeListTable(w io.Writer, output listOutput) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBJECT\tISSUER\tSERIAL\tNOT AFTER\tTHUMBPRINT")
+	for _, identity := range output.Identities {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			identity.Subject,
+			identity.Issuer,
+			identity.SerialNumber,
+			identity.NotAfter.Format(time.DateOnly),
+			thumbprintPrefix(identity.Thumbprint),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
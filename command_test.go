@@ -0,0 +1,91 @@
+package certstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestListIdentities(t *testing.T) {
+	key := newTestKey(t)
+	matching := newFakeIdentity(newTestCertificate(t, "list.example.test", key), newFakeSigner(key.Public(), nil))
+	other := newFakeIdentity(newTestCertificate(t, "other.example.test", key), newFakeSigner(key.Public(), nil))
+	load := newFakeStoreLoadWithIdentities(matching, other)
+	withFakeStoreLoads(t, load)
+
+	criteria := &matchCriteria{pattern: regexp.MustCompile("^list\\."), field: "subject"}
+	listings, _, err := listIdentities("user", "", criteria)
+	if err != nil {
+		t.Fatalf("listIdentities failed: %v", err)
+	}
+	if len(listings) != 1 || listings[0].Subject != "CN=list.example.test" {
+		t.Fatalf("expected only the matching identity, got %+v", listings)
+	}
+	if listings[0].Thumbprint != makeLeafThumbprint(matching.cert) {
+		t.Fatal("expected the listing to carry the SHA-256 thumbprint")
+	}
+	if matching.closeCount() != 1 || other.closeCount() != 1 || load.store.closeCount() != 1 {
+		t.Fatal("expected every identity and the store to be closed")
+	}
+}
+
+func TestListIdentities_AnySkipsFailedStore(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("location any searches a single store on macOS")
+	}
+
+	key := newTestKey(t)
+	withFakeStoreLoads(t,
+		&fakeStoreLoad{openErr: errors.New("failed to open user cert store")},
+		newFakeStoreLoad(newTestCertificate(t, "system.example.test", key), newFakeSigner(key.Public(), nil)),
+	)
+
+	listings, storeErrors, err := listIdentities("any", "", nil)
+	if err != nil {
+		t.Fatalf("expected the stores that opened to be listed, got %v", err)
+	}
+	if len(listings) != 1 || listings[0].Subject != "CN=system.example.test" {
+		t.Fatalf("expected the system store's identity, got %+v", listings)
+	}
+	if len(storeErrors) != 1 || storeErrors[0].Location != "user" {
+		t.Fatalf("expected the user store's error to be reported, got %+v", storeErrors)
+	}
+}
+
+func TestListIdentities_AllStoresFail(t *testing.T) {
+	withFakeStoreLoads(t, &fakeStoreLoad{openErr: errors.New("failed to open user cert store")})
+
+	_, _, err := listIdentities("user", "", nil)
+	assertErrorContains(t, err, "failed to open user cert store")
+}
+
+func TestListOutput_JSONSchema(t *testing.T) {
+	var buf bytes.Buffer
+	output := listOutput{Version: commandOutputVersion, Location: "user", Identities: []identityListing{{Subject: "CN=a"}}}
+	if _, err := writeJSONOutput(&buf, output); err != nil {
+		t.Fatalf("writeJSONOutput failed: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if doc["version"] != float64(commandOutputVersion) {
+		t.Fatalf("expected schema version %d, got %v", commandOutputVersion, doc["version"])
+	}
+
+	identity := doc["identities"].([]any)[0].(map[string]any)
+	fields := make([]string, 0, len(identity))
+	for field := range identity {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	expected := []string{"dns_names", "issuer", "not_after", "not_before", "serial_number", "sha256_thumbprint", "subject"}
+	if !slices.Equal(fields, expected) {
+		t.Fatalf("identity schema changed: got %v, want %v", fields, expected)
+	}
+}
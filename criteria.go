@@ -0,0 +1,92 @@
+package certstore
+
+import (
+	"cmp"
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// selectorFields are the certificate fields a pattern can match.
+var selectorFields = []string{"subject", "issuer", "issuer_dn", "serial", "dns_names", "ou", "organization"}
+
+// FieldMatch is a criterion of a composite selector: a pattern matched
+// against one certificate field.
+type FieldMatch struct {
+	// Field is the certificate field to match, as the selector option of
+	// the same name. Default: "subject"
+	Field string `json:"field,omitempty"`
+
+	// Pattern is matched against the field according to MatchMode.
+	// Required.
+	Pattern string `json:"pattern"`
+
+	// MatchMode sets how Pattern is interpreted, as the selector option of
+	// the same name. Default: the selector's match_mode
+	MatchMode string `json:"match_mode,omitempty"`
+}
+
+// fieldMatcher is a compiled FieldMatch.
+type fieldMatcher struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// compileFieldMatches compiles the criteria of all_of or any_of, named by
+// option in errors. Patterns without a match mode use defaultMode.
+func compileFieldMatches(option string, matches []*FieldMatch, defaultMode string) ([]fieldMatcher, error) {
+	matchers := make([]fieldMatcher, 0, len(matches))
+	for i, match := range matches {
+		field := normalizeSelectorField(match.Field)
+		if !slices.Contains(selectorFields, field) {
+			return nil, fmt.Errorf("%s[%d]: unsupported field '%s'", option, i, match.Field)
+		}
+		if match.Pattern == "" {
+			return nil, fmt.Errorf("%s[%d]: pattern is required", option, i)
+		}
+		pattern, err := compileFieldPattern(field, match.Pattern, cmp.Or(match.MatchMode, defaultMode))
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", option, i, err)
+		}
+		matchers = append(matchers, fieldMatcher{field: field, pattern: pattern})
+	}
+	return matchers, nil
+}
+
+func (f fieldMatcher) matches(cert *x509.Certificate) bool {
+	return fieldMatches(f.field, f.pattern, cert)
+}
+
+// fieldMatches reports whether the pattern matches the certificate field.
+// Serial numbers are matched in decimal and in lower case hex without
+// leading zeros, and multi-valued subject attributes match if any of their
+// values does.
+func fieldMatches(field string, pattern *regexp.Regexp, cert *x509.Certificate) bool {
+	switch field {
+	case "serial":
+		return pattern.MatchString(cert.SerialNumber.String()) || pattern.MatchString(cert.SerialNumber.Text(16))
+	case "ou":
+		return slices.ContainsFunc(cert.Subject.OrganizationalUnit, pattern.MatchString)
+	case "organization":
+		return slices.ContainsFunc(cert.Subject.Organization, pattern.MatchString)
+	default:
+		return pattern.MatchString(getFieldSelector(field)(cert))
+	}
+}
+
+// fieldsMatch reports whether the certificate matches every criterion of
+// allOf and, when anyOf is not empty, at least one of anyOf.
+func fieldsMatch(cert *x509.Certificate, allOf, anyOf []fieldMatcher) bool {
+	for _, matcher := range allOf {
+		if !matcher.matches(cert) {
+			return false
+		}
+	}
+	if len(anyOf) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(anyOf, func(matcher fieldMatcher) bool {
+		return matcher.matches(cert)
+	})
+}
@@ -0,0 +1,109 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"regexp"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestCertSelector_CompositeCriteria(t *testing.T) {
+	key := newTestKey(t)
+	issue := func(issuer, dnsName string, usage x509.ExtKeyUsage) *x509.Certificate {
+		return newTestCA(t, issuer).issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "device.example.test"},
+			DNSNames:    []string{dnsName},
+			ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		}, key.Public())
+	}
+	wanted := issue("Corp Issuing CA 2", "b.example.test", x509.ExtKeyUsageClientAuth)
+	certs := []*x509.Certificate{
+		wanted,
+		issue("Corp Issuing CA 1", "c.example.test", x509.ExtKeyUsageClientAuth),
+		issue("Corp Issuing CA 3", "a.example.test", x509.ExtKeyUsageServerAuth),
+		issue("Other CA", "a.example.test", x509.ExtKeyUsageClientAuth),
+	}
+	identities := make([]*fakeIdentity, 0, len(certs))
+	for _, cert := range certs {
+		identities = append(identities, newFakeIdentity(cert, newFakeSigner(key.Public(), []byte("ok"))))
+	}
+	withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+	resetCertificateCache(t)
+
+	selector := newTestSelector("^device\\.example\\.test$")
+	selector.AllOf = []*FieldMatch{{Field: "issuer", Pattern: "Corp Issuing CA *", MatchMode: matchGlob}}
+	selector.AnyOf = []*FieldMatch{
+		{Field: "dns_names", Pattern: "a.example.test", MatchMode: matchExact},
+		{Field: "dns_names", Pattern: "^b\\."},
+	}
+	selector.ExtendedKeyUsages = []string{"clientAuth"}
+	if err := selector.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if err := selector.compileCriteria(caddy.NewReplacer()); err != nil {
+		t.Fatalf("compileCriteria failed: %v", err)
+	}
+
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+	if cert.Leaf.SerialNumber.Cmp(wanted.SerialNumber) != 0 {
+		t.Fatalf("expected the only certificate matching every criterion, got issuer %q", cert.Leaf.Issuer.CommonName)
+	}
+}
+
+func TestCompileFieldMatches_Errors(t *testing.T) {
+	tests := map[string]*FieldMatch{
+		"unsupported field 'email'": {Field: "email", Pattern: "x"},
+		"pattern is required":       {Field: "issuer"},
+		"invalid regex pattern":     {Field: "issuer", Pattern: "("},
+	}
+	for want, match := range tests {
+		_, err := compileFieldMatches("all_of", []*FieldMatch{match}, "")
+		assertErrorContains(t, err, "all_of[0]: "+want)
+	}
+}
+
+func TestGetFieldSelector_IssuerDN(t *testing.T) {
+	cert := &x509.Certificate{Issuer: pkix.Name{
+		CommonName:         "Issuing CA",
+		OrganizationalUnit: []string{"PKI"},
+		Organization:       []string{"Example Corp"},
+		Country:            []string{"US"},
+	}}
+
+	if got := getFieldSelector("issuer")(cert); got != "Issuing CA" {
+		t.Fatalf("expected the issuer common name, got %q", got)
+	}
+	if got := getFieldSelector("issuer_dn")(cert); got != "CN=Issuing CA,OU=PKI,O=Example Corp,C=US" {
+		t.Fatalf("expected the issuer distinguished name, got %q", got)
+	}
+}
+
+func TestFieldMatches_SubjectAttributes(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{
+		CommonName:         "svc.example.test",
+		OrganizationalUnit: []string{"Staff", "Service Identities"},
+		Organization:       []string{"Example Corp"},
+	}}
+
+	tests := []struct {
+		field, pattern string
+		want           bool
+	}{
+		{"ou", "^Service Identities$", true},
+		{"ou", "^Staff$", true},
+		{"ou", "^Devices$", false},
+		{"organization", "^Example Corp$", true},
+		{"organization", "^Other Corp$", false},
+	}
+	for _, tt := range tests {
+		if got := fieldMatches(tt.field, regexp.MustCompile(tt.pattern), cert); got != tt.want {
+			t.Errorf("%s %q: expected %t, got %t", tt.field, tt.pattern, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	"go.uber.org/zap"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// devIdentityLifetime is the validity of generated development identities.
+const devIdentityLifetime = 365 * 24 * time.Hour
+
+// validateDevSelfSigned checks that the development identity the selector
+// generates would be imported into a store it searches and selected.
+func (cs *CertSelector) validateDevSelfSigned() error {
+	if cs.DevSelfSigned == "" {
+		return nil
+	}
+	if location := normalizeStoreLocation(cs.Location); location != "user" && location != "any" {
+		return fmt.Errorf("dev_self_signed imports into the user store: location must be 'user' or 'any'")
+	}
+	if normalizeSelectorField(cs.Field) == "serial" {
+		return fmt.Errorf("dev_self_signed cannot be used with field 'serial'")
+	}
+	if !cs.pattern.MatchString(cs.DevSelfSigned) {
+		return fmt.Errorf("dev_self_signed common name '%s' does not match pattern '%s'", cs.DevSelfSigned, cs.Pattern)
+	}
+	return nil
+}
+
+// generateDevIdentity creates a self-signed client certificate and key for
+// the selector's development common name and imports it into the user store.
+func (s selectorSnapshot) generateDevIdentity() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating development key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("generating development serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: s.devCommonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devIdentityLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if s.field == "dns_names" {
+		template.DNSNames = []string{s.devCommonName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("creating development certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("parsing development certificate: %w", err)
+	}
+
+	password := rand.Text()
+	pfx, err := pkcs12.Encode(rand.Reader, key, cert, nil, password)
+	if err != nil {
+		return fmt.Errorf("encoding development identity: %w", err)
+	}
+	if err := importIdentity(LocationUser, pfx, password); err != nil {
+		return fmt.Errorf("importing development identity: %w", err)
+	}
+
+	if s.logger != nil {
+		s.logger.Warn("no identity matched; generated a self-signed development identity in the user store",
+			zap.String("common_name", s.devCommonName),
+			zap.String("serial_number", cert.SerialNumber.String()),
+			zap.Time("not_after", cert.NotAfter),
+		)
+	}
+	return nil
+}
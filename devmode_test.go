@@ -0,0 +1,89 @@
+package certstore
+
+import (
+	"crypto"
+	"sync"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// devBackend is a user store that keeps the identities imported into it.
+type devBackend struct {
+	mu         sync.Mutex
+	identities []*fakeIdentity
+	imports    int
+}
+
+func (b *devBackend) OpenStore(StoreLocation) (Store, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	store := &devStore{backend: b}
+	for _, identity := range b.identities {
+		store.identities = append(store.identities, newFakeIdentity(identity.cert, identity.signer))
+	}
+	return store, nil
+}
+
+func (b *devBackend) OpenWritableStore(location StoreLocation) (Store, error) {
+	return b.OpenStore(location)
+}
+
+type devStore struct {
+	fakeStore
+	backend *devBackend
+}
+
+func (s *devStore) Import(pfx []byte, password string) error {
+	key, cert, err := pkcs12.Decode(pfx, password)
+	if err != nil {
+		return err
+	}
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	s.backend.identities = append(s.backend.identities, newFakeIdentity(cert, key.(crypto.Signer)))
+	s.backend.imports++
+	return nil
+}
+
+func TestCertSelector_DevSelfSigned(t *testing.T) {
+	resetCertificateCache(t)
+	backend := &devBackend{}
+	t.Cleanup(SetBackend(backend))
+
+	selector := newTestSelector("^dev\\.example\\.test$")
+	selector.DevSelfSigned = "dev.example.test"
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if backend.imports != 1 {
+		t.Fatalf("expected one generated identity to be imported, got %d", backend.imports)
+	}
+	if cert.Leaf.Subject.CommonName != "dev.example.test" || !cert.Leaf.Equal(backend.identities[0].cert) {
+		t.Fatal("expected the generated identity to be selected")
+	}
+}
+
+func TestCertSelector_ValidateDevSelfSigned(t *testing.T) {
+	tests := map[string]struct {
+		location, field, name, contains string
+	}{
+		"system location":   {location: "system", name: "dev.example.test", contains: "location must be 'user' or 'any'"},
+		"serial field":      {location: "user", field: "serial", name: "dev.example.test", contains: "field 'serial'"},
+		"name not matching": {location: "any", name: "other.example.test", contains: "does not match pattern"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			selector := newTestSelector("^dev\\.example\\.test$")
+			selector.Location = tt.location
+			selector.Field = tt.field
+			selector.DevSelfSigned = tt.name
+			assertErrorContains(t, selector.validateDevSelfSigned(), tt.contains)
+		})
+	}
+}
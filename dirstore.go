@@ -0,0 +1,194 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// validateDirectory checks the directory setting.
+func (cs *CertSelector) validateDirectory() error {
+	isDirectory := normalizeStoreLocation(cs.Location) == "directory"
+	if isDirectory && cs.Directory == "" {
+		return fmt.Errorf("location 'directory' requires directory")
+	}
+	if !isDirectory && cs.Directory != "" {
+		return fmt.Errorf("directory requires location 'directory'")
+	}
+	return nil
+}
+
+// openDirectoryStore opens the directory of PEM and PKCS#12 files dir.
+func openDirectoryStore(dir string) (Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the directory location requires a directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening certificate directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("opening certificate directory: %s is not a directory", dir)
+	}
+	return directoryStore{dir: dir}, nil
+}
+
+// directoryStore is a directory of PEM and PKCS#12 files. It needs no OS
+// support, so its location works in every build. Its identities are:
+//   - .pem, .crt and .cer files holding a certificate chain and its private
+//     key, or whose key is in a file of the same name ending in .key or
+//     -key.pem
+//   - .p12 and .pfx files without a password
+//
+// Certificates of PEM files without a key are listed as intermediates.
+type directoryStore struct {
+	dir string
+}
+
+func (s directoryStore) Identities() ([]Identity, error) {
+	identities, _, err := s.load()
+	return identities, err
+}
+
+func (s directoryStore) Intermediates() ([]*x509.Certificate, error) {
+	_, certs, err := s.load()
+	return certs, err
+}
+
+func (directoryStore) Close() {}
+
+// load reads the identities of the directory and the certificates of its
+// PEM files without a key. Files of other types are ignored.
+func (s directoryStore) load() ([]Identity, []*x509.Certificate, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading certificate directory: %w", err)
+	}
+
+	identities := []Identity{}
+	var intermediates []*x509.Certificate
+	for _, entry := range entries {
+		path := filepath.Join(s.dir, entry.Name())
+		// Stat follows the symbolic links of mounted secrets.
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		var identity Identity
+		var certs []*x509.Certificate
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".p12", ".pfx":
+			identity, err = loadPFXFile(path)
+		case ".pem", ".crt", ".cer":
+			identity, certs, err = loadPEMFile(path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if identity != nil {
+			identities = append(identities, identity)
+		}
+		intermediates = append(intermediates, certs...)
+	}
+	return identities, intermediates, nil
+}
+
+// loadPFXFile reads a PKCS#12 file without a password.
+func loadPFXFile(path string) (Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, leaf, intermediates, err := pkcs12.DecodeChain(data, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: decoding pfx: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key type %T", path, key)
+	}
+	return &directoryIdentity{chain: append([]*x509.Certificate{leaf}, intermediates...), signer: signer}, nil
+}
+
+// loadPEMFile reads a PEM file as an identity when its private key is found,
+// and as certificates otherwise. Files holding only a key are skipped.
+func loadPEMFile(path string) (Identity, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		if _, keyErr := parsePEMPrivateKey(data); keyErr == nil {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, err := findPEMKey(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return nil, certs, nil
+	}
+	identity, err := newFileIdentity(path, certs, key)
+	return identity, nil, err
+}
+
+// findPEMKey returns the private key in data or in the key file next to
+// path, or nil when there is none.
+func findPEMKey(path string, data []byte) (crypto.Signer, error) {
+	if key, err := parsePEMPrivateKey(data); err == nil {
+		return key, nil
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, keyPath := range []string{base + ".key", base + "-key.pem"} {
+		keyData, err := os.ReadFile(keyPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePEMPrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyPath, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// directoryIdentity is a certificate chain read from a file and its
+// private key.
+type directoryIdentity struct {
+	chain  []*x509.Certificate
+	signer crypto.Signer
+}
+
+func (i *directoryIdentity) Certificate() (*x509.Certificate, error) {
+	return i.chain[0], nil
+}
+
+func (i *directoryIdentity) CertificateChain() ([]*x509.Certificate, error) {
+	return i.chain, nil
+}
+
+func (i *directoryIdentity) Signer() (crypto.Signer, error) {
+	return i.signer, nil
+}
+
+func (*directoryIdentity) Close() {}
+
+// Interface guards
+var _ IntermediateStore = directoryStore{}
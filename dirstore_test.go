@@ -0,0 +1,173 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// writePEMFile writes the certificates and, if set, the key to path.
+func writePEMFile(t *testing.T, path string, key crypto.Signer, certs ...*x509.Certificate) {
+	t.Helper()
+
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if key != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("marshal key: %v", err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})...)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// loadFromDirectory loads the identity matching pattern from dir.
+func loadFromDirectory(t *testing.T, dir, pattern string, fromStore bool) (*x509.Certificate, int, error) {
+	t.Helper()
+
+	resetCertificateCache(t)
+	selector := newTestSelector(pattern)
+	selector.Location, selector.Directory = "directory", dir
+	if fromStore {
+		selector.ExtraIntermediates = &ExtraIntermediates{FromStore: true}
+	}
+	if err := selector.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer selector.release()
+	return cert.Leaf, len(cert.Certificate), nil
+}
+
+func TestDirectoryStore(t *testing.T) {
+	ca := newTestCA(t, "Directory CA")
+	issue := func(commonName string, key crypto.Signer) *x509.Certificate {
+		return ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}, key.Public())
+	}
+	dir := t.TempDir()
+
+	// The combined identity is issued by an intermediate kept in its own
+	// file, which extra_intermediates from_store appends.
+	intermediateKey := newTestKey(t)
+	intermediate := &testCA{key: intermediateKey, cert: ca.issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Directory Intermediate"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, intermediateKey.Public())}
+	writePEMFile(t, filepath.Join(dir, "intermediate.pem"), nil, intermediate.cert)
+	combinedKey := newTestKey(t)
+	combined := intermediate.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "combined.example.test"}}, combinedKey.Public())
+	writePEMFile(t, filepath.Join(dir, "combined.pem"), combinedKey, combined)
+
+	separateKey := newTestKey(t)
+	separate := issue("separate.example.test", separateKey)
+	writePEMFile(t, filepath.Join(dir, "separate.crt"), nil, separate)
+	writePEMFile(t, filepath.Join(dir, "separate.key"), separateKey)
+
+	cfsslKey := newTestKey(t)
+	cfssl := issue("cfssl.example.test", cfsslKey)
+	writePEMFile(t, filepath.Join(dir, "cfssl.pem"), nil, cfssl)
+	writePEMFile(t, filepath.Join(dir, "cfssl-key.pem"), cfsslKey)
+
+	pfxKey := newTestKey(t)
+	pfxCert := issue("pfx.example.test", pfxKey)
+	pfx, err := pkcs12.Encode(rand.Reader, pfxKey, pfxCert, []*x509.Certificate{ca.cert}, "")
+	if err != nil {
+		t.Fatalf("encode pfx: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pfx.p12"), pfx, 0o600); err != nil {
+		t.Fatalf("write pfx: %v", err)
+	}
+
+	writePEMFile(t, filepath.Join(dir, "ca.pem"), nil, ca.cert)
+	if err := os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write README: %v", err)
+	}
+
+	tests := []struct {
+		pattern   string
+		want      *x509.Certificate
+		fromStore bool
+		chainLen  int
+	}{
+		{pattern: "^combined", want: combined, chainLen: 1},
+		{pattern: "^separate", want: separate, chainLen: 1},
+		{pattern: "^cfssl", want: cfssl, chainLen: 1},
+		{pattern: "^pfx", want: pfxCert, chainLen: 1},
+		{pattern: "^combined", want: combined, fromStore: true, chainLen: 2},
+	}
+	for _, tt := range tests {
+		leaf, chainLen, err := loadFromDirectory(t, dir, tt.pattern, tt.fromStore)
+		if err != nil {
+			t.Fatalf("%s: load failed: %v", tt.pattern, err)
+		}
+		if !leaf.Equal(tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.pattern, tt.want.Subject, leaf.Subject)
+		}
+		if chainLen != tt.chainLen {
+			t.Errorf("%s (from store %t): expected %d certificates, got %d", tt.pattern, tt.fromStore, tt.chainLen, chainLen)
+		}
+	}
+
+	// The CA certificate has no key, so it is not an identity.
+	_, _, err = loadFromDirectory(t, dir, "^Directory CA$", false)
+	assertErrorContains(t, err, "no identity found")
+}
+
+func TestDirectoryStore_MismatchedKey(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t)
+	writePEMFile(t, filepath.Join(dir, "client.pem"), newTestKey(t), newTestCertificate(t, "client", key))
+
+	_, _, err := loadFromDirectory(t, dir, "^client$", false)
+	assertErrorContains(t, err, "private key does not match the first certificate")
+}
+
+func TestCertSelector_DirectoryValidation(t *testing.T) {
+	tests := []struct {
+		location, directory, storeName string
+		wantErr                        string
+	}{
+		{location: "directory", directory: "/etc/caddy/certs"},
+		{location: "directory", wantErr: "location 'directory' requires directory"},
+		{location: "user", directory: "/etc/caddy/certs", wantErr: "directory requires location 'directory'"},
+		{location: "directory", directory: "/etc/caddy/certs", storeName: "My", wantErr: "cannot be used with location 'directory'"},
+	}
+	for _, tt := range tests {
+		selector := newTestSelector(".*")
+		selector.Location, selector.Directory, selector.StoreName = tt.location, tt.directory, tt.storeName
+		err := selector.validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt, err)
+			}
+			continue
+		}
+		assertErrorContains(t, err, tt.wantErr)
+	}
+}
+
+func TestOpenDirectoryStore_NotADirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.pem")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	_, err := openDirectoryStore(path)
+	assertErrorContains(t, err, "is not a directory")
+}
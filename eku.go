@@ -0,0 +1,156 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// oidExtensionExtendedKeyUsage identifies the Enhanced Key Usage extension.
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	// oidAnyExtendedKeyUsage is the "Any Purpose" usage, valid for every
+	// purpose.
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+// extKeyUsageNames maps the Enhanced Key Usage friendly names shown by
+// certmgr.msc and the short names used by OpenSSL and RFC 5280, such as
+// clientAuth, in lower case, to their object identifiers.
+var extKeyUsageNames = map[string]string{
+	"any purpose":                    "2.5.29.37.0",
+	"server authentication":          "1.3.6.1.5.5.7.3.1",
+	"client authentication":          "1.3.6.1.5.5.7.3.2",
+	"code signing":                   "1.3.6.1.5.5.7.3.3",
+	"secure email":                   "1.3.6.1.5.5.7.3.4",
+	"ip security end system":         "1.3.6.1.5.5.7.3.5",
+	"ip security tunnel termination": "1.3.6.1.5.5.7.3.6",
+	"ip security user":               "1.3.6.1.5.5.7.3.7",
+	"time stamping":                  "1.3.6.1.5.5.7.3.8",
+	"ocsp signing":                   "1.3.6.1.5.5.7.3.9",
+	"ip security ike intermediate":   "1.3.6.1.5.5.8.2.2",
+	"kdc authentication":             "1.3.6.1.5.2.3.5",
+	"smart card logon":               "1.3.6.1.4.1.311.20.2.2",
+	"certificate request agent":      "1.3.6.1.4.1.311.20.2.1",
+	"encrypting file system":         "1.3.6.1.4.1.311.10.3.4",
+	"file recovery":                  "1.3.6.1.4.1.311.10.3.4.1",
+	"document signing":               "1.3.6.1.4.1.311.10.3.12",
+	"key recovery agent":             "1.3.6.1.4.1.311.21.6",
+	"remote desktop authentication":  "1.3.6.1.4.1.311.54.1.2",
+
+	"serverauth":       "1.3.6.1.5.5.7.3.1",
+	"clientauth":       "1.3.6.1.5.5.7.3.2",
+	"codesigning":      "1.3.6.1.5.5.7.3.3",
+	"emailprotection":  "1.3.6.1.5.5.7.3.4",
+	"timestamping":     "1.3.6.1.5.5.7.3.8",
+	"ocspsigning":      "1.3.6.1.5.5.7.3.9",
+	"mssmartcardlogin": "1.3.6.1.4.1.311.20.2.2",
+}
+
+// parseExtKeyUsages converts Enhanced Key Usage friendly or short names,
+// matched case-insensitively, or dotted object identifiers to object
+// identifiers.
+func parseExtKeyUsages(usages []string) ([]asn1.ObjectIdentifier, error) {
+	oids := make([]asn1.ObjectIdentifier, 0, len(usages))
+	for _, usage := range usages {
+		oid, err := parseExtKeyUsage(usage)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+func parseExtKeyUsage(usage string) (asn1.ObjectIdentifier, error) {
+	dotted := usage
+	if known, ok := extKeyUsageNames[strings.ToLower(strings.TrimSpace(usage))]; ok {
+		dotted = known
+	}
+	oid, ok := parseDottedOID(dotted)
+	if !ok {
+		return nil, fmt.Errorf("unknown extended key usage '%s': must be a name such as 'Client Authentication' or 'clientAuth', or an OID", usage)
+	}
+	return oid, nil
+}
+
+// parseDottedOID parses an object identifier in dotted form, such as
+// "1.3.6.1.5.5.7.3.2".
+func parseDottedOID(dotted string) (asn1.ObjectIdentifier, bool) {
+	parts := strings.Split(dotted, ".")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		oid = append(oid, n)
+	}
+	return oid, true
+}
+
+// hasExtKeyUsages reports whether the certificate is valid for all of the
+// usages. As in certmgr.msc, a certificate without the Enhanced Key Usage
+// extension or with "Any Purpose" is valid for all purposes.
+func hasExtKeyUsages(cert *x509.Certificate, usages []asn1.ObjectIdentifier) bool {
+	if len(usages) == 0 {
+		return true
+	}
+	certUsages, ok := certificateExtKeyUsages(cert)
+	if !ok || containsOID(certUsages, oidAnyExtendedKeyUsage) {
+		return true
+	}
+	for _, usage := range usages {
+		if !containsOID(certUsages, usage) {
+			return false
+		}
+	}
+	return true
+}
+
+// issuersPermitExtKeyUsages reports whether every CA in the chain, leaf
+// first, permits the leaf's Enhanced Key Usages, as Windows Schannel requires
+// when it chains usages. A CA without the extension or with "Any Purpose"
+// permits every usage, and so does a leaf without usages of its own.
+func issuersPermitExtKeyUsages(chain []*x509.Certificate) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	leafUsages, ok := certificateExtKeyUsages(chain[0])
+	if !ok || containsOID(leafUsages, oidAnyExtendedKeyUsage) {
+		return true
+	}
+	for _, issuer := range chain[1:] {
+		if !hasExtKeyUsages(issuer, leafUsages) {
+			return false
+		}
+	}
+	return true
+}
+
+// certificateExtKeyUsages returns the object identifiers listed in the
+// certificate's Enhanced Key Usage extension, and false when it has none.
+func certificateExtKeyUsages(cert *x509.Certificate) ([]asn1.ObjectIdentifier, bool) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionExtendedKeyUsage) {
+			continue
+		}
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &oids); err != nil {
+			return nil, true
+		}
+		return oids, true
+	}
+	return nil, false
+}
+
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	return slices.ContainsFunc(oids, oid.Equal)
+}
@@ -0,0 +1,170 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHasExtKeyUsages(t *testing.T) {
+	ca := newTestCA(t, "EKU CA")
+	key := newTestKey(t)
+	smartCardLogon := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+
+	tests := []struct {
+		name     string
+		template *x509.Certificate
+		usages   []string
+		expected bool
+	}{
+		{
+			name:     "friendly names",
+			template: &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, UnknownExtKeyUsage: []asn1.ObjectIdentifier{smartCardLogon}},
+			usages:   []string{"client authentication", "Smart Card Logon"},
+			expected: true,
+		},
+		{
+			name:     "short names",
+			template: &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, UnknownExtKeyUsage: []asn1.ObjectIdentifier{smartCardLogon}},
+			usages:   []string{"clientAuth", "msSmartcardLogin"},
+			expected: true,
+		},
+		{
+			name:     "short name of a missing usage",
+			template: &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}},
+			usages:   []string{"clientAuth"},
+			expected: false,
+		},
+		{
+			name:     "OID",
+			template: &x509.Certificate{UnknownExtKeyUsage: []asn1.ObjectIdentifier{smartCardLogon}},
+			usages:   []string{"1.3.6.1.4.1.311.20.2.2"},
+			expected: true,
+		},
+		{
+			name:     "missing usage",
+			template: &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}},
+			usages:   []string{"Client Authentication", "Smart Card Logon"},
+			expected: false,
+		},
+		{
+			name:     "no extension",
+			template: &x509.Certificate{},
+			usages:   []string{"Smart Card Logon"},
+			expected: true,
+		},
+		{
+			name:     "any purpose",
+			template: &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}},
+			usages:   []string{"Smart Card Logon"},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.template.Subject = pkix.Name{CommonName: "eku.example.test"}
+			cert := ca.issue(t, tt.template, key.Public())
+			usages, err := parseExtKeyUsages(tt.usages)
+			if err != nil {
+				t.Fatalf("parse usages: %v", err)
+			}
+			if got := hasExtKeyUsages(cert, usages); got != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseExtKeyUsages_Invalid(t *testing.T) {
+	for _, usage := range []string{"Smartcard", "1", "1.3.six"} {
+		_, err := parseExtKeyUsages([]string{usage})
+		assertErrorContains(t, err, "unknown extended key usage")
+	}
+}
+
+func TestCertSelector_ExtendedKeyUsages(t *testing.T) {
+	resetCertificateCache(t)
+
+	ca := newTestCA(t, "EKU CA")
+	var identities []*fakeIdentity
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		key := newTestKey(t)
+		cert := ca.issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "eku.example.test"},
+			ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		}, key.Public())
+		identities = append(identities, newFakeIdentity(cert, newFakeSigner(key.Public(), []byte("ok"))))
+	}
+	withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+	selector := newTestSelector("^eku\\.example\\.test$")
+	selector.ExtendedKeyUsages = []string{"Client Authentication"}
+	if err := selector.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if !cert.Leaf.Equal(identities[1].cert) {
+		t.Fatal("expected the client authentication certificate to be selected")
+	}
+}
+
+func TestCertSelector_ValidateEKUChain(t *testing.T) {
+	newRestrictedCA := func(name string, usages ...x509.ExtKeyUsage) *testCA {
+		key := newTestKey(t)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(atomic.AddInt64(&testSerial, 1)),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			ExtKeyUsage:           usages,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		return &testCA{cert: createTestCertificate(t, template, template, key.Public(), key), key: key}
+	}
+	cas := []*testCA{
+		newRestrictedCA("Client CA", x509.ExtKeyUsageClientAuth),
+		newRestrictedCA("Server CA", x509.ExtKeyUsageServerAuth),
+	}
+
+	var identities []*fakeIdentity
+	for _, ca := range cas {
+		key := newTestKey(t)
+		leaf := ca.issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "chained.example.test"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, key.Public())
+		identity := newFakeIdentity(leaf, newFakeSigner(key.Public(), []byte("ok")))
+		identity.chain = []*x509.Certificate{leaf, ca.cert}
+		identities = append(identities, identity)
+	}
+	if !issuersPermitExtKeyUsages(identities[0].chain) || issuersPermitExtKeyUsages(identities[1].chain) {
+		t.Fatal("expected only the client CA to permit client authentication")
+	}
+
+	resetCertificateCache(t)
+	withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+	selector := newTestSelector("^chained\\.example\\.test$")
+	selector.ValidateEKUChain = true
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if !cert.Leaf.Equal(identities[0].cert) {
+		t.Fatal("expected the certificate whose issuer permits its usage to be selected")
+	}
+}
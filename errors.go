@@ -0,0 +1,207 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Errors reported for well-known platform failures. Use errors.Is to test for
+// them; the returned error is a *PlatformError describing the failure.
+var (
+	// ErrCertificateNotFound means the certificate or its private key was not
+	// found in the store (CRYPT_E_NOT_FOUND).
+	ErrCertificateNotFound = errors.New("certificate not found")
+
+	// ErrKeysetUnavailable means the private key container does not exist or
+	// cannot be opened by the Caddy process (NTE_BAD_KEYSET).
+	ErrKeysetUnavailable = errors.New("private key set unavailable")
+
+	// ErrInteractionNotAllowed means the keychain needs user interaction that
+	// the Caddy process cannot show (errSecInteractionNotAllowed).
+	ErrInteractionNotAllowed = errors.New("keychain interaction not allowed")
+
+	// ErrAuthorizationFailed means access to the keychain item was denied
+	// (errSecAuthFailed).
+	ErrAuthorizationFailed = errors.New("keychain authorization failed")
+
+	// ErrAccessDenied means the OS denied the Caddy process access to the
+	// store or private key (ERROR_ACCESS_DENIED, NTE_PERM,
+	// errSecNoAccessForItem).
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// PlatformError is a certificate store failure with a well-known platform
+// error code, translated into an actionable message.
+type PlatformError struct {
+	// Kind is one of the Err* sentinels of this package.
+	Kind error
+
+	// Code is the symbolic name of the platform error code, such as
+	// "NTE_BAD_KEYSET", and Hint explains how to resolve it.
+	Code string
+	Hint string
+
+	// Err is the original error returned by the platform.
+	Err error
+}
+
+func (e *PlatformError) Error() string {
+	return fmt.Sprintf("%v (%s): %s", e.Kind, e.Code, e.Hint)
+}
+
+func (e *PlatformError) Unwrap() error { return e.Err }
+
+func (e *PlatformError) Is(target error) bool { return target == e.Kind }
+
+type platformErrorInfo struct {
+	kind error
+	code string
+	hint string
+}
+
+// windowsErrors maps the HRESULTs returned by CryptoAPI and CNG.
+var windowsErrors = map[uint32]platformErrorInfo{
+	0x80092004: {
+		kind: ErrCertificateNotFound,
+		code: "CRYPT_E_NOT_FOUND",
+		hint: "check the store location and that the certificate was imported with its private key",
+	},
+	0x80090016: {
+		kind: ErrKeysetUnavailable,
+		code: "NTE_BAD_KEYSET",
+		hint: "the key container is missing or the account running Caddy cannot read it; grant it access under Manage Private Keys or re-import the certificate with its key",
+	},
+	0x5: {
+		kind: ErrAccessDenied,
+		code: "ERROR_ACCESS_DENIED",
+		hint: "the account running Caddy cannot open the store or key; run it as an account with access or grant access under Manage Private Keys",
+	},
+	0x80070005: {
+		kind: ErrAccessDenied,
+		code: "E_ACCESSDENIED",
+		hint: "the account running Caddy cannot open the store or key; run it as an account with access or grant access under Manage Private Keys",
+	},
+	0x80090010: {
+		kind: ErrAccessDenied,
+		code: "NTE_PERM",
+		hint: "the private key's permissions deny the account running Caddy; grant it access under Manage Private Keys",
+	},
+}
+
+// darwinErrors maps the OSStatus codes returned by the Security framework.
+var darwinErrors = map[int64]platformErrorInfo{
+	-25308: {
+		kind: ErrInteractionNotAllowed,
+		code: "errSecInteractionNotAllowed",
+		hint: "the keychain is locked or Caddy runs without a user session; unlock the keychain or add Caddy to the private key's access control list",
+	},
+	-25293: {
+		kind: ErrAuthorizationFailed,
+		code: "errSecAuthFailed",
+		hint: "access to the private key was denied; check the key's access control list and the keychain password",
+	},
+	-25243: {
+		kind: ErrAccessDenied,
+		code: "errSecNoAccessForItem",
+		hint: "the keychain item's access control list does not include Caddy; add it to the private key's access control list",
+	},
+}
+
+// cfErrorCode extracts the code of the CFErrors formatted by the certstore
+// package, which does not wrap them.
+var cfErrorCode = regexp.MustCompile(`CFError (-?\d+)`)
+
+// translatePlatformError returns a *PlatformError when err carries a
+// well-known platform error code, and err unchanged otherwise.
+func translatePlatformError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var platformErr *PlatformError
+	if errors.As(err, &platformErr) {
+		return err
+	}
+	info, ok := lookupPlatformError(err)
+	if !ok {
+		return err
+	}
+	return &PlatformError{Kind: info.kind, Code: info.code, Hint: info.hint, Err: err}
+}
+
+// lookupPlatformError walks the error chain looking for a known code.
+func lookupPlatformError(err error) (platformErrorInfo, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			info, ok := windowsErrors[uint32(errno)]
+			return info, ok
+		}
+		if code, ok := osStatusCode(err); ok {
+			info, ok := darwinErrors[code]
+			return info, ok
+		}
+	}
+	return platformErrorInfo{}, false
+}
+
+// accessDeniedKinds are the failures reported when the OS refuses the Caddy
+// process access to a store or private key.
+var accessDeniedKinds = []error{ErrAccessDenied, ErrAuthorizationFailed, ErrInteractionNotAllowed}
+
+func isAccessDenied(err error) bool {
+	for _, kind := range accessDeniedKinds {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAccessDenied logs and counts err when the OS denied access, so
+// permission regressions stand out from selectors that match nothing.
+func recordAccessDenied(logger *zap.Logger, location, operation string, err error) {
+	var platformErr *PlatformError
+	if !isAccessDenied(err) || !errors.As(err, &platformErr) {
+		return
+	}
+
+	certstoreMetrics.accessDenied.WithLabelValues(location, operation, platformErr.Code).Inc()
+	if logger != nil {
+		logger.Warn("OS denied access to the certificate store",
+			zap.String("location", location),
+			zap.String("operation", operation),
+			zap.String("code", platformErr.Code),
+			zap.Error(err),
+		)
+	}
+}
+
+// keychainStatus is an OSStatus returned by this package's own keychain
+// calls.
+type keychainStatus int32
+
+func (s keychainStatus) Error() string { return fmt.Sprintf("OSStatus %d", int32(s)) }
+
+// osStatusCode returns the OSStatus carried by an error from the certstore
+// package, which reports them as an unexported integer type or as a
+// formatted CFError, or by a keychainStatus.
+func osStatusCode(err error) (int64, bool) {
+	if status, ok := err.(keychainStatus); ok {
+		return int64(status), true
+	}
+	v := reflect.ValueOf(err)
+	if v.Type().Name() == "osStatus" && v.CanInt() {
+		return v.Int(), true
+	}
+	if match := cfErrorCode.FindStringSubmatch(err.Error()); match != nil {
+		code, err := strconv.ParseInt(match[1], 10, 64)
+		return code, err == nil
+	}
+	return 0, false
+}
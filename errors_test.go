@@ -0,0 +1,90 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// osStatus mirrors the unexported OSStatus error type of the certstore package.
+type osStatus int32
+
+func (s osStatus) Error() string { return fmt.Sprintf("OSStatus %d", s) }
+
+func TestTranslatePlatformError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		kind     error
+		contains string
+	}{
+		{
+			name:     "CRYPT_E_NOT_FOUND",
+			err:      fmt.Errorf("failed to open system cert store: %w", syscall.Errno(0x80092004)),
+			kind:     ErrCertificateNotFound,
+			contains: "CRYPT_E_NOT_FOUND",
+		},
+		{
+			name:     "NTE_BAD_KEYSET",
+			err:      fmt.Errorf("failed to load identity private key: %w", syscall.Errno(0x80090016)),
+			kind:     ErrKeysetUnavailable,
+			contains: "Manage Private Keys",
+		},
+		{
+			name:     "errSecInteractionNotAllowed",
+			err:      osStatus(-25308),
+			kind:     ErrInteractionNotAllowed,
+			contains: "errSecInteractionNotAllowed",
+		},
+		{
+			name:     "errSecAuthFailed from CFError",
+			err:      errors.New("CFError -25293 (The user name or passphrase you entered is not correct.)"),
+			kind:     ErrAuthorizationFailed,
+			contains: "access control list",
+		},
+		{
+			name:     "NTE_PERM",
+			err:      fmt.Errorf("failed to load identity private key: %w", syscall.Errno(0x80090010)),
+			kind:     ErrAccessDenied,
+			contains: "NTE_PERM",
+		},
+		{
+			name:     "errSecInteractionNotAllowed from a keychain file",
+			err:      fmt.Errorf("opening keychain /Library/Keychains/System.keychain: %w", keychainStatus(-25308)),
+			kind:     ErrInteractionNotAllowed,
+			contains: "errSecInteractionNotAllowed",
+		},
+		{
+			name:     "errSecNoAccessForItem",
+			err:      osStatus(-25243),
+			kind:     ErrAccessDenied,
+			contains: "errSecNoAccessForItem",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translatePlatformError(tt.err)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatal("expected the original error to stay in the chain")
+			}
+			var platformErr *PlatformError
+			if !errors.As(err, &platformErr) {
+				t.Fatalf("expected *PlatformError, got %T", err)
+			}
+			assertErrorContains(t, err, tt.contains)
+		})
+	}
+
+	t.Run("unknown errors are unchanged", func(t *testing.T) {
+		for _, err := range []error{errors.New("bad chain"), syscall.Errno(2), osStatus(-25300)} {
+			if translated := translatePlatformError(err); translated != err {
+				t.Fatalf("expected %v to be unchanged, got %v", err, translated)
+			}
+		}
+	})
+}
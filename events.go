@@ -0,0 +1,115 @@
+package certstore
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+// Certificate lifecycle events emitted through the events app.
+const (
+	// eventLoaded is emitted when a certificate is loaded from the store
+	// into the cache.
+	eventLoaded = "certstore.loaded"
+
+	// eventRotated is emitted when a cached certificate is replaced by a
+	// different one, such as its renewal.
+	eventRotated = "certstore.rotated"
+
+	// eventExpiringSoon is emitted once a cached certificate is within its
+	// rollover window, or defaultExpiringSoonWindow, of expiry.
+	eventExpiringSoon = "certstore.expiring_soon"
+)
+
+const (
+	// defaultExpiringSoonWindow is how long before expiry a certificate is
+	// reported as expiring soon when its selector sets no rollover window.
+	defaultExpiringSoonWindow = 7 * 24 * time.Hour
+
+	// expiryCheckInterval is how often a certificate already expiring soon
+	// is checked again, to notice its replacement.
+	expiryCheckInterval = time.Hour
+)
+
+// eventEmitter emits events through the events app on behalf of the module
+// that provisioned a selector.
+type eventEmitter struct {
+	app *caddyevents.App
+	ctx caddy.Context
+}
+
+// newEventEmitter returns an emitter for the module of ctx, or nil when the
+// events app is not configured.
+func newEventEmitter(ctx caddy.Context) (*eventEmitter, error) {
+	appIface, err := ctx.AppIfConfigured("events")
+	if errors.Is(err, caddy.ErrNotConfigured) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting events app: %w", err)
+	}
+	return &eventEmitter{app: appIface.(*caddyevents.App), ctx: ctx}, nil
+}
+
+// emit emits the event of the cache entry through the emitter of the
+// config that last provisioned one of its selectors, if any.
+func (cached *cachedCert) emit(name string, data map[string]any) {
+	if events := cached.events.Load(); events != nil {
+		events.app.Emit(events.ctx, name, data)
+	}
+}
+
+// setEvents makes events the emitter of the entry, unless it is nil.
+func (cached *cachedCert) setEvents(events *eventEmitter) {
+	if events != nil {
+		cached.events.Store(events)
+	}
+}
+
+// eventData describes the cached certificate for an event. The caller must
+// hold cached.mu.
+func (cached *cachedCert) eventData() map[string]any {
+	leaf := cached.cert.Leaf
+	return map[string]any{
+		"cache_key":     thumbprintPrefix(cached.cacheKey),
+		"location":      cached.selector.location,
+		"common_name":   leaf.Subject.CommonName,
+		"serial_number": leaf.SerialNumber.String(),
+		"thumbprint":    makeLeafThumbprint(leaf),
+		"not_after":     leaf.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// watchExpiry emits eventExpiringSoon once for each cached certificate that
+// comes within the expiry window, until the cache entry is closed.
+func (cached *cachedCert) watchExpiry() {
+	window := cmp.Or(cached.selector.rollover, defaultExpiringSoonWindow)
+	var reported string
+	for {
+		cached.mu.RLock()
+		wait := time.Until(cached.cert.Leaf.NotAfter.Add(-window))
+		thumbprint := makeLeafThumbprint(cached.cert.Leaf)
+		data := cached.eventData()
+		cached.mu.RUnlock()
+
+		if wait <= 0 {
+			if thumbprint != reported {
+				reported = thumbprint
+				cached.emit(eventExpiringSoon, data)
+			}
+			wait = expiryCheckInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-cached.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package certstore
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+// eventRecorder is an events handler passing the events it handles on.
+type eventRecorder chan caddy.Event
+
+func (r eventRecorder) Handle(_ context.Context, e caddy.Event) error {
+	r <- e
+	return nil
+}
+
+// nextEvent returns the next event handled by r.
+func (r eventRecorder) nextEvent(t *testing.T) caddy.Event {
+	t.Helper()
+
+	select {
+	case e := <-r:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return caddy.Event{}
+	}
+}
+
+func TestCachedCertificate_LifecycleEvents(t *testing.T) {
+	resetCertificateCache(t)
+
+	ca := newTestCA(t, "Events CA")
+	key := newTestKey(t)
+	renewedKey := newTestKey(t)
+	original := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "events.example.test"}}, key.Public())
+	// The renewal expires well outside the default expiring soon window.
+	renewal := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "events.example.test"},
+		NotAfter: time.Now().Add(90 * 24 * time.Hour),
+	}, renewedKey.Public())
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(original, newFakeSigner(key.Public(), []byte("original"))),
+		newFakeStoreLoad(renewal, newFakeSigner(renewedKey.Public(), []byte("renewal"))),
+	)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	events := new(caddyevents.App)
+	if err := events.Provision(ctx); err != nil {
+		t.Fatalf("provisioning events app: %v", err)
+	}
+	recorder := make(eventRecorder, 10)
+	if err := events.On("", recorder); err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+
+	selector := newTestSelector("^events\\.example\\.test$")
+	selector.events = &eventEmitter{app: events, ctx: ctx}
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	loaded := recorder.nextEvent(t)
+	if loaded.Name() != eventLoaded || loaded.Data["serial_number"] != original.SerialNumber.String() {
+		t.Fatalf("expected %s for serial %s, got %s %v", eventLoaded, original.SerialNumber, loaded.Name(), loaded.Data)
+	}
+	// The original certificate expires within an hour.
+	expiring := recorder.nextEvent(t)
+	if expiring.Name() != eventExpiringSoon || expiring.Data["thumbprint"] != makeLeafThumbprint(original) {
+		t.Fatalf("expected %s for the original certificate, got %s %v", eventExpiringSoon, expiring.Name(), expiring.Data)
+	}
+
+	if changed, err := selector.cacheEntry.reselect(t.Context()); err != nil || !changed {
+		t.Fatalf("expected the renewal to be swapped in, got changed=%t err=%v", changed, err)
+	}
+	rotated := recorder.nextEvent(t)
+	if rotated.Name() != eventRotated || rotated.Data["serial_number"] != renewal.SerialNumber.String() ||
+		rotated.Data["old_thumbprint"] != makeLeafThumbprint(original) {
+		t.Fatalf("expected %s to the renewal, got %s %v", eventRotated, rotated.Name(), rotated.Data)
+	}
+}
+
+func TestNewEventEmitter_WithoutEventsApp(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	events, err := newEventEmitter(ctx)
+	if err != nil || events != nil {
+		t.Fatalf("expected no emitter without an events app, got %v, %v", events, err)
+	}
+}
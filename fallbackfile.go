@@ -0,0 +1,111 @@
+package certstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// validateFallbackFile checks the fallback_file and fallback_key settings.
+func (cs *CertSelector) validateFallbackFile() error {
+	if cs.FallbackKey != "" && cs.FallbackFile == "" {
+		return fmt.Errorf("fallback_key requires fallback_file")
+	}
+	if cs.FallbackKey != "" && isPFXFile(cs.FallbackFile) {
+		return fmt.Errorf("fallback_key cannot be used with a PKCS#12 fallback_file")
+	}
+	return nil
+}
+
+// isPFXFile reports whether path names a PKCS#12 file.
+func isPFXFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".p12", ".pfx":
+		return true
+	default:
+		return false
+	}
+}
+
+// findFallbackIdentity returns the identity of the fallback file after the
+// store search failed with err, or err itself when no fallback file is set
+// or ctx is done.
+func (s selectorSnapshot) findFallbackIdentity(ctx context.Context, err error) (Store, Identity, error) {
+	if s.fallbackFile == "" || ctx.Err() != nil {
+		return nil, nil, err
+	}
+	identity, fileErr := loadFallbackFile(s.fallbackFile, s.fallbackKey)
+	if fileErr != nil {
+		return nil, nil, errors.Join(err, fmt.Errorf("loading fallback_file: %w", fileErr))
+	}
+	if s.logger != nil {
+		s.logger.Warn("no identity found in the certificate store, using fallback_file",
+			zap.String("location", s.location),
+			zap.String("fallback_file", s.fallbackFile),
+			zap.Error(err),
+		)
+	}
+	return fallbackFileStore{identity: identity}, identity, nil
+}
+
+// loadFallbackFile reads the identity of a PEM or PKCS#12 file. The private
+// key of a PEM file is read from keyFile when set, and otherwise from the
+// file itself or the key file next to it, as in the directory location.
+func loadFallbackFile(certFile, keyFile string) (Identity, error) {
+	if isPFXFile(certFile) {
+		return loadPFXFile(certFile)
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certFile, err)
+	}
+
+	var key crypto.Signer
+	if keyFile != "" {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = parsePEMPrivateKey(keyData); err != nil {
+			return nil, fmt.Errorf("%s: %w", keyFile, err)
+		}
+	} else if key, err = findPEMKey(certFile, data); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%s: no private key found; set fallback_key", certFile)
+	}
+	return newFileIdentity(certFile, certs, key)
+}
+
+// newFileIdentity returns the identity of a certificate chain read from path
+// and its private key, which must match the first certificate.
+func newFileIdentity(path string, chain []*x509.Certificate, key crypto.Signer) (Identity, error) {
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(chain[0].PublicKey) {
+		return nil, fmt.Errorf("%s: private key does not match the first certificate", path)
+	}
+	return &directoryIdentity{chain: chain, signer: key}, nil
+}
+
+// fallbackFileStore holds the identity of a fallback file in place of the
+// store the selector searched.
+type fallbackFileStore struct {
+	identity Identity
+}
+
+func (s fallbackFileStore) Identities() ([]Identity, error) {
+	return []Identity{s.identity}, nil
+}
+
+func (fallbackFileStore) Close() {}
@@ -0,0 +1,95 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertSelector_FallbackFile(t *testing.T) {
+	ca := newTestCA(t, "Fallback CA")
+	dir := t.TempDir()
+
+	combinedKey := newTestKey(t)
+	combined := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "combined.example.test"}}, combinedKey.Public())
+	writePEMFile(t, filepath.Join(dir, "combined.pem"), combinedKey, combined)
+
+	separateKey := newTestKey(t)
+	separate := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "separate.example.test"}}, separateKey.Public())
+	writePEMFile(t, filepath.Join(dir, "separate.crt"), nil, separate)
+	writePEMFile(t, filepath.Join(dir, "client.key"), separateKey)
+
+	writePEMFile(t, filepath.Join(dir, "keyless.pem"), nil, separate)
+
+	tests := []struct {
+		name     string
+		file     string
+		key      string
+		wantCN   string
+		wantFail string
+	}{
+		{name: "key in the file", file: "combined.pem", wantCN: "combined.example.test"},
+		{name: "fallback_key", file: "separate.crt", key: "client.key", wantCN: "separate.example.test"},
+		{name: "missing key", file: "keyless.pem", wantFail: "set fallback_key"},
+		{name: "mismatched key", file: "combined.pem", key: "client.key", wantFail: "does not match"},
+		{name: "missing file", file: "missing.pem", wantFail: "loading fallback_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+			other := newTestKey(t)
+			withFakeStoreLoads(t, newFakeStoreLoad(newTestCertificate(t, "other.example.test", other), newFakeSigner(other.Public(), []byte("other"))))
+
+			selector := newTestSelector("^client\\.example\\.test$")
+			selector.FallbackFile = filepath.Join(dir, tt.file)
+			if tt.key != "" {
+				selector.FallbackKey = filepath.Join(dir, tt.key)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if tt.wantFail != "" {
+				assertErrorContains(t, err, tt.wantFail)
+				return
+			}
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.Subject.CommonName != tt.wantCN {
+				t.Fatalf("expected the fallback file's certificate %s, got %s", tt.wantCN, cert.Leaf.Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestCertSelector_FallbackFileNotUsedOnMatch(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	withFakeStoreLoads(t, newFakeStoreLoad(newTestCertificate(t, "client.example.test", key), newFakeSigner(key.Public(), []byte("ok"))))
+
+	selector := newTestSelector("^client\\.example\\.test$")
+	selector.FallbackFile = filepath.Join(t.TempDir(), "missing.pem")
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+	if cert.Leaf.Subject.CommonName != "client.example.test" {
+		t.Fatalf("expected the store's certificate, got %s", cert.Leaf.Subject.CommonName)
+	}
+}
+
+func TestCertSelector_ValidateFallbackFile(t *testing.T) {
+	tests := map[string]*CertSelector{
+		"key without file": {Pattern: "x", FallbackKey: "client.key"},
+		"key with pfx":     {Pattern: "x", FallbackFile: "client.pfx", FallbackKey: "client.key"},
+	}
+
+	for name, cs := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertErrorContains(t, cs.validate(), "fallback_key")
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package certstore
+
+import "reflect"
+
+// keyHardwareBacked reports whether the identity's private key is stored in
+// hardware and cannot be exported. It is false when that cannot be determined.
+var keyHardwareBacked = identityKeyHardwareBacked
+
+// identityHandle returns the unexported OS handle field of an identity from
+// the tailscale/certstore package, which does not expose the handles it wraps.
+// They are needed to query how the private key is stored.
+func identityHandle(identity Identity, field string) (reflect.Value, bool) {
+	v := reflect.ValueOf(identity)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	handle := v.Elem().FieldByName(field)
+	return handle, handle.IsValid()
+}
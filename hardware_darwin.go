@@ -0,0 +1,41 @@
+//go:build cgo
+
+package certstore
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+// identityKeyOnToken reports whether the identity's private key lives on a
+// token, such as the Secure Enclave or a smart card, rather than in a keychain.
+static int identityKeyOnToken(SecIdentityRef identity) {
+	SecKeyRef key = NULL;
+	if (SecIdentityCopyPrivateKey(identity, &key) != errSecSuccess) {
+		return 0;
+	}
+	CFDictionaryRef attrs = SecKeyCopyAttributes(key);
+	CFRelease(key);
+	if (attrs == NULL) {
+		return 0;
+	}
+	int onToken = CFDictionaryContainsKey(attrs, kSecAttrTokenID);
+	CFRelease(attrs);
+	return onToken;
+}
+*/
+import "C"
+
+import (
+	"reflect"
+)
+
+// identityKeyHardwareBacked reports whether the identity's private key is held
+// by a token, which the keychain never lets export.
+func identityKeyHardwareBacked(identity Identity) bool {
+	ref, ok := identityHandle(identity, "ref")
+	if !ok || ref.Kind() != reflect.Uintptr || ref.Uint() == 0 {
+		return false
+	}
+	return C.identityKeyOnToken(C.SecIdentityRef(ref.Uint())) != 0
+}
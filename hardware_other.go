@@ -0,0 +1,9 @@
+//go:build !windows && !(darwin && cgo)
+
+package certstore
+
+// identityKeyHardwareBacked reports false; there is no supported certificate
+// store on this platform.
+func identityKeyHardwareBacked(Identity) bool {
+	return false
+}
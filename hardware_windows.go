@@ -0,0 +1,68 @@
+package certstore
+
+import (
+	"reflect"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// ncryptImplHardwareFlag and ncryptImplRemovableFlag are the
+	// NCRYPT_IMPL_TYPE_PROPERTY bits of keys held by a TPM or smart card.
+	ncryptImplHardwareFlag  = 0x1
+	ncryptImplRemovableFlag = 0x8
+)
+
+var (
+	modncrypt             = windows.NewLazySystemDLL("ncrypt.dll")
+	procNCryptGetProperty = modncrypt.NewProc("NCryptGetProperty")
+	procNCryptFreeObject  = modncrypt.NewProc("NCryptFreeObject")
+)
+
+// identityKeyHardwareBacked queries the CNG key storage provider of the
+// identity's private key for its implementation type. Keys of legacy CryptoAPI
+// providers are treated as software keys.
+func identityKeyHardwareBacked(identity Identity) bool {
+	certCtx, ok := identityCertContext(identity)
+	if !ok {
+		return false
+	}
+
+	var (
+		key      windows.Handle
+		keySpec  uint32
+		mustFree bool
+	)
+	flags := uint32(windows.CRYPT_ACQUIRE_SILENT_FLAG | windows.CRYPT_ACQUIRE_ONLY_NCRYPT_KEY_FLAG)
+	if err := windows.CryptAcquireCertificatePrivateKey(certCtx, flags, nil, &key, &keySpec, &mustFree); err != nil {
+		return false
+	}
+	if mustFree {
+		defer procNCryptFreeObject.Call(uintptr(key))
+	}
+
+	var implType, size uint32
+	status, _, _ := procNCryptGetProperty.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr("Impl Type"))),
+		uintptr(unsafe.Pointer(&implType)),
+		unsafe.Sizeof(implType),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+	)
+	if status != 0 {
+		return false
+	}
+	return implType&(ncryptImplHardwareFlag|ncryptImplRemovableFlag) != 0
+}
+
+// identityCertContext returns the certificate context of the leaf of a
+// tailscale/certstore identity.
+func identityCertContext(identity Identity) (*windows.CertContext, bool) {
+	chain, ok := identityHandle(identity, "chain")
+	if !ok || chain.Kind() != reflect.Slice || chain.Len() == 0 || chain.Index(0).Kind() != reflect.Pointer {
+		return nil, false
+	}
+	return (*windows.CertContext)(unsafe.Pointer(chain.Index(0).Pointer())), true
+}
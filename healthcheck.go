@@ -0,0 +1,64 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// provisionHealthCheckTransport builds the transport carrying active health
+// checks when they do not use the proxy's client certificate.
+func (h *HTTPTransport) provisionHealthCheckTransport(ctx caddy.Context) error {
+	if !h.HealthCheckNoClientCert && h.HealthCheckClientCertRef == "" {
+		return nil
+	}
+	if h.HealthCheckNoClientCert && h.HealthCheckClientCertRef != "" {
+		return fmt.Errorf("health_check_no_client_certificate and health_check_client_certificate_ref are mutually exclusive")
+	}
+
+	var healthSelector *CertSelector
+	if h.HealthCheckClientCertRef != "" {
+		var err error
+		if healthSelector, err = resolveSelector(ctx, nil, h.HealthCheckClientCertRef); err != nil {
+			return fmt.Errorf("health_check_client_certificate_ref: %w", err)
+		}
+	}
+
+	transport, err := h.HTTPTransport.NewTransport(ctx)
+	if err != nil {
+		return fmt.Errorf("building health check transport: %w", err)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = new(tls.Config)
+	}
+	transport.TLSClientConfig.GetClientCertificate = nil
+	h.applyTrust(transport.TLSClientConfig)
+	if healthSelector != nil {
+		transport.TLSClientConfig.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return selectorClientCertificate(healthSelector, cri)
+		}
+	}
+	h.healthCheckTransport = transport
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper. Active health checks are sent
+// through the health check transport when one is configured.
+func (h *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if h.healthCheckTransport != nil && isActiveHealthCheck(req) {
+		h.SetScheme(req)
+		return h.healthCheckTransport.RoundTrip(req)
+	}
+	return h.HTTPTransport.RoundTrip(req)
+}
+
+// isActiveHealthCheck reports whether req is a reverse_proxy active health
+// check. Proxied requests derive from a request served by a Caddy HTTP server
+// and carry it in their context; health checks are made by the proxy itself
+// and do not.
+func isActiveHealthCheck(req *http.Request) bool {
+	return req.Context().Value(caddyhttp.ServerCtxKey) == nil
+}
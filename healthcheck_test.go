@@ -0,0 +1,85 @@
+package certstore
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+func TestHTTPTransport_HealthCheckNoClientCert(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "health.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, key))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			_, _ = io.WriteString(w, "anonymous")
+			return
+		}
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	h := &HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{
+			TLS: &reverseproxy.TLSConfig{InsecureSkipVerify: true},
+		},
+		ClientCert:              &CertSelector{Pattern: "^health\\.example\\.test$", Location: "user"},
+		HealthCheckNoClientCert: true,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer h.Cleanup()
+
+	proxied := context.WithValue(context.Background(), caddyhttp.ServerCtxKey, new(caddyhttp.Server))
+	tests := map[string]struct {
+		ctx      context.Context
+		expected string
+	}{
+		"proxied request":     {ctx: proxied, expected: "health.example.test"},
+		"active health check": {ctx: context.Background(), expected: "anonymous"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(tt.ctx, http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("build request: %v", err)
+			}
+			resp, err := h.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("round trip failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, body)
+			}
+		})
+	}
+}
+
+func TestHTTPTransport_HealthCheckOptionsExclusive(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &HTTPTransport{
+		HTTPTransport:            &reverseproxy.HTTPTransport{},
+		HealthCheckNoClientCert:  true,
+		HealthCheckClientCertRef: "health",
+	}
+	assertErrorContains(t, h.Provision(ctx), "mutually exclusive")
+}
@@ -0,0 +1,40 @@
+package certstore
+
+import (
+	"fmt"
+	"os/user"
+)
+
+// Impersonation is the account a load is retried as when the OS denies the
+// account running Caddy access to the store or private key, as happens to
+// Windows services whose account was not granted access to LocalMachine
+// keys.
+type Impersonation struct {
+	// User is the account, as DOMAIN\user, .\user for a local account or
+	// user@domain. Required.
+	User string `json:"user"`
+
+	// Password is the account's password. Use a placeholder such as
+	// {env.CADDY_KEY_ACCOUNT_PASSWORD} to keep it out of the configuration.
+	Password string `json:"password,omitempty"`
+}
+
+// impersonateAccount runs fn on a thread impersonating the account. It is a
+// variable so that tests can replace it.
+var impersonateAccount = impersonateLogon
+
+// validateImpersonation checks the impersonate setting.
+func (cs *CertSelector) validateImpersonation() error {
+	if cs.Impersonate != nil && cs.Impersonate.User == "" {
+		return fmt.Errorf("impersonate requires a user")
+	}
+	return nil
+}
+
+// currentAccount names the account running Caddy for error messages.
+func currentAccount() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return "an unknown account"
+}
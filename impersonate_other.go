@@ -0,0 +1,9 @@
+//go:build !windows
+
+package certstore
+
+import "errors"
+
+func impersonateLogon(string, string, func() error) error {
+	return errors.New("impersonate is only supported on Windows")
+}
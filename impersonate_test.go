@@ -0,0 +1,73 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// withFakeImpersonation replaces impersonateAccount, recording the accounts
+// loads are retried as.
+func withFakeImpersonation(t *testing.T) *[]string {
+	t.Helper()
+
+	var users []string
+	old := impersonateAccount
+	impersonateAccount = func(user, password string, fn func() error) error {
+		users = append(users, user+":"+password)
+		return fn()
+	}
+	t.Cleanup(func() { impersonateAccount = old })
+	return &users
+}
+
+func deniedStoreLoad() *fakeStoreLoad {
+	return &fakeStoreLoad{openErr: fmt.Errorf("failed to open system cert store: %w", syscall.Errno(0x5))}
+}
+
+func TestCertSelector_ImpersonateRetry(t *testing.T) {
+	resetCertificateCache(t)
+	users := withFakeImpersonation(t)
+
+	key := newTestKey(t)
+	provider := withFakeStoreLoads(t, deniedStoreLoad(), newFakeStoreLoad(newTestCertificate(t, "svc.example.test", key), newFakeSigner(key.Public(), []byte("ok"))))
+
+	selector := newTestSelector("^svc\\.example\\.test$")
+	selector.Location = "system"
+	selector.Impersonate = &Impersonation{User: `CORP\svc-caddy`, Password: "secret"}
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if len(*users) != 1 || (*users)[0] != `CORP\svc-caddy:secret` {
+		t.Fatalf("expected one retry as the configured account, got %v", *users)
+	}
+	if provider.openCount() != 2 {
+		t.Fatalf("expected the store to be opened again, got %d opens", provider.openCount())
+	}
+}
+
+func TestCertSelector_AccessDeniedWithoutImpersonate(t *testing.T) {
+	resetCertificateCache(t)
+	users := withFakeImpersonation(t)
+	withFakeStoreLoads(t, deniedStoreLoad())
+
+	selector := newTestSelector("^svc\\.example\\.test$")
+	selector.Location = "system"
+	_, err := selector.loadCertificate(t.Context())
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied, got %v", err)
+	}
+	assertErrorContains(t, err, "set impersonate to retry as an account with access")
+	if len(*users) != 0 {
+		t.Fatalf("expected no retry, got %v", *users)
+	}
+}
+
+func TestCertSelector_ImpersonateValidation(t *testing.T) {
+	selector := newTestSelector("^svc\\.example\\.test$")
+	selector.Impersonate = &Impersonation{Password: "secret"}
+	assertErrorContains(t, selector.validate(), "impersonate requires a user")
+}
@@ -0,0 +1,80 @@
+package certstore
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// LogonUserW logon type and provider: a service logon, as the account would
+// get running Caddy itself, with the default provider.
+const (
+	logon32LogonService    = 5
+	logon32ProviderDefault = 0
+)
+
+var (
+	modadvapi32                 = windows.NewLazySystemDLL("advapi32.dll")
+	procLogonUserW              = modadvapi32.NewProc("LogonUserW")
+	procImpersonateLoggedOnUser = modadvapi32.NewProc("ImpersonateLoggedOnUser")
+)
+
+// impersonateLogon logs the account on and runs fn on a thread impersonating
+// it. Store and key handles opened by fn stay usable once the thread
+// reverts, as access is checked when they are opened. The account needs the
+// "Log on as a service" right.
+func impersonateLogon(user, password string, fn func() error) error {
+	token, err := logonUser(user, password)
+	if err != nil {
+		return fmt.Errorf("logging on as %s: %w", user, err)
+	}
+	defer token.Close()
+
+	runtime.LockOSThread()
+	if ok, _, err := procImpersonateLoggedOnUser.Call(uintptr(token)); ok == 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("impersonating %s: %w", user, err)
+	}
+	defer func() {
+		// A thread that cannot revert keeps the account's identity, so it
+		// stays locked and exits with the goroutine instead of running
+		// others.
+		if windows.RevertToSelf() == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+	return fn()
+}
+
+// logonUser logs the account on. LogonUserW takes the domain of DOMAIN\user
+// accounts separately, and none for user@domain ones.
+func logonUser(user, password string) (windows.Token, error) {
+	var domain *uint16
+	if d, name, ok := strings.Cut(user, `\`); ok {
+		var err error
+		if domain, err = windows.UTF16PtrFromString(d); err != nil {
+			return 0, err
+		}
+		user = name
+	}
+	userPtr, err := windows.UTF16PtrFromString(user)
+	if err != nil {
+		return 0, err
+	}
+	passwordPtr, err := windows.UTF16PtrFromString(password)
+	if err != nil {
+		return 0, err
+	}
+
+	var token windows.Token
+	if ok, _, err := procLogonUserW.Call(
+		uintptr(unsafe.Pointer(userPtr)), uintptr(unsafe.Pointer(domain)), uintptr(unsafe.Pointer(passwordPtr)),
+		logon32LogonService, logon32ProviderDefault, uintptr(unsafe.Pointer(&token)),
+	); ok == 0 {
+		return 0, err
+	}
+	return token, nil
+}
@@ -0,0 +1,128 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// maxImportSize bounds the admin API import payload.
+const maxImportSize = 1 << 20
+
+// importRequest is the payload of the admin API import endpoint. It carries
+// either a PKCS#12 (PFX) file or PEM certificates and their private key.
+type importRequest struct {
+	// Location is the store to import into, "user", "system" or "nssdb".
+	Location string `json:"location"`
+
+	// PFX is the base64 PKCS#12 file, protected by Password.
+	PFX      []byte `json:"pfx,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// PEM holds the leaf certificate, its intermediates and the private
+	// key.
+	PEM string `json:"pem,omitempty"`
+}
+
+// importResponse describes the imported identity.
+type importResponse struct {
+	Location     string `json:"location"`
+	Subject      string `json:"subject"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// importLocation validates the requested store location.
+func importLocation(location string) (StoreLocation, error) {
+	switch strings.ToLower(location) {
+	case "user":
+		return LocationUser, nil
+	case "system", "machine":
+		return LocationSystem, nil
+	case "nssdb":
+		return LocationNSS, nil
+	default:
+		return "", fmt.Errorf("unsupported import location '%s': must be 'user', 'system' or 'nssdb'", location)
+	}
+}
+
+// pfx returns the PKCS#12 file to import and the leaf certificate it holds,
+// encoding PEM payloads as PKCS#12 first.
+func (r importRequest) pfx() ([]byte, *x509.Certificate, error) {
+	switch {
+	case len(r.PFX) > 0 && r.PEM != "":
+		return nil, nil, fmt.Errorf("pfx and pem are mutually exclusive")
+	case len(r.PFX) > 0:
+		_, leaf, _, err := pkcs12.DecodeChain(r.PFX, r.Password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding pfx: %w", err)
+		}
+		return r.PFX, leaf, nil
+	case r.PEM != "":
+		return encodePEMIdentity([]byte(r.PEM), r.Password)
+	default:
+		return nil, nil, fmt.Errorf("import must set 'pfx' or 'pem'")
+	}
+}
+
+// encodePEMIdentity encodes PEM certificates and their private key as a
+// PKCS#12 file protected by password.
+func encodePEMIdentity(data []byte, password string) ([]byte, *x509.Certificate, error) {
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing pem certificates: %w", err)
+	}
+	key, err := parsePEMPrivateKey(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf := certs[0]
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(leaf.PublicKey) {
+		return nil, nil, fmt.Errorf("private key does not match the first certificate")
+	}
+
+	pfx, err := pkcs12.Encode(rand.Reader, key, leaf, certs[1:], password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding pfx: %w", err)
+	}
+	return pfx, leaf, nil
+}
+
+// parsePEMPrivateKey parses the first private key block of data.
+func parsePEMPrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM private key found")
+		}
+		var key any
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", strings.ToLower(block.Type), err)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			return key.(crypto.Signer), nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Policies for private key access refused because the keychain would need
+// user interaction.
+const (
+	interactionFail     = "fail"
+	interactionRetry    = "retry"
+	interactionFallback = "fallback"
+)
+
+const (
+	// defaultInteractionRetryTimeout bounds the retry policy when
+	// interaction_retry_timeout is not set.
+	defaultInteractionRetryTimeout = 30 * time.Second
+
+	// interactionFallbackPeriod is how long the fallback selector is used
+	// before the primary certificate is tried again.
+	interactionFallbackPeriod = time.Minute
+)
+
+// interactionRetryInterval is the wait between signing attempts of the retry
+// policy.
+var interactionRetryInterval = 2 * time.Second
+
+// validateInteractionPolicy checks the interaction-denied settings.
+func (cs *CertSelector) validateInteractionPolicy() error {
+	switch cs.OnInteractionDenied {
+	case "", interactionFail, interactionRetry:
+	case interactionFallback:
+		if cs.Fallback == nil {
+			return fmt.Errorf("on_interaction_denied '%s' requires a fallback selector", interactionFallback)
+		}
+	default:
+		return fmt.Errorf("unsupported on_interaction_denied value '%s': must be '%s', '%s' or '%s'",
+			cs.OnInteractionDenied, interactionFail, interactionRetry, interactionFallback)
+	}
+	if cs.InteractionRetryTimeout < 0 {
+		return fmt.Errorf("interaction_retry_timeout must not be negative")
+	}
+	return nil
+}
+
+// interactionPolicy returns the configured policy, defaulting to fail.
+func (cs *CertSelector) interactionPolicy() string {
+	if cs.OnInteractionDenied == "" {
+		return interactionFail
+	}
+	return cs.OnInteractionDenied
+}
+
+// handleInteractionDenied applies the interaction policy after sign failed
+// with ErrInteractionNotAllowed. With the retry policy it keeps calling sign
+// until it stops failing that way or the retry timeout elapses, giving a
+// script or user the chance to unlock the keychain.
+func (cached *cachedCert) handleInteractionDenied(sign func() ([]byte, error), err error) ([]byte, error) {
+	cached.recordInteractionDenied(err)
+
+	if cached.selector.interactionPolicy != interactionRetry {
+		return nil, err
+	}
+
+	timeout := cached.selector.interactionRetryTimeout
+	deadline := time.Now().Add(timeout)
+	for time.Now().Add(interactionRetryInterval).Before(deadline) {
+		time.Sleep(interactionRetryInterval)
+
+		sig, retryErr := sign()
+		if !errors.Is(retryErr, ErrInteractionNotAllowed) {
+			if retryErr == nil {
+				cached.clearInteractionDenied()
+			}
+			return sig, retryErr
+		}
+	}
+	return nil, fmt.Errorf("private key access still not allowed after retrying for %s: %w", timeout, err)
+}
+
+// recordInteractionDenied logs and counts a refused private key access and
+// remembers when it happened for the fallback policy.
+func (cached *cachedCert) recordInteractionDenied(err error) {
+	cached.mu.Lock()
+	cached.interactionDeniedAt = time.Now()
+	serial := certificateSerial(cached.cert)
+	cached.mu.Unlock()
+
+	certstoreMetrics.interactionDenied.
+		WithLabelValues(cached.selector.location, cached.selector.interactionPolicy).
+		Inc()
+
+	if cached.selector.logger != nil {
+		cached.selector.logger.Warn("private key access requires user interaction",
+			zap.String("serial_number", serial),
+			zap.String("policy", cached.selector.interactionPolicy),
+			zap.Error(err),
+		)
+	}
+}
+
+func (cached *cachedCert) clearInteractionDenied() {
+	cached.mu.Lock()
+	cached.interactionDeniedAt = time.Time{}
+	cached.mu.Unlock()
+}
+
+// interactionDeniedRecently reports whether private key access was refused
+// within the fallback period.
+func (cached *cachedCert) interactionDeniedRecently() bool {
+	cached.mu.RLock()
+	deniedAt := cached.interactionDeniedAt
+	cached.mu.RUnlock()
+	return !deniedAt.IsZero() && time.Since(deniedAt) < interactionFallbackPeriod
+}
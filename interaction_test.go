@@ -0,0 +1,101 @@
+package certstore
+
+import (
+	"crypto"
+	crand "crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const errSecInteractionNotAllowed = osStatus(-25308)
+
+func TestInteractionDenied_Policies(t *testing.T) {
+	oldInterval := interactionRetryInterval
+	interactionRetryInterval = time.Millisecond
+	t.Cleanup(func() { interactionRetryInterval = oldInterval })
+
+	tests := []struct {
+		name      string
+		policy    string
+		errs      []error
+		expectErr bool
+		expectSig string
+	}{
+		{name: "fail", policy: interactionFail, errs: []error{errSecInteractionNotAllowed}, expectErr: true},
+		{name: "retry until unlocked", policy: interactionRetry, errs: []error{errSecInteractionNotAllowed, errSecInteractionNotAllowed}, expectSig: "unlocked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			key := newTestKey(t)
+			cert := newTestCertificate(t, "interaction.example.test", key)
+			withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSignerWithErrors(key.Public(), []byte("unlocked"), tt.errs...)))
+
+			selector := newTestSelector("^interaction\\.example\\.test$")
+			selector.OnInteractionDenied = tt.policy
+			tlsCert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+
+			denied := certstoreMetrics.interactionDenied.WithLabelValues("user", tt.policy)
+			before := testutil.ToFloat64(denied)
+
+			sig, err := tlsCert.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256)
+			if tt.expectErr {
+				if !errors.Is(err, ErrInteractionNotAllowed) {
+					t.Fatalf("expected interaction error, got %v", err)
+				}
+			} else if err != nil || string(sig) != tt.expectSig {
+				t.Fatalf("expected signature %q, got %q (%v)", tt.expectSig, sig, err)
+			}
+
+			if got := testutil.ToFloat64(denied) - before; got != 1 {
+				t.Fatalf("expected one interaction denied event, got %v", got)
+			}
+		})
+	}
+}
+
+func TestInteractionDenied_Fallback(t *testing.T) {
+	resetCertificateCache(t)
+
+	primaryKey := newTestKey(t)
+	fallbackKey := newTestKey(t)
+	primaryCert := newTestCertificate(t, "primary.example.test", primaryKey)
+	fallbackCert := newTestCertificate(t, "fallback.example.test", fallbackKey)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(fallbackCert, newFakeSigner(fallbackKey.Public(), []byte("fallback"))),
+		newFakeStoreLoad(primaryCert, newFakeSignerWithErrors(primaryKey.Public(), []byte("primary"), errSecInteractionNotAllowed)),
+	)
+
+	selector := newTestSelector("^primary\\.example\\.test$")
+	selector.OnInteractionDenied = interactionFallback
+	selector.Fallback = newTestSelector("^fallback\\.example\\.test$")
+	if _, err := selector.Fallback.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("fallback load failed: %v", err)
+	}
+	tlsCert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if _, err := tlsCert.PrivateKey.(crypto.Signer).Sign(crand.Reader, []byte("digest"), crypto.SHA256); !errors.Is(err, ErrInteractionNotAllowed) {
+		t.Fatalf("expected current handshake to fail with interaction error, got %v", err)
+	}
+
+	current, err := selector.currentCertificate()
+	if err != nil {
+		t.Fatalf("current certificate failed: %v", err)
+	}
+	if current.Leaf.Subject.CommonName != "fallback.example.test" {
+		t.Fatalf("expected fallback certificate after denied interaction, got %q", current.Leaf.Subject.CommonName)
+	}
+}
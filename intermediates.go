@@ -0,0 +1,157 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// ExtraIntermediates adds certificates to the chain presented with the
+// selected certificate. It covers cross-signing, where the chain stored with
+// the identity is the wrong path for some relying parties.
+type ExtraIntermediates struct {
+	// Files are PEM files whose certificates are appended to the chain, in
+	// order, after the chain stored with the identity.
+	Files []string `json:"files,omitempty"`
+
+	// FromStore appends the CA certificates of the OS intermediate store
+	// that issue a certificate already in the chain, such as cross-signed
+	// versions of its intermediates. Self-signed roots are never added.
+	FromStore bool `json:"from_store,omitempty"`
+}
+
+// loadIntermediateFiles reads the certificates of the PEM files.
+func loadIntermediateFiles(files []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading intermediates file: %w", err)
+		}
+		fileCerts, err := parsePEMCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing intermediates file %s: %w", file, err)
+		}
+		certs = append(certs, fileCerts...)
+	}
+	return certs, nil
+}
+
+// parsePEMCertificates parses every CERTIFICATE block of data.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// appendIntermediates appends the extra intermediates configured for the
+// selector to the chain of cert, skipping certificates already present.
+func (s selectorSnapshot) appendIntermediates(cert *tls.Certificate, store Store) error {
+	if len(s.intermediateFiles) == 0 && !s.intermediatesFromStore {
+		return nil
+	}
+
+	chain, err := parseCertificateChain(cert.Certificate)
+	if err != nil {
+		return err
+	}
+
+	chain = appendMissing(chain, s.intermediateFiles...)
+	if s.intermediatesFromStore {
+		lister, ok := store.(IntermediateStore)
+		if !ok {
+			return fmt.Errorf("certificate store cannot list intermediates")
+		}
+		pool, err := lister.Intermediates()
+		if err != nil {
+			return fmt.Errorf("listing intermediates: %w", err)
+		}
+		chain = appendIssuers(chain, pool)
+	}
+
+	cert.Certificate = serializeCertificateChain(chain)
+	return nil
+}
+
+// parseCertificateChain parses the DER certificates of a tls.Certificate.
+func parseCertificateChain(ders [][]byte) ([]*x509.Certificate, error) {
+	chain := make([]*x509.Certificate, 0, len(ders))
+	for _, der := range ders {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate chain: %w", err)
+		}
+		chain = append(chain, parsed)
+	}
+	return chain, nil
+}
+
+// appendIssuers appends the certificates of pool that issue a certificate of
+// the chain, repeating until no more are found so cross-signs of added
+// certificates are included too.
+func appendIssuers(chain, pool []*x509.Certificate) []*x509.Certificate {
+	for added := true; added; {
+		added = false
+		for _, candidate := range pool {
+			if isSelfSigned(candidate) || containsCertificate(chain, candidate) || !issuesAny(candidate, chain) {
+				continue
+			}
+			chain = append(chain, candidate)
+			added = true
+		}
+	}
+	return chain
+}
+
+// appendMissing appends the certificates not already in chain.
+func appendMissing(chain []*x509.Certificate, certs ...*x509.Certificate) []*x509.Certificate {
+	for _, cert := range certs {
+		if !containsCertificate(chain, cert) {
+			chain = append(chain, cert)
+		}
+	}
+	return chain
+}
+
+func containsCertificate(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuesAny reports whether issuer signed one of the certificates.
+func issuesAny(issuer *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, issuer.RawSubject) && cert.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer)
+}
@@ -0,0 +1,58 @@
+//go:build cgo
+
+package certstore
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+// copyKeychainCertificateData returns the DER encoding of every certificate
+// in the keychain search list.
+static CFArrayRef copyKeychainCertificateData(OSStatus *status) {
+	const void *keys[] = { kSecClass, kSecMatchLimit, kSecReturnData };
+	const void *values[] = { kSecClassCertificate, kSecMatchLimitAll, kCFBooleanTrue };
+	CFDictionaryRef query = CFDictionaryCreate(NULL, keys, values, 3,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFTypeRef result = NULL;
+	*status = SecItemCopyMatching(query, &result);
+	CFRelease(query);
+	return (CFArrayRef)result;
+}
+
+static CFDataRef certificateDataAtIndex(CFArrayRef items, CFIndex i) {
+	return (CFDataRef)CFArrayGetValueAtIndex(items, i);
+}
+*/
+import "C"
+
+import (
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+)
+
+// osIntermediates lists the CA certificates in the keychain search list,
+// which spans the user and System keychains regardless of location.
+func osIntermediates(StoreLocation) ([]*x509.Certificate, error) {
+	var status C.OSStatus
+	items := C.copyKeychainCertificateData(&status)
+	if status == C.errSecItemNotFound {
+		return nil, nil
+	}
+	if status != C.errSecSuccess {
+		return nil, fmt.Errorf("listing keychain certificates: OSStatus %d", int(status))
+	}
+	defer C.CFRelease(C.CFTypeRef(items))
+
+	var intermediates []*x509.Certificate
+	n := C.CFArrayGetCount(items)
+	for i := C.CFIndex(0); i < n; i++ {
+		data := C.certificateDataAtIndex(items, i)
+		der := C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+		if cert, err := x509.ParseCertificate(der); err == nil && cert.IsCA {
+			intermediates = append(intermediates, cert)
+		}
+	}
+	return intermediates, nil
+}
@@ -0,0 +1,86 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertSelector_ExtraIntermediates(t *testing.T) {
+	resetCertificateCache(t)
+
+	rootA := newTestCA(t, "Root A")
+	rootB := newTestCA(t, "Root B")
+	unrelated := newTestCA(t, "Unrelated CA")
+
+	intermediateKey := newTestKey(t)
+	intermediate := &testCA{
+		cert: rootA.issue(t, &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "Intermediate"},
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, intermediateKey.Public()),
+		key: intermediateKey,
+	}
+	// Root A cross-signed by Root B, the path some relying parties need.
+	crossSigned := rootB.issue(t, &x509.Certificate{
+		Subject:               rootA.cert.Subject,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, rootA.key.Public())
+
+	leafKey := newTestKey(t)
+	leaf := intermediate.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "chain.example.test"}}, leafKey.Public())
+	load := newFakeStoreLoadWithChain([]*x509.Certificate{leaf, intermediate.cert}, newFakeSigner(leafKey.Public(), []byte("ok")))
+	load.store.intermediates = []*x509.Certificate{rootA.cert, unrelated.cert, crossSigned}
+	withFakeStoreLoads(t, load)
+
+	file := filepath.Join(t.TempDir(), "extra.pem")
+	extraPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: unrelated.cert.Raw})
+	if err := os.WriteFile(file, extraPEM, 0o600); err != nil {
+		t.Fatalf("write intermediates file: %v", err)
+	}
+	intermediates, err := loadIntermediateFiles([]string{file})
+	if err != nil {
+		t.Fatalf("loadIntermediateFiles failed: %v", err)
+	}
+
+	selector := newTestSelector("^chain\\.example\\.test$")
+	selector.ExtraIntermediates = &ExtraIntermediates{Files: []string{file}, FromStore: true}
+	selector.SendRoot = true // keep the self-signed CA of the file
+	selector.intermediates = intermediates
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	expected := []*x509.Certificate{leaf, intermediate.cert, unrelated.cert, crossSigned}
+	if len(cert.Certificate) != len(expected) {
+		t.Fatalf("expected chain of %d certificates, got %d", len(expected), len(cert.Certificate))
+	}
+	for i, want := range expected {
+		if string(cert.Certificate[i]) != string(want.Raw) {
+			t.Fatalf("unexpected certificate at chain position %d", i)
+		}
+	}
+}
+
+func TestLoadIntermediateFiles_Errors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := loadIntermediateFiles([]string{filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Fatal("expected error for a missing file")
+	}
+	_, err := loadIntermediateFiles([]string{empty})
+	assertErrorContains(t, err, "no PEM certificates found")
+}
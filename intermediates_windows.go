@@ -0,0 +1,47 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// osIntermediates lists the CA certificates in the Intermediate Certification
+// Authorities store of location.
+func osIntermediates(location StoreLocation) ([]*x509.Certificate, error) {
+	flags := uint32(windows.CERT_SYSTEM_STORE_LOCAL_MACHINE)
+	if location == LocationUser {
+		flags = windows.CERT_SYSTEM_STORE_CURRENT_USER
+	}
+	flags |= windows.CERT_STORE_READONLY_FLAG | windows.CERT_STORE_OPEN_EXISTING_FLAG
+
+	name, err := windows.UTF16PtrFromString("CA")
+	if err != nil {
+		return nil, err
+	}
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM_W, 0, 0, flags, uintptr(unsafe.Pointer(name)))
+	if err != nil {
+		return nil, fmt.Errorf("opening intermediate certificate store: %w", err)
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	var intermediates []*x509.Certificate
+	var certCtx *windows.CertContext
+	for {
+		certCtx, err = windows.CertEnumCertificatesInStore(store, certCtx)
+		if err != nil {
+			// CRYPT_E_NOT_FOUND marks the end of the store.
+			break
+		}
+		// The encoding belongs to the context, which the next enumeration
+		// frees, and the parsed certificate would keep pointing into it.
+		der := bytes.Clone(unsafe.Slice(certCtx.EncodedCert, certCtx.Length))
+		if cert, err := x509.ParseCertificate(der); err == nil && cert.IsCA {
+			intermediates = append(intermediates, cert)
+		}
+	}
+	return intermediates, nil
+}
@@ -0,0 +1,44 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// Key types a selector can require of the certificate's key.
+const (
+	keyTypeRSA     = "rsa"
+	keyTypeECDSA   = "ecdsa"
+	keyTypeEd25519 = "ed25519"
+)
+
+// validateKeyPolicy checks the key_type and min_key_bits settings.
+func (cs *CertSelector) validateKeyPolicy() error {
+	switch cs.KeyType {
+	case "", keyTypeRSA, keyTypeECDSA, keyTypeEd25519:
+	default:
+		return fmt.Errorf("unsupported key_type '%s': must be '%s', '%s' or '%s'", cs.KeyType, keyTypeRSA, keyTypeECDSA, keyTypeEd25519)
+	}
+	if cs.MinKeyBits < 0 {
+		return fmt.Errorf("min_key_bits must not be negative")
+	}
+	return nil
+}
+
+// keyAcceptable reports whether the certificate's key is of keyType, when
+// set, and, if it is an RSA key, has a modulus of at least minRSABits.
+func keyAcceptable(cert *x509.Certificate, keyType string, minRSABits int) bool {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return (keyType == "" || keyType == keyTypeRSA) && pub.N.BitLen() >= minRSABits
+	case *ecdsa.PublicKey:
+		return keyType == "" || keyType == keyTypeECDSA
+	case ed25519.PublicKey:
+		return keyType == "" || keyType == keyTypeEd25519
+	default:
+		return keyType == ""
+	}
+}
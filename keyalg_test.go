@@ -0,0 +1,74 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestCertSelector_KeyPolicy(t *testing.T) {
+	ca := newTestCA(t, "Key CA")
+	issue := func(pub crypto.PublicKey) *x509.Certificate {
+		return ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "key.example.test"}}, pub)
+	}
+	rsaKey := func(bits int) *rsa.PrivateKey {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatalf("generate RSA key: %v", err)
+		}
+		return key
+	}
+
+	// Issued in this order, the legacy RSA certificate has the highest
+	// serial number and would be chosen without a key policy.
+	ecKey, modernRSA, legacyRSA := newTestKey(t), rsaKey(2048), rsaKey(1024)
+	ecCert, modernCert, legacyCert := issue(ecKey.Public()), issue(modernRSA.Public()), issue(legacyRSA.Public())
+
+	tests := []struct {
+		name       string
+		keyType    string
+		minKeyBits int
+		want       *x509.Certificate
+	}{
+		{name: "no policy", want: legacyCert},
+		{name: "ecdsa", keyType: keyTypeECDSA, want: ecCert},
+		{name: "min key bits", minKeyBits: 2048, want: modernCert},
+		{name: "rsa with min key bits", keyType: keyTypeRSA, minKeyBits: 2048, want: modernCert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(
+				newFakeIdentity(ecCert, newFakeSigner(ecKey.Public(), []byte("ok"))),
+				newFakeIdentity(modernCert, newFakeSigner(modernRSA.Public(), []byte("ok"))),
+				newFakeIdentity(legacyCert, newFakeSigner(legacyRSA.Public(), []byte("ok"))),
+			))
+
+			selector := newTestSelector("^key\\.example\\.test$")
+			selector.KeyType, selector.MinKeyBits = tt.keyType, tt.minKeyBits
+			if err := selector.validate(); err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.SerialNumber.Cmp(tt.want.SerialNumber) != 0 {
+				t.Fatalf("expected serial %s, got %s", tt.want.SerialNumber, cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
+
+func TestCertSelector_KeyPolicyValidation(t *testing.T) {
+	selector := newTestSelector("^key\\.example\\.test$")
+	selector.KeyType = "dsa"
+	assertErrorContains(t, selector.validate(), "unsupported key_type 'dsa'")
+
+	selector.KeyType, selector.MinKeyBits = "", -1
+	assertErrorContains(t, selector.validate(), "min_key_bits must not be negative")
+}
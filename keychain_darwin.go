@@ -0,0 +1,301 @@
+//go:build cgo
+
+package certstore
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+// copyKeychainIdentities returns the identities of the keychain file at path,
+// searching it alone instead of the keychain search list. unreadable is set
+// when the process may not read the keychain.
+static CFArrayRef copyKeychainIdentities(const char *path, OSStatus *status, int *unreadable) {
+	SecKeychainRef keychain = NULL;
+	*status = SecKeychainOpen(path, &keychain);
+	if (*status != errSecSuccess) {
+		return NULL;
+	}
+	// SecKeychainOpen succeeds for missing files; the status tells.
+	SecKeychainStatus keychainStatus;
+	*status = SecKeychainGetStatus(keychain, &keychainStatus);
+	if (*status != errSecSuccess) {
+		CFRelease(keychain);
+		return NULL;
+	}
+	if ((keychainStatus & kSecReadPermStatus) == 0) {
+		CFRelease(keychain);
+		*unreadable = 1;
+		return NULL;
+	}
+
+	CFArrayRef searchList = CFArrayCreate(NULL, (const void **)&keychain, 1, &kCFTypeArrayCallBacks);
+	CFRelease(keychain);
+	const void *keys[] = { kSecClass, kSecMatchLimit, kSecReturnRef, kSecMatchSearchList };
+	const void *values[] = { kSecClassIdentity, kSecMatchLimitAll, kCFBooleanTrue, searchList };
+	CFDictionaryRef query = CFDictionaryCreate(NULL, keys, values, 4,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFRelease(searchList);
+
+	CFTypeRef result = NULL;
+	*status = SecItemCopyMatching(query, &result);
+	CFRelease(query);
+	return (CFArrayRef)result;
+}
+
+static SecIdentityRef retainIdentityAtIndex(CFArrayRef items, CFIndex i) {
+	SecIdentityRef identity = (SecIdentityRef)CFArrayGetValueAtIndex(items, i);
+	CFRetain(identity);
+	return identity;
+}
+
+// copyIdentityChainData returns the DER encoding of the identity's
+// certificate followed by the issuers the keychain finds for it.
+static CFArrayRef copyIdentityChainData(SecIdentityRef identity) {
+	SecCertificateRef cert = NULL;
+	if (SecIdentityCopyCertificate(identity, &cert) != errSecSuccess) {
+		return NULL;
+	}
+	CFMutableArrayRef chain = CFArrayCreateMutable(NULL, 0, &kCFTypeArrayCallBacks);
+	SecPolicyRef policy = SecPolicyCreateBasicX509();
+	SecTrustRef trust = NULL;
+	if (SecTrustCreateWithCertificates(cert, policy, &trust) == errSecSuccess) {
+		// The evaluation builds the chain; whether it is trusted does not matter.
+		SecTrustEvaluateWithError(trust, NULL);
+		CFIndex n = SecTrustGetCertificateCount(trust);
+		for (CFIndex i = 0; i < n; i++) {
+			CFDataRef data = SecCertificateCopyData(SecTrustGetCertificateAtIndex(trust, i));
+			CFArrayAppendValue(chain, data);
+			CFRelease(data);
+		}
+		CFRelease(trust);
+	}
+	if (CFArrayGetCount(chain) == 0) {
+		CFDataRef data = SecCertificateCopyData(cert);
+		CFArrayAppendValue(chain, data);
+		CFRelease(data);
+	}
+	CFRelease(policy);
+	CFRelease(cert);
+	return chain;
+}
+
+static CFDataRef dataAtIndex(CFArrayRef items, CFIndex i) {
+	return (CFDataRef)CFArrayGetValueAtIndex(items, i);
+}
+
+// copyIdentitySignature signs digest with the identity's private key,
+// returning NULL and the error code in code on failure.
+static CFDataRef copyIdentitySignature(SecIdentityRef identity, SecKeyAlgorithm algorithm, const UInt8 *digest, CFIndex length, CFIndex *code) {
+	SecKeyRef key = NULL;
+	OSStatus status = SecIdentityCopyPrivateKey(identity, &key);
+	if (status != errSecSuccess) {
+		*code = status;
+		return NULL;
+	}
+	CFDataRef data = CFDataCreate(NULL, digest, length);
+	CFErrorRef error = NULL;
+	CFDataRef signature = SecKeyCreateSignature(key, algorithm, data, &error);
+	CFRelease(data);
+	CFRelease(key);
+	if (signature == NULL) {
+		*code = error != NULL ? CFErrorGetCode(error) : errSecParam;
+		if (error != NULL) {
+			CFRelease(error);
+		}
+	}
+	return signature;
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// openNamedOSStore opens the keychain file at path, such as a dedicated
+// caddy.keychain-db, on its own instead of the keychain search list. Relative
+// paths are resolved in ~/Library/Keychains.
+func openNamedOSStore(_ StoreLocation, path string) (Store, error) {
+	return keychainStore{path: path}, nil
+}
+
+// keychainStore is a single keychain file.
+type keychainStore struct {
+	path string
+}
+
+func (s keychainStore) Identities() ([]Identity, error) {
+	cpath := C.CString(s.path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	var status C.OSStatus
+	var unreadable C.int
+	items := C.copyKeychainIdentities(cpath, &status, &unreadable)
+	if unreadable != 0 {
+		return nil, &PlatformError{
+			Kind: ErrAccessDenied,
+			Code: "kSecReadPermStatus",
+			Hint: "the account running Caddy cannot read the keychain; the System keychain needs Caddy to run as root, such as from a launch daemon",
+			Err:  fmt.Errorf("keychain %s is not readable", s.path),
+		}
+	}
+	if status == C.errSecItemNotFound {
+		return []Identity{}, nil
+	}
+	if status != C.errSecSuccess {
+		return nil, fmt.Errorf("opening keychain %s: %w", s.path, keychainStatus(status))
+	}
+	defer C.CFRelease(C.CFTypeRef(items))
+
+	n := C.CFArrayGetCount(items)
+	identities := make([]Identity, 0, int(n))
+	for i := C.CFIndex(0); i < n; i++ {
+		identities = append(identities, &keychainIdentity{ref: C.retainIdentityAtIndex(items, i)})
+	}
+	return identities, nil
+}
+
+func (s keychainStore) Intermediates() ([]*x509.Certificate, error) {
+	return osIntermediates(LocationUser)
+}
+
+func (keychainStore) Close() {}
+
+// keychainIdentity is an identity of a keychain file. Its ref field is the
+// SecIdentityRef, as with tailscale/certstore identities, so the hardware
+// checks work on both.
+type keychainIdentity struct {
+	ref   C.SecIdentityRef
+	chain []*x509.Certificate
+}
+
+func (i *keychainIdentity) Certificate() (*x509.Certificate, error) {
+	chain, err := i.CertificateChain()
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+func (i *keychainIdentity) CertificateChain() ([]*x509.Certificate, error) {
+	if i.chain != nil {
+		return i.chain, nil
+	}
+	items := C.copyIdentityChainData(i.ref)
+	if items == 0 {
+		return nil, errors.New("error getting certificate from identity")
+	}
+	defer C.CFRelease(C.CFTypeRef(items))
+
+	n := C.CFArrayGetCount(items)
+	chain := make([]*x509.Certificate, 0, int(n))
+	for j := C.CFIndex(0); j < n; j++ {
+		data := C.dataAtIndex(items, j)
+		der := C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	i.chain = chain
+	return chain, nil
+}
+
+func (i *keychainIdentity) Signer() (crypto.Signer, error) {
+	if _, err := i.Certificate(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *keychainIdentity) Public() crypto.PublicKey {
+	if len(i.chain) == 0 {
+		return nil
+	}
+	return i.chain[0].PublicKey
+}
+
+func (i *keychainIdentity) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := i.signatureAlgorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) == 0 {
+		return nil, errors.New("empty digest")
+	}
+
+	var code C.CFIndex
+	signature := C.copyIdentitySignature(i.ref, algorithm, (*C.UInt8)(unsafe.Pointer(&digest[0])), C.CFIndex(len(digest)), &code)
+	if signature == 0 {
+		return nil, fmt.Errorf("signing with keychain identity: CFError %d", int(code))
+	}
+	defer C.CFRelease(C.CFTypeRef(signature))
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(signature)), C.int(C.CFDataGetLength(signature))), nil
+}
+
+// signatureAlgorithm returns the Security framework algorithm signing
+// digests of opts' hash with the identity's key type.
+func (i *keychainIdentity) signatureAlgorithm(opts crypto.SignerOpts) (C.SecKeyAlgorithm, error) {
+	hash := opts.HashFunc()
+	switch i.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return C.kSecKeyAlgorithmECDSASignatureDigestX962SHA256, nil
+		case crypto.SHA384:
+			return C.kSecKeyAlgorithmECDSASignatureDigestX962SHA384, nil
+		case crypto.SHA512:
+			return C.kSecKeyAlgorithmECDSASignatureDigestX962SHA512, nil
+		}
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return rsaPSSAlgorithm(hash)
+		}
+		switch hash {
+		case crypto.SHA256:
+			return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA256, nil
+		case crypto.SHA384:
+			return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384, nil
+		case crypto.SHA512:
+			return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA512, nil
+		}
+	default:
+		return 0, fmt.Errorf("unsupported key type %T", i.Public())
+	}
+	return 0, fmt.Errorf("unsupported hash %v", hash)
+}
+
+func rsaPSSAlgorithm(hash crypto.Hash) (C.SecKeyAlgorithm, error) {
+	switch hash {
+	case crypto.SHA256:
+		return C.kSecKeyAlgorithmRSASignatureDigestPSSSHA256, nil
+	case crypto.SHA384:
+		return C.kSecKeyAlgorithmRSASignatureDigestPSSSHA384, nil
+	case crypto.SHA512:
+		return C.kSecKeyAlgorithmRSASignatureDigestPSSSHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash %v", hash)
+}
+
+func (i *keychainIdentity) Close() {
+	if i.ref != 0 {
+		C.CFRelease(C.CFTypeRef(i.ref))
+		i.ref = 0
+	}
+}
+
+// Interface guards
+var (
+	_ IntermediateStore = keychainStore{}
+	_ crypto.Signer     = (*keychainIdentity)(nil)
+)
@@ -0,0 +1,29 @@
+package certstore
+
+import "strings"
+
+// keyStorage describes where an identity's private key is kept.
+type keyStorage struct {
+	// provider is the name of the CNG key storage provider or CryptoAPI
+	// CSP, such as "Microsoft Software Key Storage Provider".
+	provider string
+
+	// container is the name of the key container in the provider.
+	container string
+}
+
+// keyStorageOf returns where the identity's private key is kept. Both names
+// are empty when that cannot be determined, as on platforms other than
+// Windows.
+var keyStorageOf = identityKeyStorage
+
+// keyStoredIn reports whether the identity's private key is kept by the
+// provider and in the container, when set. Names match case-insensitively.
+func keyStoredIn(identity Identity, provider, container string) bool {
+	if provider == "" && container == "" {
+		return true
+	}
+	storage := keyStorageOf(identity)
+	return (provider == "" || strings.EqualFold(storage.provider, provider)) &&
+		(container == "" || strings.EqualFold(storage.container, container))
+}
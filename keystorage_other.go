@@ -0,0 +1,9 @@
+//go:build !windows
+
+package certstore
+
+// identityKeyStorage reports no key storage; only Windows names the
+// providers and containers of keys.
+func identityKeyStorage(Identity) keyStorage {
+	return keyStorage{}
+}
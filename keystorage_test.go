@@ -0,0 +1,37 @@
+package certstore
+
+import "testing"
+
+func TestCertSelector_KeyStorage(t *testing.T) {
+	key := newTestKey(t)
+	software := newFakeIdentity(newTestCertificate(t, "ksp.example.test", key), newFakeSigner(key.Public(), []byte("ok")))
+	software.keyStorage = keyStorage{provider: "Microsoft Software Key Storage Provider", container: "te-client-1"}
+	vendor := newFakeIdentity(newTestCertificate(t, "ksp.example.test", key), newFakeSigner(key.Public(), []byte("ok")))
+	vendor.keyStorage = keyStorage{provider: "Vendor Smart Card CSP", container: "te-client-2"}
+
+	tests := []struct {
+		name, provider, container string
+		want                      *fakeIdentity
+	}{
+		{name: "any provider", want: vendor},
+		{name: "provider", provider: "microsoft software key storage provider", want: software},
+		{name: "container", container: "te-client-2", want: vendor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(software, vendor))
+
+			selector := newTestSelector("^ksp\\.example\\.test$")
+			selector.KeyProvider, selector.KeyContainer = tt.provider, tt.container
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf != tt.want.cert {
+				t.Fatalf("expected serial %s, got %s", tt.want.cert.SerialNumber, cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package certstore
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// certKeyProvInfoPropID is CERT_KEY_PROV_INFO_PROP_ID, the certificate
+// property naming the provider and container of its private key.
+const certKeyProvInfoPropID = 2
+
+var (
+	modcrypt32                            = windows.NewLazySystemDLL("crypt32.dll")
+	procCertGetCertificateContextProperty = modcrypt32.NewProc("CertGetCertificateContextProperty")
+)
+
+// cryptKeyProvInfo is CRYPT_KEY_PROV_INFO.
+type cryptKeyProvInfo struct {
+	ContainerName  *uint16
+	ProvName       *uint16
+	ProvType       uint32
+	Flags          uint32
+	ProvParamCount uint32
+	ProvParams     uintptr
+	KeySpec        uint32
+}
+
+// identityKeyStorage reads the key provider information the store keeps
+// with the identity's certificate, without acquiring the key.
+func identityKeyStorage(identity Identity) keyStorage {
+	certCtx, ok := identityCertContext(identity)
+	if !ok {
+		return keyStorage{}
+	}
+
+	var size uint32
+	if ok, _, _ := procCertGetCertificateContextProperty.Call(
+		uintptr(unsafe.Pointer(certCtx)), certKeyProvInfoPropID, 0, uintptr(unsafe.Pointer(&size)),
+	); ok == 0 || size < uint32(unsafe.Sizeof(cryptKeyProvInfo{})) {
+		return keyStorage{}
+	}
+	// The strings follow the structure in the same buffer, which is
+	// allocated as uint64s to keep the structure aligned.
+	buf := make([]uint64, (size+7)/8)
+	if ok, _, _ := procCertGetCertificateContextProperty.Call(
+		uintptr(unsafe.Pointer(certCtx)), certKeyProvInfoPropID, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)),
+	); ok == 0 {
+		return keyStorage{}
+	}
+
+	info := (*cryptKeyProvInfo)(unsafe.Pointer(&buf[0]))
+	return keyStorage{
+		provider:  windows.UTF16PtrToString(info.ProvName),
+		container: windows.UTF16PtrToString(info.ContainerName),
+	}
+}
@@ -0,0 +1,55 @@
+package certstore
+
+import (
+	"sync"
+	"time"
+)
+
+// reuseLogInterval is how often the reuse of one cache entry is logged, so
+// debug logging on a busy proxy does not repeat the same line per hit.
+const reuseLogInterval = time.Minute
+
+// logThrottle deduplicates repetitive log entries per key. An entry is
+// allowed once per interval, and the next allowed entry reports how many
+// were suppressed since the previous one.
+type logThrottle struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+func newLogThrottle(interval time.Duration) *logThrottle {
+	return &logThrottle{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// allow reports whether an entry for key should be logged now and, if so,
+// how many entries for key were suppressed before it.
+func (t *logThrottle) allow(key string) (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		t.suppressed[key]++
+		return false, 0
+	}
+	suppressed := t.suppressed[key]
+	t.last[key] = now
+	delete(t.suppressed, key)
+	return true, suppressed
+}
+
+// forget drops the state kept for key.
+func (t *logThrottle) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.last, key)
+	delete(t.suppressed, key)
+}
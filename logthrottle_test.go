@@ -0,0 +1,62 @@
+package certstore
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogThrottle(t *testing.T) {
+	throttle := newLogThrottle(time.Minute)
+
+	if ok, suppressed := throttle.allow("a"); !ok || suppressed != 0 {
+		t.Fatalf("expected the first entry to be allowed, got ok=%v suppressed=%d", ok, suppressed)
+	}
+	for range 3 {
+		if ok, _ := throttle.allow("a"); ok {
+			t.Fatal("expected repeated entries within the interval to be suppressed")
+		}
+	}
+	if ok, _ := throttle.allow("b"); !ok {
+		t.Fatal("expected entries for another key to be allowed")
+	}
+
+	throttle.last["a"] = time.Now().Add(-time.Minute)
+	if ok, suppressed := throttle.allow("a"); !ok || suppressed != 3 {
+		t.Fatalf("expected the next entry to report 3 suppressed, got ok=%v suppressed=%d", ok, suppressed)
+	}
+
+	throttle.forget("a")
+	if ok, _ := throttle.allow("a"); !ok {
+		t.Fatal("expected a forgotten key to be allowed")
+	}
+}
+
+func TestCertificateCache_ReuseLogThrottled(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "throttle.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	var selectors []*CertSelector
+	for range 5 {
+		selector := newTestSelector("^throttle\\.example\\.test$")
+		selector.logger = zap.New(core)
+		if _, err := selector.loadCertificate(t.Context()); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+		selectors = append(selectors, selector)
+	}
+	for _, selector := range selectors {
+		selector.release()
+	}
+
+	if got := logs.FilterMessage("reusing cached certificate").Len(); got != 1 {
+		t.Fatalf("expected one reuse log entry, got %d", got)
+	}
+}
@@ -0,0 +1,57 @@
+package certstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern match modes.
+const (
+	matchRegex = "regex"
+	matchExact = "exact"
+	matchGlob  = "glob"
+)
+
+// compilePattern compiles a selector pattern according to its match mode:
+// "regex" (the default) as a regular expression, "exact" as the whole field
+// value and "glob" as a shell-style glob where "*" matches any run of
+// characters, "?" any single character and "\" escapes the next character.
+// Exact and glob patterns match the whole value, case-sensitively.
+func compilePattern(pattern, mode string) (*regexp.Regexp, error) {
+	switch mode {
+	case "", matchRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern '%s': %w", pattern, err)
+		}
+		return re, nil
+	case matchExact:
+		return regexp.Compile("^" + regexp.QuoteMeta(pattern) + "$")
+	case matchGlob:
+		return regexp.Compile(globToRegexp(pattern))
+	default:
+		return nil, fmt.Errorf("unsupported match_mode '%s': must be '%s', '%s' or '%s'", mode, matchRegex, matchExact, matchGlob)
+	}
+}
+
+// globToRegexp translates a glob into an anchored regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
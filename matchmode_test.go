@@ -0,0 +1,41 @@
+package certstore
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		mode, pattern, value string
+		want                 bool
+	}{
+		{"", `^client\..*$`, "client.example.com", true},
+		{"regex", "client", "my-client.example.com", true},
+		{"exact", "ACME Corp (Issuing CA 1)", "ACME Corp (Issuing CA 1)", true},
+		{"exact", "ACME Corp (Issuing CA 1)", "ACME Corp (Issuing CA 10)", false},
+		{"exact", "client.example.com", "clientxexample.com", false},
+		{"glob", "*.example.com", "client.example.com", true},
+		{"glob", "*.example.com", "client.example.com.evil", false},
+		{"glob", "client-?.example.com", "client-1.example.com", true},
+		{"glob", "client-?.example.com", "client-12.example.com", false},
+		{"glob", `Device \*`, "Device *", true},
+		{"glob", `Device \*`, "Device 1", false},
+		{"glob", "Kiosk (Floor *)", "Kiosk (Floor 3)", true},
+	}
+
+	for _, tt := range tests {
+		re, err := compilePattern(tt.pattern, tt.mode)
+		if err != nil {
+			t.Fatalf("compilePattern(%q, %q) failed: %v", tt.pattern, tt.mode, err)
+		}
+		if got := re.MatchString(tt.value); got != tt.want {
+			t.Errorf("%s pattern %q matching %q: expected %t, got %t", tt.mode, tt.pattern, tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestCompilePattern_Errors(t *testing.T) {
+	_, err := compilePattern("(", "regex")
+	assertErrorContains(t, err, "invalid regex pattern")
+
+	_, err = compilePattern("client", "fuzzy")
+	assertErrorContains(t, err, "unsupported match_mode 'fuzzy'")
+}
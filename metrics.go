@@ -0,0 +1,86 @@
+package certstore
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace, metricsSubsystem = "caddy", "certstore"
+
+var certstoreMetrics = struct {
+	enumerationDuration *prometheus.HistogramVec
+	interactionDenied   *prometheus.CounterVec
+	accessDenied        *prometheus.CounterVec
+	entryLoaded         *prometheus.GaugeVec
+	entryValidated      *prometheus.GaugeVec
+	leakedReferences    *prometheus.CounterVec
+	storeIdentities     *prometheus.GaugeVec
+}{
+	enumerationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "enumeration_duration_seconds",
+		Help:      "Time spent opening the OS certificate store, enumerating its identities and matching a selector.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"location", "result"}),
+	interactionDenied: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "interaction_denied_total",
+		Help:      "Private key accesses refused because the keychain would need user interaction.",
+	}, []string{"location", "policy"}),
+	accessDenied: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "access_denied_total",
+		Help:      "Certificate store and private key accesses denied by the OS.",
+	}, []string{"location", "operation", "code"}),
+	entryLoaded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_entry_loaded_timestamp_seconds",
+		Help:      "When the certificate held by a cache entry was loaded from the OS certificate store.",
+	}, []string{"cache_key", "location"}),
+	entryValidated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_entry_validated_timestamp_seconds",
+		Help:      "When the certificate held by a cache entry was last confirmed against the OS certificate store.",
+	}, []string{"cache_key", "location"}),
+	leakedReferences: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_leaked_references_total",
+		Help:      "Certificate cache references still held after the config that took them was cleaned up.",
+	}, []string{"location"}),
+	storeIdentities: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "store_identities",
+		Help:      "Identities found in an OS certificate store by its last enumeration.",
+	}, []string{"location", "store"}),
+}
+
+// registerMetrics registers the certstore collectors with registry. Registering
+// the same collectors again, as happens on config reloads or when several
+// modules share a registry, is not an error.
+func registerMetrics(registry *prometheus.Registry) error {
+	collectors := []prometheus.Collector{
+		certstoreMetrics.enumerationDuration,
+		certstoreMetrics.interactionDenied,
+		certstoreMetrics.accessDenied,
+		certstoreMetrics.entryLoaded,
+		certstoreMetrics.entryValidated,
+		certstoreMetrics.leakedReferences,
+		certstoreMetrics.storeIdentities,
+	}
+	for _, collector := range collectors {
+		err := registry.Register(collector)
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &alreadyRegistered) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRegisterMetrics_Idempotent(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	if err := registerMetrics(registry); err != nil {
+		t.Fatalf("first registration failed: %v", err)
+	}
+	if err := registerMetrics(registry); err != nil {
+		t.Fatalf("repeated registration should be ignored: %v", err)
+	}
+}
+
+func TestEnumerationDurationMetric(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "metrics.example.test", key)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))),
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))),
+	)
+
+	histogram := certstoreMetrics.enumerationDuration
+	matched := sampleCount(t, histogram, "user", "matched")
+	unmatched := sampleCount(t, histogram, "user", "no_match")
+
+	selector := newTestSelector("^metrics\\.example\\.test$")
+	store, identity, err := selector.snapshot().findIdentity(t.Context())
+	if err != nil {
+		t.Fatalf("findIdentity failed: %v", err)
+	}
+	closeCertificateResources(identity, store)
+
+	missing := newTestSelector("^missing\\.example\\.test$")
+	if _, _, err := missing.snapshot().findIdentity(t.Context()); err == nil {
+		t.Fatal("expected no match error")
+	}
+
+	if got := sampleCount(t, histogram, "user", "matched"); got != matched+1 {
+		t.Fatalf("expected matched enumeration to be observed once, got %d new samples", got-matched)
+	}
+	if got := sampleCount(t, histogram, "user", "no_match"); got != unmatched+1 {
+		t.Fatalf("expected unmatched enumeration to be observed once, got %d new samples", got-unmatched)
+	}
+}
+
+func sampleCount(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+
+	observer, err := histogram.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		t.Fatalf("get metric: %v", err)
+	}
+	metric := &dto.Metric{}
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatalf("write metric: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestCacheEntryTimeMetrics(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "age.example.test", key)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))),
+		newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))),
+	)
+
+	selector := newTestSelector("^age\\.example\\.test$")
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	entry := selector.cacheEntry
+	labels := entry.metricLabels()
+
+	loaded := gaugeValue(t, certstoreMetrics.entryLoaded, labels)
+	if loaded != float64(entry.info().LoadedAt.Unix()) {
+		t.Fatalf("expected loaded timestamp %d, got %v", entry.info().LoadedAt.Unix(), loaded)
+	}
+
+	before := entry.info()
+	if changed, err := entry.reselect(t.Context()); err != nil || changed {
+		t.Fatalf("expected unchanged re-selection, changed=%v err=%v", changed, err)
+	}
+	after := entry.info()
+	if !after.LoadedAt.Equal(before.LoadedAt) || !after.ValidatedAt.After(before.ValidatedAt) {
+		t.Fatalf("expected re-selection to update only the validation time: before=%+v after=%+v", before, after)
+	}
+	if got := gaugeValue(t, certstoreMetrics.entryValidated, labels); got != float64(after.ValidatedAt.Unix()) {
+		t.Fatalf("expected validated timestamp %d, got %v", after.ValidatedAt.Unix(), got)
+	}
+
+	selector.release()
+	if certstoreMetrics.entryLoaded.Delete(labels) {
+		t.Fatal("expected the released entry's metrics to be removed")
+	}
+}
+
+func gaugeValue(t *testing.T, gauge *prometheus.GaugeVec, labels prometheus.Labels) float64 {
+	t.Helper()
+
+	metric := &dto.Metric{}
+	if err := gauge.With(labels).Write(metric); err != nil {
+		t.Fatalf("write metric: %v", err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestAccessDeniedMetric(t *testing.T) {
+	resetCertificateCache(t)
+	withFakeStoreLoads(t, &fakeStoreLoad{openErr: fmt.Errorf("failed to open user cert store: %w", syscall.Errno(0x80070005))})
+
+	counter := certstoreMetrics.accessDenied.WithLabelValues("user", "open_store", "E_ACCESSDENIED")
+	before := testutil.ToFloat64(counter)
+	denied := sampleCount(t, certstoreMetrics.enumerationDuration, "user", "access_denied")
+
+	selector := newTestSelector("^denied\\.example\\.test$")
+	_, _, err := selector.snapshot().findIdentity(t.Context())
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(counter); got != before+1 {
+		t.Fatalf("expected one access denial to be counted, got %v", got-before)
+	}
+	if got := sampleCount(t, certstoreMetrics.enumerationDuration, "user", "access_denied"); got != denied+1 {
+		t.Fatalf("expected the enumeration to be labeled access_denied, got %d new samples", got-denied)
+	}
+}
+
+func TestLeakedReferencesMetric(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "leak.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+	released := newTestSelector("^leak\\.example\\.test$")
+	released.owner = "http.handlers.reverse_proxy > http.reverse_proxy.transport.certstore"
+	leaking := newTestSelector("^leak\\.example\\.test$")
+	leaking.owner = "certstore > selectors.leaky"
+	for _, selector := range []*CertSelector{released, leaking} {
+		if _, err := selector.loadCertificate(t.Context()); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+	}
+	released.release()
+	defer leaking.release()
+
+	counter := certstoreMetrics.leakedReferences.WithLabelValues("user")
+	before := testutil.ToFloat64(counter)
+	core, logs := observer.New(zapcore.WarnLevel)
+	if leaked := defaultApp.cache.reportLeaks(zap.New(core)); leaked != 1 {
+		t.Fatalf("expected one leaked reference, got %d", leaked)
+	}
+
+	if got := testutil.ToFloat64(counter); got != before+1 {
+		t.Fatalf("expected one leaked reference to be counted, got %v", got-before)
+	}
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one leak warning, got %d", len(entries))
+	}
+	owners, ok := entries[0].ContextMap()["owners"].([]any)
+	if !ok || len(owners) != 1 || owners[0] != leaking.owner {
+		t.Fatalf("expected the warning to name the leaking owner, got %v", entries[0].ContextMap()["owners"])
+	}
+}
+
+func TestStoreIdentitiesMetric(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	var identities []*fakeIdentity
+	for _, name := range []string{"inventory.example.test", "other.example.test", "third.example.test"} {
+		identities = append(identities, newFakeIdentity(newTestCertificate(t, name, key), newFakeSigner(key.Public(), []byte("ok"))))
+	}
+	withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+	selector := newTestSelector("^inventory\\.example\\.test$")
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if got := testutil.ToFloat64(certstoreMetrics.storeIdentities.WithLabelValues("user", "")); got != 3 {
+		t.Fatalf("expected 3 identities in the user store, got %v", got)
+	}
+}
+
+// storesByName opens a different store for each logical store name.
+type storesByName map[string]Store
+
+func (storesByName) OpenStore(StoreLocation) (Store, error) {
+	return nil, errors.New("unexpected open of the default store")
+}
+
+func (b storesByName) OpenNamedStore(_ StoreLocation, name string) (Store, error) {
+	return b[name], nil
+}
+
+func TestStoreIdentitiesMetric_NamedStores(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	newIdentity := func(name string) *fakeIdentity {
+		return newFakeIdentity(newTestCertificate(t, name, key), newFakeSigner(key.Public(), []byte("ok")))
+	}
+	t.Cleanup(SetBackend(storesByName{
+		"WebHosting": newFakeStoreLoadWithIdentities(newIdentity("web.example.test")).store,
+		"Partners": newFakeStoreLoadWithIdentities(
+			newIdentity("partner.example.test"),
+			newIdentity("other.example.test"),
+		).store,
+	}))
+
+	for _, name := range []string{"WebHosting", "Partners"} {
+		selector := newTestSelector("\\.example\\.test$")
+		selector.StoreName = name
+		if _, err := selector.loadCertificate(t.Context()); err != nil {
+			t.Fatalf("load of %s failed: %v", name, err)
+		}
+		defer selector.release()
+	}
+
+	for name, want := range map[string]float64{"WebHosting": 1, "Partners": 2} {
+		if got := testutil.ToFloat64(certstoreMetrics.storeIdentities.WithLabelValues("user", name)); got != want {
+			t.Fatalf("expected %v identities in the %s store, got %v", want, name, got)
+		}
+	}
+}
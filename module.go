@@ -4,12 +4,15 @@
 package certstore
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 )
 
@@ -27,6 +30,68 @@ type HTTPTransport struct {
 	// ClientCert specifies the criteria for selecting a client
 	// certificate from the OS certificate store for mTLS authentication.
 	ClientCert *CertSelector `json:"client_certificate,omitempty"`
+
+	// ClientCerts lists selectors tried in order at provisioning. The first
+	// one finding a certificate is used, so the config keeps working when
+	// one of several rotated certificates is missing from the store. It is
+	// mutually exclusive with ClientCert and ClientCertRef.
+	ClientCerts []*CertSelector `json:"client_certificates,omitempty"`
+
+	// ClientCertRef references a selector defined by name in the certstore
+	// app, so that multiple transports share one cached identity. It is
+	// mutually exclusive with ClientCert.
+	ClientCertRef string `json:"client_certificate_ref,omitempty"`
+
+	// ClientCertUpstreams restricts the client certificate to upstreams
+	// matching one of these patterns, a host glob optionally followed by a
+	// port such as "*.partner.com:8443". Connections to other upstreams are
+	// made without client auth. Default: all upstreams
+	ClientCertUpstreams []string `json:"client_certificate_upstreams,omitempty"`
+
+	// UpstreamClientCerts maps upstream patterns, in the syntax of
+	// ClientCertUpstreams, to the selector of the certificate presented when
+	// dialing matching upstreams, so one transport can authenticate to each
+	// backend of a mixed pool with a certificate of its own. When several
+	// patterns match, the most specific one wins: an exact host over a glob,
+	// then a pattern with a port over one without. Upstreams matching none
+	// get the transport's own certificate, if any.
+	UpstreamClientCerts map[string]*CertSelector `json:"upstream_client_certificates,omitempty"`
+
+	// HealthCheckNoClientCert makes reverse_proxy active health checks
+	// through this transport connect without a client certificate, for
+	// upstreams whose health endpoint rejects mTLS. Default: false
+	HealthCheckNoClientCert bool `json:"health_check_no_client_certificate,omitempty"`
+
+	// HealthCheckClientCertRef makes active health checks present the
+	// certificate of this named selector of the certstore app instead. It is
+	// mutually exclusive with HealthCheckNoClientCert.
+	HealthCheckClientCertRef string `json:"health_check_client_certificate_ref,omitempty"`
+
+	// Trust selects the roots upstream server certificates are verified
+	// against. "os" uses the OS trust store: the Windows ROOT and CA stores
+	// and the macOS keychain trust settings through the platform verifier,
+	// and the system CA bundle elsewhere, so internally issued server
+	// certificates are trusted without exporting PEM bundles. It cannot be
+	// combined with the CA options of the tls block. Default: "" (the tls
+	// block's settings)
+	Trust string `json:"trust,omitempty"`
+
+	// selector is the provisioned selector in use: ClientCert, one of
+	// ClientCerts or the named selector referenced by ClientCertRef.
+	selector *CertSelector
+
+	// upstreamSelectors are the provisioned UpstreamClientCerts, most
+	// specific pattern first.
+	upstreamSelectors []upstreamSelector
+
+	// rootCAs is the pool loaded for Trust, if any.
+	rootCAs *x509.CertPool
+
+	// healthCheckTransport carries active health checks when they use a
+	// client certificate policy of their own. It has its own connection
+	// pool, so connections made without the proxy's certificate are never
+	// reused for proxied requests.
+	healthCheckTransport *http.Transport
 }
 
 // CaddyModule returns the Caddy module information.
@@ -41,63 +106,190 @@ func (h HTTPTransport) CaddyModule() caddy.ModuleInfo {
 // from the OS certificate store based on the configured matcher criteria.
 // It compiles regex patterns if needed and validates the certificate exists.
 func (h *HTTPTransport) Provision(ctx caddy.Context) error {
-	// Support placeholders:
-	repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
-	if !ok {
-		repl = caddy.NewReplacer()
-	}
-
 	// Provision the embedded transport first
 	if err := h.HTTPTransport.Provision(ctx); err != nil {
 		return err
 	}
 
-	if h.ClientCert == nil {
-		return nil
+	if err := h.provisionTrust(); err != nil {
+		return err
+	}
+	if err := h.validateTLSClientCertificate(); err != nil {
+		return err
+	}
+	if err := h.provisionSelector(ctx); err != nil {
+		return err
 	}
+	if err := validateUpstreamPatterns("client_certificate_upstreams", h.ClientCertUpstreams); err != nil {
+		return err
+	}
+	if err := h.provisionUpstreamSelectors(ctx); err != nil {
+		return err
+	}
+	if h.selector != nil || len(h.upstreamSelectors) > 0 {
+		h.configureClientCertificate()
+	}
+	return h.provisionHealthCheckTransport(ctx)
+}
 
-	// Validate config
-	if h.ClientCert.Pattern == "" {
-		return fmt.Errorf("client_certificate must set 'pattern' property")
+// validateTLSClientCertificate rejects a client certificate configured in
+// the tls block alongside the transport's own: a handshake can only present
+// one of them, and the tls block's would be silently ignored.
+func (h *HTTPTransport) validateTLSClientCertificate() error {
+	t := h.HTTPTransport.TLS
+	if t == nil || t.ClientCertificateFile == "" && t.ClientCertificateAutomate == "" {
+		return nil
+	}
+	if h.ClientCert != nil || len(h.ClientCerts) > 0 || h.ClientCertRef != "" || len(h.UpstreamClientCerts) > 0 {
+		return fmt.Errorf("the tls client_certificate_file and client_certificate_automate options cannot be combined with a certstore client certificate")
 	}
+	return nil
+}
 
-	// Set up logger for the cert selector
-	h.ClientCert.logger = ctx.Logger()
+// configureClientCertificate makes the transport present the certificates of
+// its selectors. Only the client certificate callback and session cache of
+// the TLS config the http transport built are replaced, so its other
+// settings, such as the server name, CA pool and versions, are kept.
+func (h *HTTPTransport) configureClientCertificate() {
+	if h.Transport.TLSClientConfig == nil {
+		h.Transport.TLSClientConfig = new(tls.Config)
+	}
+	h.Transport.TLSClientConfig.GetClientCertificate = h.getClientCertificate
+	// Sessions resumed after a rotation would keep authenticating with the
+	// previous certificate.
+	if cache := h.Transport.TLSClientConfig.ClientSessionCache; cache != nil {
+		h.Transport.TLSClientConfig.ClientSessionCache = newRotatingSessionCache(h.clientCertSelectors(), cache)
+	}
+}
 
-	h.ClientCert.Pattern = repl.ReplaceKnown(h.ClientCert.Pattern, "")
-	h.ClientCert.Field = repl.ReplaceKnown(h.ClientCert.Field, "")
-	h.ClientCert.Location = repl.ReplaceKnown(h.ClientCert.Location, "")
+// provisionSelector resolves the selector used for client authentication:
+// the inline ClientCert, the first of ClientCerts finding a certificate or a
+// named selector owned by the certstore app.
+func (h *HTTPTransport) provisionSelector(ctx caddy.Context) error {
+	if h.ClientCert != nil && h.ClientCertRef != "" {
+		return fmt.Errorf("client_certificate and client_certificate_ref are mutually exclusive")
+	}
+	if len(h.ClientCerts) > 0 {
+		if h.ClientCert != nil || h.ClientCertRef != "" {
+			return fmt.Errorf("client_certificates is mutually exclusive with client_certificate and client_certificate_ref")
+		}
+		return h.provisionFirstSelector(ctx)
+	}
+	if h.ClientCert == nil && h.ClientCertRef == "" {
+		return nil
+	}
 
-	// Compile regex pattern
-	var err error
-	h.ClientCert.pattern, err = regexp.Compile(h.ClientCert.Pattern)
+	selector, err := resolveSelector(ctx, h.ClientCert, h.ClientCertRef)
 	if err != nil {
-		return fmt.Errorf("invalid regex pattern '%s': %w", h.ClientCert.Pattern, err)
+		return err
 	}
+	h.selector = selector
+	return nil
+}
 
-	// Load certificate from cache (or load and cache it)
-	_, err = h.ClientCert.loadCertificate()
+// provisionFirstSelector provisions the ClientCerts selectors in order and
+// keeps the first one finding a certificate. Selectors finding none are
+// released; any other error stops provisioning. When none finds one and the
+// last is optional, the transport goes without a client certificate.
+func (h *HTTPTransport) provisionFirstSelector(ctx caddy.Context) error {
+	var errs []error
+	for i, candidate := range h.ClientCerts {
+		selector, err := resolveSelector(ctx, candidate, "")
+		if err == nil && (selector.loaded() || i == len(h.ClientCerts)-1) {
+			h.selector = selector
+			return nil
+		}
+		if err == nil {
+			// An optional selector finding nothing before the last one
+			// falls through to the next.
+			candidate.release()
+			continue
+		}
+		candidate.release()
+		if !errors.Is(err, errNoMatchingIdentity) {
+			return fmt.Errorf("client_certificates[%d]: %w", i, err)
+		}
+		errs = append(errs, fmt.Errorf("client_certificates[%d]: %w", i, err))
+	}
+	return errors.Join(errs...)
+}
+
+// resolveSelector provisions the inline selector, or looks up the named
+// selector referenced by ref in the certstore app.
+func resolveSelector(ctx caddy.Context, inline *CertSelector, ref string) (*CertSelector, error) {
+	app, err := loadApp(ctx)
 	if err != nil {
-		return fmt.Errorf("no client certificate found in: %s matching pattern: %s", h.ClientCert.Location, h.ClientCert.Pattern)
+		return nil, err
 	}
 
-	if h.Transport.TLSClientConfig == nil {
-		h.Transport.TLSClientConfig = new(tls.Config)
+	if ref != "" {
+		return app.selector(ref)
 	}
-	h.Transport.TLSClientConfig.GetClientCertificate = h.getClientCertificate
 
-	return nil
+	if err := inline.provision(ctx, app); err != nil {
+		return nil, err
+	}
+	return inline, nil
 }
 
+// clientCertSelectors returns every selector whose certificate the transport
+// may present.
+func (h *HTTPTransport) clientCertSelectors() []*CertSelector {
+	var selectors []*CertSelector
+	if h.selector != nil {
+		selectors = append(selectors, h.selector)
+	}
+	for _, upstream := range h.upstreamSelectors {
+		selectors = append(selectors, upstream.selector)
+	}
+	return selectors
+}
+
+// getClientCertificate presents the certificate of the selector for the
+// upstream being dialed: its entry of UpstreamClientCerts or, failing that,
+// the transport's own selector when ClientCertUpstreams permits it.
 func (h *HTTPTransport) getClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-	cert, err := h.ClientCert.currentCertificate()
+	var ctx context.Context
+	if cri != nil {
+		ctx = cri.Context()
+	}
+	if selector := h.upstreamSelector(ctx); selector != nil {
+		return selectorClientCertificate(selector, cri)
+	}
+	if h.selector == nil || !h.presentsClientCertificate(ctx) {
+		return new(tls.Certificate), nil
+	}
+	return selectorClientCertificate(h.selector, cri)
+}
+
+// selectorClientCertificate returns the selector's current certificate for a
+// handshake. When the server would not accept it, the certificate the
+// selector finds for the server's acceptable CAs is returned instead, if
+// any, or else an empty certificate, as it is for an optional selector
+// without a certificate.
+func selectorClientCertificate(selector *CertSelector, cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if !selector.loaded() {
+		return new(tls.Certificate), nil
+	}
+	cert, err := selector.handshakeCertificate()
 	if err != nil {
 		return nil, err
 	}
-	if cri != nil {
-		if err := cri.SupportsCertificate(&cert); err != nil {
-			return new(tls.Certificate), nil
-		}
+	if cri == nil || cri.SupportsCertificate(&cert) == nil {
+		return &cert, nil
+	}
+	finishHandshake(cert)
+
+	cert, ok, err := selector.acceptableCACertificate(cri)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return new(tls.Certificate), nil
+	}
+	if cri.SupportsCertificate(&cert) != nil {
+		finishHandshake(cert)
+		return new(tls.Certificate), nil
 	}
 	return &cert, nil
 }
@@ -106,8 +298,21 @@ func (h *HTTPTransport) getClientCertificate(cri *tls.CertificateRequestInfo) (*
 // and decrements the reference count for the cached certificate. When the
 // reference count reaches zero, the certificate's OS resources are freed.
 func (h *HTTPTransport) Cleanup() error {
-	if h.ClientCert != nil && h.ClientCert.cacheKey != "" {
-		releaseCachedCertificate(h.ClientCert.cacheKey)
+	// Named selectors are owned and released by the certstore app.
+	if h.ClientCert != nil {
+		h.ClientCert.release()
+	}
+	for _, selector := range h.ClientCerts {
+		selector.release()
+	}
+	for _, selector := range h.UpstreamClientCerts {
+		if selector != nil {
+			selector.release()
+		}
+	}
+
+	if h.healthCheckTransport != nil {
+		h.healthCheckTransport.CloseIdleConnections()
 	}
 
 	err := h.HTTPTransport.Cleanup()
@@ -118,9 +323,126 @@ func (h *HTTPTransport) Cleanup() error {
 	return nil
 }
 
+// UnmarshalCaddyfile sets up the transport from Caddyfile tokens. It accepts
+// the options of the http transport along with its own:
+//
+//	transport certstore {
+//	    client_certificate [<pattern>] {
+//	        ...
+//	    }
+//	    # repeat client_certificate to try selectors in order
+//	    client_certificate_ref <name>
+//	    client_certificate_upstreams <pattern>...
+//	    upstream_client_certificate <upstream> [<pattern>] {
+//	        ...
+//	    }
+//	    health_check_no_client_certificate
+//	    health_check_client_certificate_ref <name>
+//	    trust os
+//	    # any http transport option, such as dial_timeout or tls_server_name
+//	}
+func (h *HTTPTransport) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume transport name
+
+	// Parse the certstore options and remove their tokens, leaving the rest
+	// of the block to the embedded http transport.
+	for d.NextBlock(0) {
+		parse, ok := transportSubdirectives[d.Val()]
+		if !ok {
+			d.NextSegment()
+			continue
+		}
+		segment := d.NextSegment()
+		if err := parse(h, caddyfile.NewDispenser(segment)); err != nil {
+			return err
+		}
+		d.DeleteN(len(segment))
+	}
+
+	d.Reset()
+	h.HTTPTransport = new(reverseproxy.HTTPTransport)
+	return h.HTTPTransport.UnmarshalCaddyfile(d)
+}
+
+// transportSubdirectives parse the options of the transport's Caddyfile
+// block that the http transport does not know, each given a dispenser
+// holding only the option's tokens.
+var transportSubdirectives = map[string]func(h *HTTPTransport, d *caddyfile.Dispenser) error{
+	"client_certificate": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		selector := new(CertSelector)
+		if err := selector.UnmarshalCaddyfile(d); err != nil {
+			return err
+		}
+		// A repeated client_certificate lists fallbacks tried in order.
+		switch {
+		case len(h.ClientCerts) > 0:
+			h.ClientCerts = append(h.ClientCerts, selector)
+		case h.ClientCert != nil:
+			h.ClientCerts = []*CertSelector{h.ClientCert, selector}
+			h.ClientCert = nil
+		default:
+			h.ClientCert = selector
+		}
+		return nil
+	},
+	"client_certificate_ref": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		return parseStringArg(d, &h.ClientCertRef)
+	},
+	"client_certificate_upstreams": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		patterns := d.RemainingArgs()
+		if len(patterns) == 0 {
+			return d.ArgErr()
+		}
+		h.ClientCertUpstreams = append(h.ClientCertUpstreams, patterns...)
+		return nil
+	},
+	"upstream_client_certificate": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		upstream := d.Val()
+		if _, ok := h.UpstreamClientCerts[upstream]; ok {
+			return d.Errf("duplicate upstream_client_certificate for '%s'", upstream)
+		}
+		// The rest of the tokens form a selector block once the upstream is
+		// removed.
+		d.Delete()
+		d.Reset()
+		selector := new(CertSelector)
+		if err := selector.UnmarshalCaddyfile(d); err != nil {
+			return err
+		}
+		if h.UpstreamClientCerts == nil {
+			h.UpstreamClientCerts = make(map[string]*CertSelector)
+		}
+		h.UpstreamClientCerts[upstream] = selector
+		return nil
+	},
+	"health_check_no_client_certificate": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		h.HealthCheckNoClientCert = true
+		return nil
+	},
+	"health_check_client_certificate_ref": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		return parseStringArg(d, &h.HealthCheckClientCertRef)
+	},
+	"trust": func(h *HTTPTransport, d *caddyfile.Dispenser) error {
+		d.Next() // consume option name
+		return parseStringArg(d, &h.Trust)
+	},
+}
+
 // Interface guards
 var (
 	_ caddy.Provisioner         = (*HTTPTransport)(nil)
+	_ caddyfile.Unmarshaler     = (*HTTPTransport)(nil)
 	_ http.RoundTripper         = (*HTTPTransport)(nil)
 	_ caddy.CleanerUpper        = (*HTTPTransport)(nil)
 	_ reverseproxy.TLSTransport = (*HTTPTransport)(nil)
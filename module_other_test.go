@@ -0,0 +1,13 @@
+//go:build !windows && !(darwin && cgo)
+
+package certstore
+
+import "testing"
+
+// importTestCertificate skips tests that need an OS certificate store, which
+// this platform does not have.
+func importTestCertificate(t *testing.T) {
+	t.Skip("no OS certificate store on this platform")
+}
+
+func removeTestCertificate(*testing.T) {}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
@@ -212,6 +213,99 @@ func TestHTTPTransport_GetClientCertificate(t *testing.T) {
 	}
 }
 
+func TestHTTPTransport_PreservesTLSSettings(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	withFakeStoreLoads(t, newFakeStoreLoad(newTestCertificate(t, "merge.example.test", key), newFakeSigner(key.Public(), []byte("ok"))))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEMFile(t, caFile, nil, newTestCA(t, "Upstream CA").cert)
+
+	h := &HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{
+			TLS: &reverseproxy.TLSConfig{
+				ServerName:     "upstream.example.test",
+				RootCAPEMFiles: []string{caFile},
+			},
+		},
+		ClientCert: newTestSelector("^merge\\.example\\.test$"),
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer func() {
+		if err := h.Cleanup(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	config := h.Transport.TLSClientConfig
+	if config.ServerName != "upstream.example.test" || config.RootCAs == nil {
+		t.Fatalf("expected the tls settings to be kept, got server name %q and root CAs %v", config.ServerName, config.RootCAs)
+	}
+	if config.GetClientCertificate == nil || len(config.Certificates) != 0 {
+		t.Fatal("expected the client certificate to be presented through the callback only")
+	}
+}
+
+func TestHTTPTransport_TLSClientCertificateConflict(t *testing.T) {
+	tests := map[string]*reverseproxy.TLSConfig{
+		"client_certificate_file":     {ClientCertificateFile: "client.pem", ClientCertificateKeyFile: "client.key"},
+		"client_certificate_automate": {ClientCertificateAutomate: "client.example.test"},
+	}
+
+	for name, tlsConfig := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &HTTPTransport{
+				HTTPTransport: &reverseproxy.HTTPTransport{TLS: tlsConfig},
+				ClientCertRef: "banking",
+			}
+			assertErrorContains(t, h.validateTLSClientCertificate(), "cannot be combined")
+
+			h.ClientCertRef = ""
+			if err := h.validateTLSClientCertificate(); err != nil {
+				t.Fatalf("expected the tls client certificate alone to be accepted: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPTransport_OptionalClientCertificate(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	withFakeStoreLoads(t, newFakeStoreLoad(newTestCertificate(t, "other.example.test", key), newFakeSigner(key.Public(), []byte("other"))))
+
+	selector := newTestSelector("^optional\\.example\\.test$")
+	selector.Optional = true
+	h := &HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{},
+		ClientCert:    selector,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision of an optional selector without a match failed: %v", err)
+	}
+	defer func() {
+		if err := h.Cleanup(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	cert, err := h.Transport.TLSClientConfig.GetClientCertificate(supportedCertificateRequestInfo())
+	if err != nil {
+		t.Fatalf("GetClientCertificate failed: %v", err)
+	}
+	if len(cert.Certificate) != 0 || cert.PrivateKey != nil {
+		t.Fatal("expected no client certificate without a match")
+	}
+}
+
 func TestClientCertificateRefreshRotation(t *testing.T) {
 	resetCertificateCache(t)
 
@@ -261,6 +355,72 @@ func TestClientCertificateRefreshRotation(t *testing.T) {
 	}
 }
 
+func TestClientCertificateRefreshInterval(t *testing.T) {
+	resetCertificateCache(t)
+
+	initialKey := newTestKey(t)
+	renewedKey := newTestKey(t)
+	initialCert := newTestCertificate(t, "refresh-interval.example.test", initialKey)
+	renewedCert := newTestCertificate(t, "refresh-interval.example.test", renewedKey)
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("initial"))),
+		newFakeStoreLoad(initialCert, newFakeSigner(initialKey.Public(), []byte("unchanged"))),
+		newFakeStoreLoad(renewedCert, newFakeSigner(renewedKey.Public(), []byte("renewed"))),
+	}
+	provider := withFakeStoreLoads(t, loads...)
+
+	selector := newTestSelector("^refresh-interval\\.example\\.test$")
+	selector.RefreshInterval = caddy.Duration(10 * time.Millisecond)
+	h := &HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{},
+		ClientCert:    selector,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	cleanedUp := false
+	defer func() {
+		if !cleanedUp {
+			_ = h.Cleanup()
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := h.Transport.TLSClientConfig.GetClientCertificate(supportedCertificateRequestInfo())
+		if err != nil {
+			t.Fatalf("GetClientCertificate failed: %v", err)
+		}
+		if _, err := current.PrivateKey.(crypto.Signer).Sign(nil, []byte("digest"), crypto.SHA256); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		if current.Leaf.SerialNumber.Cmp(renewedCert.SerialNumber) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected renewed serial %s, still got %s", renewedCert.SerialNumber, current.Leaf.SerialNumber)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if loads[0].identity.closeCount() != 1 || loads[1].identity.closeCount() != 1 {
+		t.Fatal("expected the replaced and unchanged resources to be closed")
+	}
+
+	// Closing the entry stops the refresh.
+	if err := h.Cleanup(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	cleanedUp = true
+	opens := provider.openCount()
+	time.Sleep(50 * time.Millisecond)
+	if provider.openCount() != opens {
+		t.Fatal("expected no refresh after cleanup")
+	}
+}
+
 func TestCertSelector_LoadCertificate(t *testing.T) {
 	importTestCertificate(t)
 	defer removeTestCertificate(t)
@@ -321,8 +481,9 @@ func TestCertSelector_LoadCertificate(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Failed to compile pattern: %v", err)
 			}
+			tt.selector.cache = defaultApp.cache
 
-			cert, err := tt.selector.loadCertificate()
+			cert, err := tt.selector.loadCertificate(t.Context())
 
 			if tt.expectError {
 				if err == nil {
@@ -338,9 +499,7 @@ func TestCertSelector_LoadCertificate(t *testing.T) {
 			}
 
 			// Cleanup
-			if tt.selector.cacheKey != "" {
-				releaseCachedCertificate(tt.selector.cacheKey)
-			}
+			tt.selector.release()
 		})
 	}
 }
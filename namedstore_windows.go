@@ -0,0 +1,64 @@
+package certstore
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/tailscale/certstore"
+	"golang.org/x/sys/windows"
+)
+
+// openNamedOSStore opens the logical store name of location, read-only.
+// tailscale/certstore only opens the Personal ("MY") store, so the store it
+// opens is retargeted to a handle of the named store, reusing its identity
+// enumeration and signing.
+func openNamedOSStore(location StoreLocation, name string) (Store, error) {
+	flags := uint32(windows.CERT_SYSTEM_STORE_LOCAL_MACHINE)
+	storeLocation := certstore.System
+	if location == LocationUser {
+		flags = windows.CERT_SYSTEM_STORE_CURRENT_USER
+		storeLocation = certstore.User
+	}
+	flags |= windows.CERT_STORE_OPEN_EXISTING_FLAG | windows.CERT_STORE_READONLY_FLAG
+
+	storeName, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM_W, 0, 0, flags, uintptr(unsafe.Pointer(storeName)))
+	if err != nil {
+		return nil, fmt.Errorf("opening certificate store '%s': %w", name, err)
+	}
+
+	store, err := certstore.Open(storeLocation, certstore.ReadOnly)
+	if err != nil {
+		windows.CertCloseStore(handle, 0)
+		return nil, err
+	}
+	if err := replaceStoreHandle(store, handle); err != nil {
+		windows.CertCloseStore(handle, 0)
+		store.Close()
+		return nil, err
+	}
+	return osStore{store: store, location: location}, nil
+}
+
+// replaceStoreHandle swaps the store handle held by a tailscale/certstore
+// store for handle, closing the previous one.
+func replaceStoreHandle(store certstore.Store, handle windows.Handle) error {
+	v := reflect.ValueOf(store)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported certificate store implementation %T", store)
+	}
+	field := v.Elem().FieldByName("store")
+	if !field.IsValid() || field.Kind() != reflect.Uintptr {
+		return fmt.Errorf("unsupported certificate store implementation %T", store)
+	}
+
+	field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+	previous := windows.Handle(field.Uint())
+	field.SetUint(uint64(handle))
+	windows.CertCloseStore(previous, 0)
+	return nil
+}
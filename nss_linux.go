@@ -0,0 +1,510 @@
+//go:build cgo && nss
+
+package certstore
+
+/*
+#cgo pkg-config: nss
+#include <stdlib.h>
+#include <nss.h>
+#include <pk11pub.h>
+#include <cert.h>
+#include <keyhi.h>
+#include <secerr.h>
+#include <prerror.h>
+
+// openDatabase opens the shared database described by spec as a slot of its
+// own, initializing NSS without a database first unless the process already
+// did.
+static PK11SlotInfo *openDatabase(const char *spec, int writable, int *code) {
+	if (!NSS_IsInitialized() && NSS_NoDB_Init(NULL) != SECSuccess) {
+		*code = PORT_GetError();
+		return NULL;
+	}
+	PK11SlotInfo *slot = SECMOD_OpenUserDB(spec);
+	if (slot == NULL) {
+		*code = PORT_GetError();
+		return NULL;
+	}
+	// Databases without a primary password, as browsers create them,
+	// are unlocked by the empty password; new databases are given one.
+	SECStatus rv = SECSuccess;
+	if (PK11_NeedUserInit(slot)) {
+		if (writable) {
+			rv = PK11_InitPin(slot, NULL, "");
+		}
+	} else if (PK11_NeedLogin(slot) && !PK11_IsLoggedIn(slot, NULL)) {
+		rv = PK11_CheckUserPassword(slot, "");
+	}
+	if (rv != SECSuccess) {
+		*code = PORT_GetError();
+		SECMOD_CloseUserDB(slot);
+		PK11_FreeSlot(slot);
+		return NULL;
+	}
+	return slot;
+}
+
+static void closeDatabase(PK11SlotInfo *slot) {
+	SECMOD_CloseUserDB(slot);
+	PK11_FreeSlot(slot);
+}
+
+static CERTCertListNode *listHead(CERTCertList *list) {
+	CERTCertListNode *node = CERT_LIST_HEAD(list);
+	return CERT_LIST_END(node, list) ? NULL : node;
+}
+
+static CERTCertListNode *listNext(CERTCertList *list, CERTCertListNode *node) {
+	node = CERT_LIST_NEXT(node);
+	return CERT_LIST_END(node, list) ? NULL : node;
+}
+
+// chainData returns the DER encoding of the certificate followed by the
+// issuers NSS finds for it, up to and including the root as on the other
+// platforms.
+static CERTCertificateList *chainData(CERTCertificate *cert) {
+	return CERT_CertChainFromCert(cert, certUsageSSLClient, PR_TRUE);
+}
+
+static SECItem *chainItem(CERTCertificateList *chain, int i) {
+	return &chain->certs[i];
+}
+
+// signDigest signs digest with the key using mechanism, returning the error
+// code in code on failure.
+static SECStatus signDigest(SECKEYPrivateKey *key, CK_MECHANISM_TYPE mechanism, SECItem *params,
+		unsigned char *digest, unsigned int length, SECItem *signature, int *code) {
+	SECItem data = { siBuffer, digest, length };
+	int signatureLength = PK11_SignatureLen(key);
+	if (signatureLength <= 0 || SECITEM_AllocItem(NULL, signature, signatureLength) == NULL) {
+		*code = PORT_GetError();
+		return SECFailure;
+	}
+	if (PK11_SignWithMechanism(key, mechanism, params, signature, &data) != SECSuccess) {
+		*code = PORT_GetError();
+		SECITEM_FreeItem(signature, PR_FALSE);
+		return SECFailure;
+	}
+	return SECSuccess;
+}
+
+static SECStatus signPSS(SECKEYPrivateKey *key, CK_MECHANISM_TYPE hash, CK_RSA_PKCS_MGF_TYPE mgf,
+		unsigned long saltLength, unsigned char *digest, unsigned int length, SECItem *signature, int *code) {
+	CK_RSA_PKCS_PSS_PARAMS pss = { hash, mgf, saltLength };
+	SECItem params = { siBuffer, (unsigned char *)&pss, sizeof(pss) };
+	return signDigest(key, CKM_RSA_PKCS_PSS, &params, digest, length, signature, code);
+}
+
+// importKey imports a PKCS#8 private key whose public key is publicValue.
+static SECStatus importKey(PK11SlotInfo *slot, unsigned char *pkcs8, unsigned int pkcs8Length,
+		unsigned char *publicValue, unsigned int publicLength, int *code) {
+	SECItem keyItem = { siBuffer, pkcs8, pkcs8Length };
+	SECItem publicItem = { siBuffer, publicValue, publicLength };
+	SECKEYPrivateKey *key = NULL;
+	if (PK11_ImportDERPrivateKeyInfoAndReturnKey(slot, &keyItem, NULL, &publicItem,
+			PR_TRUE, PR_TRUE, KU_ALL, &key, NULL) != SECSuccess) {
+		*code = PORT_GetError();
+		return SECFailure;
+	}
+	SECKEY_DestroyPrivateKey(key);
+	return SECSuccess;
+}
+
+// importCertificate imports a DER certificate under nickname.
+static SECStatus importCertificate(PK11SlotInfo *slot, unsigned char *der, unsigned int length,
+		const char *nickname, int *code) {
+	SECItem item = { siBuffer, der, length };
+	CERTCertificate *cert = CERT_NewTempCertificate(CERT_GetDefaultCertDB(), &item, NULL, PR_FALSE, PR_TRUE);
+	if (cert == NULL) {
+		*code = PORT_GetError();
+		return SECFailure;
+	}
+	SECStatus rv = PK11_ImportCert(slot, cert, CK_INVALID_HANDLE, nickname, PR_FALSE);
+	if (rv != SECSuccess) {
+		*code = PORT_GetError();
+	}
+	CERT_DestroyCertificate(cert);
+	return rv;
+}
+*/
+import "C"
+
+import (
+	"cmp"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// nssDatabases are the databases opened by stores, by directory. NSS opens
+// a directory only once, so stores of the same database share its slot.
+var nssDatabases = struct {
+	sync.Mutex
+	open map[string]*nssDatabase
+}{open: make(map[string]*nssDatabase)}
+
+// nssDatabase is an opened NSS shared database.
+type nssDatabase struct {
+	slot     *C.PK11SlotInfo
+	writable bool
+	refs     int
+}
+
+// openNSSStore opens the NSS shared database in the directory path, or the
+// user's ~/.pki/nssdb when path is empty. The "sql:" prefix certutil takes
+// is accepted.
+func openNSSStore(path string, writable bool) (Store, error) {
+	dir, err := nssDatabaseDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nssDatabases.Lock()
+	defer nssDatabases.Unlock()
+
+	if db, ok := nssDatabases.open[dir]; ok {
+		if writable && !db.writable {
+			return nil, fmt.Errorf("NSS database %s is already open read-only", dir)
+		}
+		db.refs++
+		return &nssStore{dir: dir, db: db}, nil
+	}
+
+	flags := "flags=readOnly"
+	if writable {
+		flags = ""
+	}
+	spec := C.CString(fmt.Sprintf("configdir='sql:%s' %s", nssQuote(dir), flags))
+	defer C.free(unsafe.Pointer(spec))
+
+	var code C.int
+	slot := C.openDatabase(spec, boolToCInt(writable), &code)
+	if slot == nil {
+		return nil, fmt.Errorf("opening NSS database %s: %w", dir, nssError(code))
+	}
+	db := &nssDatabase{slot: slot, writable: writable, refs: 1}
+	nssDatabases.open[dir] = db
+	return &nssStore{dir: dir, db: db}, nil
+}
+
+// nssDatabaseDir resolves the database directory of path.
+func nssDatabaseDir(path string) (string, error) {
+	path = strings.TrimPrefix(path, "sql:")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("locating the user's NSS database: %w", err)
+		}
+		path = filepath.Join(home, ".pki", "nssdb")
+	}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving NSS database path %s: %w", path, err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("opening NSS database: %w", err)
+	}
+	return dir, nil
+}
+
+// nssQuote escapes a value of a single-quoted module spec parameter.
+func nssQuote(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+func boolToCInt(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nssError describes an NSS error code.
+func nssError(code C.int) error {
+	if name := C.PR_ErrorToName(C.PRErrorCode(code)); name != nil {
+		return errors.New(C.GoString(name))
+	}
+	return fmt.Errorf("NSS error %d", int(code))
+}
+
+// nssStore is an NSS shared database.
+type nssStore struct {
+	dir    string
+	db     *nssDatabase
+	closed bool
+}
+
+func (s *nssStore) Identities() ([]Identity, error) {
+	list := C.PK11_ListCertsInSlot(s.db.slot)
+	if list == nil {
+		return []Identity{}, nil
+	}
+	defer C.CERT_DestroyCertList(list)
+
+	var identities []Identity
+	for node := C.listHead(list); node != nil; node = C.listNext(list, node) {
+		key := C.PK11_FindPrivateKeyFromCert(s.db.slot, node.cert, nil)
+		if key == nil {
+			continue
+		}
+		identities = append(identities, &nssIdentity{cert: C.CERT_DupCertificate(node.cert), key: key})
+	}
+	if identities == nil {
+		return []Identity{}, nil
+	}
+	return identities, nil
+}
+
+// Import adds the identity and its intermediates to the database.
+func (s *nssStore) Import(pfx []byte, password string) error {
+	key, leaf, intermediates, err := pkcs12.DecodeChain(pfx, password)
+	if err != nil {
+		return fmt.Errorf("decoding pfx: %w", err)
+	}
+	publicValue, err := nssPublicValue(leaf.PublicKey)
+	if err != nil {
+		return err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encoding private key: %w", err)
+	}
+
+	var code C.int
+	if C.importKey(s.db.slot, (*C.uchar)(&pkcs8[0]), C.uint(len(pkcs8)),
+		(*C.uchar)(&publicValue[0]), C.uint(len(publicValue)), &code) != C.SECSuccess {
+		return fmt.Errorf("importing private key into %s: %w", s.dir, nssError(code))
+	}
+	if err := s.importCertificate(leaf); err != nil {
+		return err
+	}
+	for _, cert := range intermediates {
+		if err := s.importCertificate(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *nssStore) importCertificate(cert *x509.Certificate) error {
+	cnickname := C.CString(nssNickname(cert))
+	defer C.free(unsafe.Pointer(cnickname))
+
+	var code C.int
+	if C.importCertificate(s.db.slot, (*C.uchar)(&cert.Raw[0]), C.uint(len(cert.Raw)), cnickname, &code) != C.SECSuccess {
+		return fmt.Errorf("importing certificate %s into %s: %w", cert.Subject, s.dir, nssError(code))
+	}
+	return nil
+}
+
+// nssNickname returns the name certutil lists the certificate under: its
+// common name, or its serial number when it has none.
+func nssNickname(cert *x509.Certificate) string {
+	return cmp.Or(cert.Subject.CommonName, cert.SerialNumber.Text(16))
+}
+
+// nssPublicValue returns the public key value NSS derives key IDs from, as
+// the certificate does when it is imported after its key.
+func nssPublicValue(pub crypto.PublicKey) ([]byte, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pub.N.Bytes(), nil
+	case *ecdsa.PublicKey:
+		key, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return key.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for NSS databases", pub)
+	}
+}
+
+func (s *nssStore) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	nssDatabases.Lock()
+	defer nssDatabases.Unlock()
+	s.db.refs--
+	if s.db.refs == 0 {
+		C.closeDatabase(s.db.slot)
+		delete(nssDatabases.open, s.dir)
+	}
+}
+
+// nssIdentity is a certificate of an NSS database and its private key.
+type nssIdentity struct {
+	cert  *C.CERTCertificate
+	key   *C.SECKEYPrivateKey
+	chain []*x509.Certificate
+}
+
+func (i *nssIdentity) Certificate() (*x509.Certificate, error) {
+	chain, err := i.CertificateChain()
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+func (i *nssIdentity) CertificateChain() ([]*x509.Certificate, error) {
+	if i.chain != nil {
+		return i.chain, nil
+	}
+	leaf, err := x509.ParseCertificate(C.GoBytes(unsafe.Pointer(i.cert.derCert.data), C.int(i.cert.derCert.len)))
+	if err != nil {
+		return nil, err
+	}
+	chain := []*x509.Certificate{leaf}
+	if list := C.chainData(i.cert); list != nil {
+		defer C.CERT_DestroyCertificateList(list)
+		// The first certificate of the list is the leaf.
+		for j := 1; j < int(list.len); j++ {
+			item := C.chainItem(list, C.int(j))
+			cert, err := x509.ParseCertificate(C.GoBytes(unsafe.Pointer(item.data), C.int(item.len)))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, cert)
+		}
+	}
+	i.chain = chain
+	return chain, nil
+}
+
+func (i *nssIdentity) Signer() (crypto.Signer, error) {
+	if _, err := i.Certificate(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *nssIdentity) Public() crypto.PublicKey {
+	if len(i.chain) == 0 {
+		return nil
+	}
+	return i.chain[0].PublicKey
+}
+
+func (i *nssIdentity) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if len(digest) == 0 {
+		return nil, errors.New("empty digest")
+	}
+
+	var signature C.SECItem
+	var code C.int
+	var rv C.SECStatus
+	switch pub := i.Public().(type) {
+	case *ecdsa.PublicKey:
+		rv = C.signDigest(i.key, C.CKM_ECDSA, nil, (*C.uchar)(&digest[0]), C.uint(len(digest)), &signature, &code)
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			hash, mgf, err := nssPSSMechanisms(pss.HashFunc())
+			if err != nil {
+				return nil, err
+			}
+			rv = C.signPSS(i.key, hash, mgf, C.ulong(pssSaltLength(pub, pss)),
+				(*C.uchar)(&digest[0]), C.uint(len(digest)), &signature, &code)
+			break
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+		}
+		data := append(append([]byte{}, prefix...), digest...)
+		rv = C.signDigest(i.key, C.CKM_RSA_PKCS, nil, (*C.uchar)(&data[0]), C.uint(len(data)), &signature, &code)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+	if rv != C.SECSuccess {
+		return nil, fmt.Errorf("signing with NSS identity: %w", nssError(code))
+	}
+	defer C.SECITEM_FreeItem(&signature, C.PR_FALSE)
+
+	out := C.GoBytes(unsafe.Pointer(signature.data), C.int(signature.len))
+	if _, ok := i.Public().(*ecdsa.PublicKey); ok {
+		return encodeECDSASignature(out)
+	}
+	return out, nil
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers PKCS#1 v1.5 signatures
+// put in front of the digest, which CKM_RSA_PKCS leaves to the caller.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// nssPSSMechanisms returns the PKCS#11 hash and MGF1 of a PSS signature.
+func nssPSSMechanisms(hash crypto.Hash) (C.CK_MECHANISM_TYPE, C.CK_RSA_PKCS_MGF_TYPE, error) {
+	switch hash {
+	case crypto.SHA256:
+		return C.CKM_SHA256, C.CKG_MGF1_SHA256, nil
+	case crypto.SHA384:
+		return C.CKM_SHA384, C.CKG_MGF1_SHA384, nil
+	case crypto.SHA512:
+		return C.CKM_SHA512, C.CKG_MGF1_SHA512, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported hash %v", hash)
+}
+
+// pssSaltLength returns the salt length opts ask for, the largest the key
+// allows for rsa.PSSSaltLengthAuto.
+func pssSaltLength(pub *rsa.PublicKey, opts *rsa.PSSOptions) int {
+	switch opts.SaltLength {
+	case rsa.PSSSaltLengthEqualsHash:
+		return opts.Hash.Size()
+	case rsa.PSSSaltLengthAuto:
+		return (pub.N.BitLen()-1+7)/8 - 2 - opts.Hash.Size()
+	default:
+		return opts.SaltLength
+	}
+}
+
+// encodeECDSASignature converts the r || s signature of CKM_ECDSA to the
+// ASN.1 form crypto.Signer returns.
+func encodeECDSASignature(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature length %d", len(raw))
+	}
+	half := len(raw) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+func (i *nssIdentity) Close() {
+	if i.key != nil {
+		C.SECKEY_DestroyPrivateKey(i.key)
+		i.key = nil
+	}
+	if i.cert != nil {
+		C.CERT_DestroyCertificate(i.cert)
+		i.cert = nil
+	}
+}
+
+// Interface guards
+var (
+	_ Importer      = (*nssStore)(nil)
+	_ crypto.Signer = (*nssIdentity)(nil)
+)
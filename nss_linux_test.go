@@ -0,0 +1,107 @@
+//go:build cgo && nss
+
+package certstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// importNSSIdentity imports the identity issued by ca for key into the NSS
+// database in dir, creating the database if needed.
+func importNSSIdentity(t *testing.T, dir string, ca *testCA, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	cert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "nss.example.test"}}, key.Public())
+	pfx, err := pkcs12.Encode(rand.Reader, key, cert, []*x509.Certificate{ca.cert}, "secret")
+	if err != nil {
+		t.Fatalf("encode pfx: %v", err)
+	}
+	store, err := openNSSStore(dir, true)
+	if err != nil {
+		t.Fatalf("open writable NSS database: %v", err)
+	}
+	defer store.Close()
+	if err := store.(Importer).Import(pfx, "secret"); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	return cert
+}
+
+func TestCertSelector_NSSDatabase(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ca := newTestCA(t, "NSS CA")
+
+	for name, key := range map[string]crypto.Signer{"ecdsa": newTestKey(t), "rsa": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			resetCertificateCache(t)
+			dir := t.TempDir()
+			want := importNSSIdentity(t, dir, ca, key)
+
+			selector := newTestSelector("^nss\\.example\\.test$")
+			selector.Location, selector.NSSDatabase = "nssdb", "sql:"+dir
+			// The issuer is a root, presented only with send_root.
+			selector.SendRoot = true
+			if err := selector.validate(); err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if !cert.Leaf.Equal(want) {
+				t.Fatalf("expected the imported certificate, got %s", cert.Leaf.Subject)
+			}
+			if len(cert.Certificate) != 2 {
+				t.Fatalf("expected the leaf and its issuer, got %d certificates", len(cert.Certificate))
+			}
+
+			digest := sha256.Sum256([]byte("nss"))
+			signer := cert.PrivateKey.(crypto.Signer)
+			opts := []crypto.SignerOpts{crypto.SHA256}
+			if name == "rsa" {
+				opts = append(opts, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+			}
+			for _, opt := range opts {
+				signature, err := signer.Sign(rand.Reader, digest[:], opt)
+				if err != nil {
+					t.Fatalf("sign with %T failed: %v", opt, err)
+				}
+				verifyNSSSignature(t, want.PublicKey, digest[:], signature, opt)
+			}
+		})
+	}
+}
+
+func verifyNSSSignature(t *testing.T, pub crypto.PublicKey, digest, signature []byte, opts crypto.SignerOpts) {
+	t.Helper()
+
+	var err error
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			t.Fatalf("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			err = rsa.VerifyPSS(pub, crypto.SHA256, digest, signature, pss)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature)
+		}
+	}
+	if err != nil {
+		t.Fatalf("invalid %T signature: %v", opts, err)
+	}
+}
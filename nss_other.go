@@ -0,0 +1,13 @@
+//go:build !linux || !cgo || !nss
+
+package certstore
+
+import "errors"
+
+// errNSSUnsupported is returned when opening an NSS database in a build
+// without NSS support.
+var errNSSUnsupported = errors.New("NSS databases are only supported on Linux builds with cgo and the 'nss' build tag")
+
+func openNSSStore(string, bool) (Store, error) {
+	return nil, errNSSUnsupported
+}
@@ -0,0 +1,233 @@
+package certstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspFetchTimeout bounds a single OCSP request to the responder.
+	ocspFetchTimeout = 10 * time.Second
+
+	// ocspRetryInterval is how long to wait before retrying a failed fetch.
+	ocspRetryInterval = time.Minute
+
+	// ocspDefaultRefresh is used when a response does not set NextUpdate.
+	ocspDefaultRefresh = time.Hour
+)
+
+// ocspHTTPClient is the client used to query OCSP responders.
+var ocspHTTPClient = &http.Client{Timeout: ocspFetchTimeout}
+
+// ocspState records the outcome of the most recent OCSP fetch.
+type ocspState struct {
+	status     int
+	thisUpdate time.Time
+	nextUpdate time.Time
+	err        error
+}
+
+// fetchOCSPResponse queries the leaf's OCSP responder and returns the raw
+// response together with its parsed form.
+func fetchOCSPResponse(ctx context.Context, leaf, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("certificate has no OCSP responder")
+	}
+
+	reqBytes, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create OCSP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("build OCSP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	resp, err := ocspHTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query OCSP responder %s: %w", leaf.OCSPServer[0], err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s returned HTTP %d", leaf.OCSPServer[0], resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read OCSP response: %w", err)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse OCSP response: %w", err)
+	}
+
+	return raw, parsed, nil
+}
+
+// certificateIssuer returns the issuer of the leaf from the presented chain,
+// or from the chain stored with the identity when only the leaf is presented
+// because its issuing root was stripped.
+func certificateIssuer(leaf *x509.Certificate, chain [][]byte, identity Identity) (*x509.Certificate, error) {
+	if len(chain) >= 2 {
+		issuer, err := x509.ParseCertificate(chain[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse issuer certificate: %w", err)
+		}
+		return issuer, nil
+	}
+	if identity != nil {
+		stored, err := identity.CertificateChain()
+		if err == nil {
+			for _, cert := range stored {
+				if !cert.Equal(leaf) && issuesAny(cert, []*x509.Certificate{leaf}) {
+					return cert, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("certificate chain does not include the issuer")
+}
+
+// ocspStatusString returns a readable OCSP certificate status.
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// maintainOCSP keeps the OCSP staple of the cached certificate fresh, fetching
+// a new response halfway through the validity window of the current one. It
+// returns when the cache entry is closed.
+func (cached *cachedCert) maintainOCSP() {
+	for {
+		timer := time.NewTimer(cached.nextOCSPFetch())
+		select {
+		case <-cached.done:
+			timer.Stop()
+			return
+		case <-cached.ocspRefresh:
+			timer.Stop()
+		case <-timer.C:
+		}
+		cached.updateOCSP()
+	}
+}
+
+// nextOCSPFetch returns how long to wait before fetching a new OCSP response.
+func (cached *cachedCert) nextOCSPFetch() time.Duration {
+	cached.mu.RLock()
+	state := cached.ocsp
+	cached.mu.RUnlock()
+
+	switch {
+	case state.err != nil:
+		return ocspRetryInterval
+	case state.nextUpdate.IsZero():
+		return ocspDefaultRefresh
+	default:
+		refreshAt := state.thisUpdate.Add(state.nextUpdate.Sub(state.thisUpdate) / 2)
+		return max(time.Until(refreshAt), 0)
+	}
+}
+
+// updateOCSP fetches a fresh OCSP response for the current leaf and staples it
+// to the cached certificate. Failures are recorded and logged, but the
+// previous staple is kept until it expires.
+func (cached *cachedCert) updateOCSP() {
+	cached.mu.RLock()
+	leaf := cached.cert.Leaf
+	chain := cached.cert.Certificate
+	identity := cached.identity
+	cached.mu.RUnlock()
+
+	raw, resp, err := cached.requestOCSP(leaf, chain, identity)
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.cert.Leaf != leaf {
+		// The certificate was swapped while fetching; the refresh that
+		// swapped it schedules a fetch for the new leaf.
+		return
+	}
+
+	logger := cached.selector.logger
+	if err != nil {
+		cached.ocsp.err = err
+		if !cached.ocsp.nextUpdate.IsZero() && time.Now().After(cached.ocsp.nextUpdate) {
+			cached.cert.OCSPStaple = nil
+		}
+		if logger != nil {
+			logger.Warn("fetching OCSP response for client certificate",
+				zap.String("serial_number", certificateSerial(cached.cert)),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	cached.cert.OCSPStaple = raw
+	cached.ocsp = ocspState{
+		status:     resp.Status,
+		thisUpdate: resp.ThisUpdate,
+		nextUpdate: resp.NextUpdate,
+	}
+
+	if logger == nil {
+		return
+	}
+	if resp.Status == ocsp.Revoked {
+		logger.Error("client certificate has been revoked",
+			zap.String("serial_number", certificateSerial(cached.cert)),
+			zap.Time("revoked_at", resp.RevokedAt),
+		)
+		return
+	}
+	logger.Debug("updated OCSP staple for client certificate",
+		zap.String("serial_number", certificateSerial(cached.cert)),
+		zap.String("ocsp_status", ocspStatusString(resp.Status)),
+		zap.Time("next_update", resp.NextUpdate),
+	)
+}
+
+// requestOCSP fetches an OCSP response for leaf using the issuer from chain
+// or the identity's stored chain.
+func (cached *cachedCert) requestOCSP(leaf *x509.Certificate, chain [][]byte, identity Identity) ([]byte, *ocsp.Response, error) {
+	issuer, err := certificateIssuer(leaf, chain, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ocspFetchTimeout)
+	defer cancel()
+
+	return fetchOCSPResponse(ctx, leaf, issuer)
+}
+
+// scheduleOCSPUpdate asks the OCSP maintenance loop to fetch immediately.
+func (cached *cachedCert) scheduleOCSPUpdate() {
+	if cached.ocspRefresh == nil {
+		return
+	}
+	select {
+	case cached.ocspRefresh <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,126 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func newTestOCSPResponder(t *testing.T, ca *testCA, status int) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCachedCertificate_FetchOCSP(t *testing.T) {
+	tests := []struct {
+		name       string
+		responder  func(*testing.T, *testCA) string
+		wantStaple bool
+		wantStatus string
+	}{
+		{
+			name: "good response is stapled",
+			responder: func(t *testing.T, ca *testCA) string {
+				return newTestOCSPResponder(t, ca, ocsp.Good).URL
+			},
+			wantStaple: true,
+			wantStatus: "good",
+		},
+		{
+			name: "revoked response is reported",
+			responder: func(t *testing.T, ca *testCA) string {
+				return newTestOCSPResponder(t, ca, ocsp.Revoked).URL
+			},
+			wantStaple: true,
+			wantStatus: "revoked",
+		},
+		{
+			name: "responder failure does not fail loading",
+			responder: func(t *testing.T, _ *testCA) string {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				}))
+				t.Cleanup(server.Close)
+				return server.URL
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			ca := newTestCA(t, "OCSP Test CA")
+			key := newTestKey(t)
+			leaf := ca.issue(t, &x509.Certificate{
+				Subject:    pkix.Name{CommonName: "ocsp.example.test"},
+				OCSPServer: []string{tt.responder(t, ca)},
+			}, key.Public())
+			withFakeStoreLoads(t, newFakeStoreLoadWithChain([]*x509.Certificate{leaf, ca.cert}, newFakeSigner(key.Public(), []byte("ok"))))
+
+			selector := newTestSelector("^ocsp\\.example\\.test$")
+			selector.FetchOCSP = true
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+
+			if hasStaple := len(cert.OCSPStaple) > 0; hasStaple != tt.wantStaple {
+				t.Fatalf("expected staple=%t, got %t", tt.wantStaple, hasStaple)
+			}
+
+			infos := defaultApp.cache.info()
+			if len(infos) != 1 {
+				t.Fatalf("expected 1 cache entry, got %d", len(infos))
+			}
+			if infos[0].OCSPStatus != tt.wantStatus {
+				t.Fatalf("expected OCSP status %q, got %q", tt.wantStatus, infos[0].OCSPStatus)
+			}
+			if !tt.wantStaple && infos[0].OCSPError == "" {
+				t.Fatal("expected OCSP error to be reported")
+			}
+		})
+	}
+}
+
+func TestCertificateIssuer_RequiresChain(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "no-chain.example.test", key)
+
+	_, err := certificateIssuer(cert, [][]byte{cert.Raw}, newFakeIdentity(cert, key))
+	assertErrorContains(t, err, "does not include the issuer")
+}
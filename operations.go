@@ -1,58 +1,326 @@
 package certstore
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
+	"errors"
 	"fmt"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
-	"github.com/tailscale/certstore"
+	"go.uber.org/zap"
 )
 
-var openCertStore = certstore.Open
+var (
+	// errNoMatchingIdentity is returned when no identity in the store matches.
+	errNoMatchingIdentity = errors.New("no identity found")
 
-// getStoreLocation converts a string location to certstore.StoreLocation.
-func getStoreLocation(location string) certstore.StoreLocation {
+	// errEnumerationBudgetExceeded is returned when matching stops early
+	// because max_candidates or max_enumeration_time was reached.
+	errEnumerationBudgetExceeded = errors.New("enumeration budget exceeded")
+)
+
+// storeLocations returns the stores to enumerate for a normalized location.
+// On macOS the keychain search list already spans the user and System
+// keychains, so "any" opens a single store.
+func storeLocations(location string) []StoreLocation {
+	if location != "any" {
+		return []StoreLocation{getStoreLocation(location)}
+	}
+	if runtime.GOOS == "darwin" {
+		return []StoreLocation{LocationUser}
+	}
+	return []StoreLocation{LocationUser, LocationSystem}
+}
+
+// getStoreLocation converts a string location to StoreLocation.
+func getStoreLocation(location string) StoreLocation {
 	switch strings.ToLower(location) {
-	case "system":
-		return certstore.System
 	case "user":
-		return certstore.User
+		return LocationUser
+	case "nssdb":
+		return LocationNSS
+	case "piv":
+		return LocationPIV
+	case "directory":
+		return LocationDirectory
 	default:
-		return certstore.System
+		return LocationSystem
 	}
 }
 
+// enumerationBudget bounds how much of a store is examined while matching.
+// A zero value places no bounds on the enumeration.
+type enumerationBudget struct {
+	maxCandidates int
+	deadline      time.Time
+}
+
+// check returns an error when examining another candidate would exceed the
+// budget, given the number of candidates already examined.
+func (b enumerationBudget) check(examined int) error {
+	if b.maxCandidates > 0 && examined >= b.maxCandidates {
+		return fmt.Errorf("%w: stopped after examining %d candidates (max_candidates)", errEnumerationBudgetExceeded, examined)
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return fmt.Errorf("%w: max_enumeration_time elapsed after examining %d candidates", errEnumerationBudgetExceeded, examined)
+	}
+	return nil
+}
+
+// matchCriteria describes which identities a selector accepts and how it
+// breaks ties between several accepted identities.
+type matchCriteria struct {
+	pattern *regexp.Regexp
+	field   string
+
+	// allOf and anyOf are the composite criteria: a match must match all
+	// of allOf and, if set, any of anyOf.
+	allOf, anyOf []fieldMatcher
+
+	// template matches the certificate template a match was issued from.
+	template templateMatcher
+
+	// thumbprint, when set, is the SHA-1 or SHA-256 digest a match's
+	// certificate must have.
+	thumbprint []byte
+
+	// extKeyUsages are the Enhanced Key Usages a match must be valid for.
+	extKeyUsages []asn1.ObjectIdentifier
+
+	// ekuChaining rejects matches whose issuing CAs do not permit the
+	// leaf's Enhanced Key Usages.
+	ekuChaining bool
+
+	// keyType, when set, is the type a match's key must have, and
+	// minKeyBits the shortest RSA key accepted.
+	keyType    string
+	minKeyBits int
+
+	// keyProvider and keyContainer, when set, name where a match's private
+	// key must be kept.
+	keyProvider  string
+	keyContainer string
+
+	// selection is the policy choosing between matches of the same rank.
+	selection string
+
+	// preferHardware chooses a match whose private key is hardware-backed
+	// over other matches, such as a software copy of the same certificate.
+	preferHardware bool
+
+	// validAt, when set, chooses a match whose certificate is valid at that
+	// time over expired or not yet valid matches. A certificate whose
+	// NotBefore is at most notBeforeSkew later still counts as valid.
+	validAt       time.Time
+	notBeforeSkew time.Duration
+
+	// rollover, when set, ranks valid matches expiring within that long of
+	// validAt below valid matches that are not due for renewal yet.
+	rollover time.Duration
+
+	// acceptableCAs, when set, are the distinguished names of the CAs one of
+	// which must issue a certificate of a match's chain.
+	acceptableCAs [][]byte
+
+	// logger, when set, logs why matching identities are skipped.
+	logger *zap.Logger
+}
+
+// matches reports whether the identity's certificate field matches the
+// pattern, the composite criteria and the template, and the certificate has
+// the pinned thumbprint, an acceptable key kept in the required provider and
+// container, the required key usages and a chain issued by an acceptable CA.
+func (m matchCriteria) matches(identity Identity) bool {
+	certInfo, err := identity.Certificate()
+	if err != nil {
+		return false
+	}
+	return fieldMatches(m.field, m.pattern, certInfo) &&
+		fieldsMatch(certInfo, m.allOf, m.anyOf) &&
+		m.template.matches(certInfo) &&
+		thumbprintMatches(certInfo, m.thumbprint) &&
+		keyAcceptable(certInfo, m.keyType, m.minKeyBits) &&
+		keyStoredIn(identity, m.keyProvider, m.keyContainer) &&
+		hasExtKeyUsages(certInfo, m.extKeyUsages) &&
+		m.usageChains(identity) &&
+		issuedByAcceptableCA(identity, m.acceptableCAs)
+}
+
+// selectable reports whether the identity matches and the process can use
+// its private key. A key whose ACL denies access or whose key container is
+// missing would otherwise only fail at the first handshake, so such an
+// identity is skipped in favor of the next match.
+func (m matchCriteria) selectable(identity Identity) bool {
+	if !m.matches(identity) {
+		return false
+	}
+	if _, err := identity.Signer(); err != nil {
+		if m.logger != nil {
+			certInfo, _ := identity.Certificate()
+			m.logger.Warn("skipping matching identity whose private key is not accessible",
+				zap.String("common_name", certInfo.Subject.CommonName),
+				zap.String("serial_number", certInfo.SerialNumber.String()),
+				zap.Error(err),
+			)
+		}
+		return false
+	}
+	return true
+}
+
+// usageChains reports whether the identity's issuing CAs permit its key
+// usages, when ekuChaining is set.
+func (m matchCriteria) usageChains(identity Identity) bool {
+	if !m.ekuChaining {
+		return true
+	}
+	chain, err := identity.CertificateChain()
+	return err == nil && issuersPermitExtKeyUsages(chain)
+}
+
+// rank scores a matching identity. A valid certificate outranks an invalid
+// one, a valid one not due for rollover outranks one that is, and with
+// preferHardware a hardware-backed key outranks others of the same validity.
+func (m matchCriteria) rank(identity Identity) int {
+	rank := 0
+	if m.valid(identity) {
+		rank += 4
+		if !m.dueForRollover(identity) {
+			rank += 2
+		}
+	}
+	if !m.preferHardware || keyHardwareBacked(identity) {
+		rank++
+	}
+	return rank
+}
+
+// valid reports whether the identity's certificate is valid at validAt,
+// tolerating notBeforeSkew of clock skew for freshly issued certificates.
+func (m matchCriteria) valid(identity Identity) bool {
+	if m.validAt.IsZero() {
+		return true
+	}
+	certInfo, err := identity.Certificate()
+	if err != nil {
+		return false
+	}
+	return !m.validAt.Add(m.notBeforeSkew).Before(certInfo.NotBefore) && !m.validAt.After(certInfo.NotAfter)
+}
+
+// dueForRollover reports whether the identity's certificate expires within
+// the rollover window of validAt.
+func (m matchCriteria) dueForRollover(identity Identity) bool {
+	if m.rollover <= 0 || m.validAt.IsZero() {
+		return false
+	}
+	certInfo, err := identity.Certificate()
+	return err == nil && certInfo.NotAfter.Before(m.validAt.Add(m.rollover))
+}
+
 // findMatchingIdentity searches for an identity using regex pattern matching.
-// It closes all non-matching identities and returns the first match, or an error if not found.
-func findMatchingIdentity(identities []certstore.Identity, pattern *regexp.Regexp, field string) (match certstore.Identity, err error) {
-	if pattern == nil {
+// It returns the match of the highest rank, breaking ties by the highest
+// serial number and then the highest leaf thumbprint, so repeated provisions
+// select the same certificate whatever the enumeration order. Identical
+// certificates keep the first match. All other identities are closed. When
+// the enumeration budget runs out, the best match so far is returned. An
+// error is returned if none matches within the budget or ctx is done.
+func findMatchingIdentity(ctx context.Context, identities []Identity, criteria matchCriteria, budget enumerationBudget) (Identity, error) {
+	if criteria.pattern == nil {
+		closeIdentities(identities)
 		return nil, fmt.Errorf("pattern is required")
 	}
 
-	selector := getFieldSelector(field)
-	for _, tmpID := range identities {
-		certInfo, err := tmpID.Certificate()
-		if err != nil {
-			tmpID.Close()
-			continue
+	var best Identity
+	bestScore := -1
+	for i, candidate := range identities {
+		if err := ctx.Err(); err != nil {
+			closeIdentities(identities[i:])
+			closeIdentity(best)
+			return nil, err
+		}
+		if err := budget.check(i); err != nil {
+			closeIdentities(identities[i:])
+			if best != nil {
+				criteria.warnPartialMatch(best, err)
+				return best, nil
+			}
+			return nil, err
 		}
 
-		fieldValue := selector(certInfo)
-		if pattern.MatchString(fieldValue) {
-			match = tmpID
-			break
+		if !criteria.selectable(candidate) {
+			candidate.Close()
+			continue
 		}
+		score := criteria.rank(candidate)
+		if score < bestScore || score == bestScore && !criteria.outranksOnTie(candidate, best) {
+			candidate.Close()
+			continue
+		}
+		closeIdentity(best)
+		best, bestScore = candidate, score
+	}
+
+	if best != nil {
+		return best, nil
+	}
+	return nil, fmt.Errorf("%w matching pattern '%s' in field '%s'", errNoMatchingIdentity, criteria.pattern.String(), criteria.field)
+}
 
-		tmpID.Close()
+// warnPartialMatch logs that the enumeration budget ran out with err before
+// every identity was examined, so an unexamined identity may outrank best.
+func (m matchCriteria) warnPartialMatch(best Identity, err error) {
+	if m.logger == nil {
+		return
+	}
+	certInfo, _ := best.Certificate()
+	m.logger.Warn("enumeration budget exhausted, using the best match among the identities examined",
+		zap.String("common_name", certInfo.Subject.CommonName),
+		zap.String("serial_number", certInfo.SerialNumber.String()),
+		zap.Error(err),
+	)
+}
+
+// outranksOnTie reports whether candidate is chosen over best, of the same
+// rank, because the selection policy prefers its certificate or, failing
+// that, its certificate has a higher serial number or, with equal serial
+// numbers, a higher leaf thumbprint.
+func (m matchCriteria) outranksOnTie(candidate, best Identity) bool {
+	candidateCert, err := candidate.Certificate()
+	if err != nil {
+		return false
 	}
+	bestCert, err := best.Certificate()
+	if err != nil {
+		return true
+	}
+	if c := compareBySelection(m.selection, candidateCert, bestCert); c != 0 {
+		return c > 0
+	}
+	if c := candidateCert.SerialNumber.Cmp(bestCert.SerialNumber); c != 0 {
+		return c > 0
+	}
+	return makeLeafThumbprint(candidateCert) > makeLeafThumbprint(bestCert)
+}
 
-	if match == nil {
-		err = fmt.Errorf("no identity found matching pattern '%s' in field '%s'", pattern.String(), field)
+// closeIdentities releases identities that will not be used.
+func closeIdentities(identities []Identity) {
+	for _, identity := range identities {
+		identity.Close()
 	}
+}
 
-	return match, err
+// closeIdentity releases an identity that will not be used, such as a match
+// outranked by a later one. A nil identity is ignored.
+func closeIdentity(identity Identity) {
+	if identity != nil {
+		identity.Close()
+	}
 }
 
 // getFieldSelector returns a function that extracts the specified field from a certificate.
@@ -60,6 +328,8 @@ func getFieldSelector(field string) func(*x509.Certificate) string {
 	switch field {
 	case "issuer":
 		return func(cert *x509.Certificate) string { return cert.Issuer.CommonName }
+	case "issuer_dn":
+		return func(cert *x509.Certificate) string { return cert.Issuer.String() }
 	case "serial":
 		return func(cert *x509.Certificate) string { return cert.SerialNumber.String() }
 	case "dns_names":
@@ -74,14 +344,36 @@ func getFieldSelector(field string) func(*x509.Certificate) string {
 	}
 }
 
-// buildTLSCertificate constructs a tls.Certificate from a certstore.Identity.
-func buildTLSCertificate(identity certstore.Identity) (tls.Certificate, error) {
+// errPINUnsupported is returned when a pin is set for an identity whose
+// store has no use for one.
+var errPINUnsupported = errors.New("pin is set but the identity's store does not accept a PIN")
+
+// unlockIdentity hands the configured pin, if any, to the identity, or to
+// the smart card holding the key of a Windows identity.
+func unlockIdentity(identity Identity, pin string) error {
+	if pin == "" {
+		return nil
+	}
+	if unlocker, ok := identity.(PINIdentity); ok {
+		return unlocker.SetPIN(pin)
+	}
+	return setPlatformPIN(identity, pin)
+}
+
+// buildTLSCertificate constructs a tls.Certificate from a Identity, with its
+// chain normalized and, if verifyLinkage is set, checked.
+func buildTLSCertificate(identity Identity, verifyLinkage bool) (tls.Certificate, error) {
 	var cert tls.Certificate
 
+	leaf, err := identity.Certificate()
+	if err != nil {
+		return cert, err
+	}
 	certChain, err := identity.CertificateChain()
 	if err != nil {
 		return cert, err
 	}
+	certChain = normalizeChain(leaf, certChain, verifyLinkage)
 
 	signer, err := identity.Signer()
 	if err != nil {
@@ -89,7 +381,7 @@ func buildTLSCertificate(identity certstore.Identity) (tls.Certificate, error) {
 	}
 
 	cert = tls.Certificate{
-		Leaf:        certChain[0],
+		Leaf:        leaf,
 		Certificate: serializeCertificateChain(certChain),
 		PrivateKey:  signer,
 	}
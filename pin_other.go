@@ -0,0 +1,7 @@
+//go:build !windows
+
+package certstore
+
+func setPlatformPIN(Identity, string) error {
+	return errPINUnsupported
+}
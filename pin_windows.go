@@ -0,0 +1,82 @@
+package certstore
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// CryptoAPI key specs and the provider parameters setting their PIN.
+const (
+	atKeyExchange     = 1
+	ppKeyExchangePIN  = 32
+	ppSignaturePIN    = 33
+	ncryptPINProperty = "SmartCardPin"
+)
+
+var (
+	procNCryptSetProperty = modncrypt.NewProc("NCryptSetProperty")
+	procCryptSetProvParam = modadvapi32.NewProc("CryptSetProvParam")
+)
+
+// setPlatformPIN sets the PIN of the smart card holding the private key of
+// a tailscale/certstore identity on its key handle, NCRYPT_PIN_PROPERTY for
+// CNG keys and PP_SIGNATURE_PIN or PP_KEYEXCHANGE_PIN for CryptoAPI ones,
+// so that signing does not show the PIN dialog.
+func setPlatformPIN(identity Identity, pin string) error {
+	if _, err := identity.Signer(); err != nil {
+		return err
+	}
+	signer, ok := identityHandle(identity, "signer")
+	if !ok || signer.Kind() != reflect.Pointer || signer.IsNil() {
+		return errPINUnsupported
+	}
+	key := signer.Elem()
+	if cng := key.FieldByName("cngHandle"); cng.IsValid() && cng.Uint() != 0 {
+		return setCNGPIN(uintptr(cng.Uint()), pin)
+	}
+	prov, keySpec := key.FieldByName("capiProv"), key.FieldByName("keySpec")
+	if !prov.IsValid() || prov.Uint() == 0 || !keySpec.IsValid() {
+		return errPINUnsupported
+	}
+	return setCAPIPIN(uintptr(prov.Uint()), uint32(keySpec.Uint()), pin)
+}
+
+func setCNGPIN(key uintptr, pin string) error {
+	value, err := windows.UTF16FromString(pin)
+	if err != nil {
+		return err
+	}
+	property, err := windows.UTF16PtrFromString(ncryptPINProperty)
+	if err != nil {
+		return err
+	}
+	status, _, _ := procNCryptSetProperty.Call(
+		key,
+		uintptr(unsafe.Pointer(property)),
+		uintptr(unsafe.Pointer(&value[0])),
+		uintptr(len(value)*2),
+		0,
+	)
+	if status != 0 {
+		return fmt.Errorf("setting the smart card PIN: %w", windows.Errno(status))
+	}
+	return nil
+}
+
+func setCAPIPIN(prov uintptr, keySpec uint32, pin string) error {
+	value, err := windows.BytePtrFromString(pin)
+	if err != nil {
+		return err
+	}
+	param := uintptr(ppSignaturePIN)
+	if keySpec == atKeyExchange {
+		param = ppKeyExchangePIN
+	}
+	if ok, _, err := procCryptSetProvParam.Call(prov, param, uintptr(unsafe.Pointer(value)), 0); ok == 0 {
+		return fmt.Errorf("setting the smart card PIN: %w", err)
+	}
+	return nil
+}
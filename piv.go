@@ -0,0 +1,25 @@
+package certstore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pivSlots are the PIV slots holding identities, named as ykman names them:
+// authentication, signature, key management and card authentication.
+var pivSlots = []string{"9a", "9c", "9d", "9e"}
+
+// validatePIVSlot checks the piv_slot setting.
+func (cs *CertSelector) validatePIVSlot() error {
+	if cs.PIVSlot == "" {
+		return nil
+	}
+	if normalizeStoreLocation(cs.Location) != "piv" {
+		return fmt.Errorf("piv_slot requires location 'piv'")
+	}
+	if !slices.Contains(pivSlots, strings.ToLower(cs.PIVSlot)) {
+		return fmt.Errorf("unsupported piv_slot '%s': must be one of %s", cs.PIVSlot, strings.Join(pivSlots, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !piv
+
+package certstore
+
+import "errors"
+
+// errPIVUnsupported is returned when opening PIV tokens in a build without
+// PIV support.
+var errPIVUnsupported = errors.New("PIV tokens are only supported in builds with the 'piv' build tag")
+
+func openPIVStore(string) (Store, error) {
+	return nil, errPIVUnsupported
+}
@@ -0,0 +1,66 @@
+package certstore
+
+import "testing"
+
+// pinIdentity is a fake identity of a token accepting a PIN.
+type pinIdentity struct {
+	*fakeIdentity
+	pin string
+}
+
+func (i *pinIdentity) SetPIN(pin string) error {
+	i.pin = pin
+	return nil
+}
+
+func TestCertSelector_PIN(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	load := newFakeStoreLoad(newTestCertificate(t, "piv.example.test", key), newFakeSigner(key.Public(), []byte("ok")))
+	identity := &pinIdentity{fakeIdentity: load.identity}
+	load.store.identities = []Identity{identity}
+	backend := &namedStoreBackend{load: load}
+	t.Cleanup(SetBackend(backend))
+
+	selector := newTestSelector("^piv\\.example\\.test$")
+	selector.Location, selector.PIVSlot, selector.PIN = "piv", "9A", "123456"
+	if err := selector.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if len(backend.names) != 1 || backend.names[0] != `piv\9a` {
+		t.Fatalf("expected PIV slot 9a to be opened, got %v", backend.names)
+	}
+	if identity.pin != "123456" {
+		t.Fatalf("expected the PIN to be handed to the identity, got %q", identity.pin)
+	}
+}
+
+func TestCertSelector_PINUnsupported(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	withFakeStoreLoads(t, newFakeStoreLoad(newTestCertificate(t, "piv.example.test", key), newFakeSigner(key.Public(), []byte("ok"))))
+
+	selector := newTestSelector("^piv\\.example\\.test$")
+	selector.PIN = "123456"
+	_, err := selector.loadCertificate(t.Context())
+	assertErrorContains(t, err, "does not accept a PIN")
+}
+
+func TestCertSelector_PIVSlotValidation(t *testing.T) {
+	selector := newTestSelector("^piv\\.example\\.test$")
+	selector.PIVSlot = "9a"
+	assertErrorContains(t, selector.validate(), "piv_slot requires location 'piv'")
+
+	selector.Location, selector.PIVSlot = "piv", "82"
+	assertErrorContains(t, selector.validate(), "unsupported piv_slot '82'")
+
+	selector.PIVSlot, selector.StoreName = "", "WebHosting"
+	assertErrorContains(t, selector.validate(), "cannot be used with location 'piv'")
+}
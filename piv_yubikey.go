@@ -0,0 +1,159 @@
+//go:build piv
+
+package certstore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/go-piv/piv-go/piv"
+)
+
+// pivSlotsByName are the piv-go slots of pivSlots.
+var pivSlotsByName = map[string]piv.Slot{
+	"9a": piv.SlotAuthentication,
+	"9c": piv.SlotSignature,
+	"9d": piv.SlotKeyManagement,
+	"9e": piv.SlotCardAuthentication,
+}
+
+// openPIVStore opens the PIV application of every YubiKey connected. When
+// slot is set, only the identity in that slot is listed.
+func openPIVStore(slot string) (Store, error) {
+	store := &pivStore{}
+	if slot == "" {
+		for _, name := range pivSlots {
+			store.slots = append(store.slots, pivSlotsByName[name])
+		}
+	} else {
+		s, ok := pivSlotsByName[strings.ToLower(slot)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported PIV slot '%s'", slot)
+		}
+		store.slots = []piv.Slot{s}
+	}
+
+	cards, err := piv.Cards()
+	if err != nil {
+		return nil, fmt.Errorf("listing smart cards: %w", err)
+	}
+	for _, card := range cards {
+		if !strings.Contains(strings.ToLower(card), "yubikey") {
+			continue
+		}
+		yk, err := piv.Open(card)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("opening %s: %w", card, err)
+		}
+		store.tokens = append(store.tokens, &pivToken{yk: yk})
+	}
+	return store, nil
+}
+
+// pivStore is the PIV application of the YubiKeys connected.
+type pivStore struct {
+	tokens []*pivToken
+	slots  []piv.Slot
+}
+
+// pivToken is an opened YubiKey. piv-go runs one transaction at a time on
+// it, so its identities serialize their calls with mu.
+type pivToken struct {
+	mu sync.Mutex
+	yk *piv.YubiKey
+}
+
+func (s *pivStore) Identities() ([]Identity, error) {
+	identities := []Identity{}
+	for _, token := range s.tokens {
+		for _, slot := range s.slots {
+			token.mu.Lock()
+			cert, err := token.yk.Certificate(slot)
+			token.mu.Unlock()
+			if errors.Is(err, piv.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading PIV slot %s: %w", slot, err)
+			}
+			identities = append(identities, &pivIdentity{token: token, slot: slot, cert: cert})
+		}
+	}
+	return identities, nil
+}
+
+func (s *pivStore) Close() {
+	for _, token := range s.tokens {
+		token.yk.Close()
+	}
+	s.tokens = nil
+}
+
+// pivIdentity is the certificate of a PIV slot and the key next to it.
+type pivIdentity struct {
+	token *pivToken
+	slot  piv.Slot
+	cert  *x509.Certificate
+	pin   string
+}
+
+func (i *pivIdentity) Certificate() (*x509.Certificate, error) {
+	return i.cert, nil
+}
+
+// CertificateChain returns the leaf alone: PIV slots hold no intermediates.
+func (i *pivIdentity) CertificateChain() ([]*x509.Certificate, error) {
+	return []*x509.Certificate{i.cert}, nil
+}
+
+// SetPIN sets the PIN the key is unlocked with when its PIN policy asks for
+// one.
+func (i *pivIdentity) SetPIN(pin string) error {
+	i.pin = pin
+	return nil
+}
+
+func (i *pivIdentity) Signer() (crypto.Signer, error) {
+	i.token.mu.Lock()
+	defer i.token.mu.Unlock()
+
+	key, err := i.token.yk.PrivateKey(i.slot, i.cert.PublicKey, piv.KeyAuth{PIN: i.pin})
+	if err != nil {
+		return nil, fmt.Errorf("opening the key of PIV slot %s: %w", i.slot, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key of PIV slot %s cannot sign", i.slot)
+	}
+	return pivSigner{token: i.token, signer: signer}, nil
+}
+
+func (*pivIdentity) Close() {}
+
+// pivSigner serializes the signatures of a token.
+type pivSigner struct {
+	token  *pivToken
+	signer crypto.Signer
+}
+
+func (s pivSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s pivSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.token.mu.Lock()
+	defer s.token.mu.Unlock()
+	return s.signer.Sign(rand, digest, opts)
+}
+
+// Interface guards
+var (
+	_ PINIdentity   = (*pivIdentity)(nil)
+	_ crypto.Signer = pivSigner{}
+)
@@ -0,0 +1,120 @@
+package certstore
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(Placeholders{})
+	httpcaddyfile.RegisterHandlerDirective("certstore_placeholders", parsePlaceholdersCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("certstore_placeholders", httpcaddyfile.Before, "header")
+}
+
+// selectorPlaceholderPrefix starts the placeholders describing the
+// certificate of a named selector, such as
+// {certstore.selectors.banking.subject}.
+const selectorPlaceholderPrefix = "certstore.selectors."
+
+// Placeholders is an HTTP handler that adds placeholders describing the
+// certificate each named selector of the certstore app is using, so that the
+// templates handler and header directives can render which store identity
+// each proxy presents:
+//
+//	{certstore.selectors.<name>.subject}
+//	{certstore.selectors.<name>.thumbprint}
+//	{certstore.selectors.<name>.not_after}
+//	{certstore.selectors.<name>.days_to_expiry}
+type Placeholders struct {
+	app *App
+}
+
+// CaddyModule returns the Caddy module information.
+func (Placeholders) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.certstore_placeholders",
+		New: func() caddy.Module { return new(Placeholders) },
+	}
+}
+
+// Provision looks up the certstore app whose selectors are described.
+func (p *Placeholders) Provision(ctx caddy.Context) error {
+	app, err := loadApp(ctx)
+	if err != nil {
+		return err
+	}
+	p.app = app
+	return nil
+}
+
+// ServeHTTP adds the selector placeholders to the request's replacer.
+func (p Placeholders) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	repl.Map(p.app.replaceSelectorPlaceholder)
+	return next.ServeHTTP(w, r)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	certstore_placeholders
+func (p *Placeholders) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+func parsePlaceholdersCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := new(Placeholders)
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
+}
+
+// replaceSelectorPlaceholder resolves the placeholders of named selectors,
+// the selector name being everything between the prefix and the last dot.
+func (a *App) replaceSelectorPlaceholder(key string) (any, bool) {
+	rest, ok := strings.CutPrefix(key, selectorPlaceholderPrefix)
+	if !ok {
+		return nil, false
+	}
+	dot := strings.LastIndexByte(rest, '.')
+	if dot < 0 {
+		return nil, false
+	}
+	selector, err := a.selector(rest[:dot])
+	if err != nil {
+		return nil, false
+	}
+	cert, err := selector.currentCertificate()
+	if err != nil || cert.Leaf == nil {
+		return nil, false
+	}
+
+	leaf := cert.Leaf
+	switch rest[dot+1:] {
+	case "subject":
+		return leaf.Subject.CommonName, true
+	case "thumbprint":
+		return makeLeafThumbprint(leaf), true
+	case "not_after":
+		return leaf.NotAfter.UTC().Format(time.RFC3339), true
+	case "days_to_expiry":
+		return int(time.Until(leaf.NotAfter).Hours() / 24), true
+	default:
+		return nil, false
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Placeholders)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Placeholders)(nil)
+	_ caddyfile.Unmarshaler       = (*Placeholders)(nil)
+)
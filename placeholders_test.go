@@ -0,0 +1,52 @@
+package certstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestPlaceholders(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "status.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"partner.api": {Pattern: "^status\\.example\\.test$", Location: "user"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer app.Cleanup()
+
+	repl := caddy.NewReplacer()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+	handler := Placeholders{app: app}
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+	if err := handler.ServeHTTP(httptest.NewRecorder(), req, next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"{certstore.selectors.partner.api.subject}":        "status.example.test",
+		"{certstore.selectors.partner.api.thumbprint}":     makeLeafThumbprint(cert),
+		"{certstore.selectors.partner.api.days_to_expiry}": strconv.Itoa(0),
+		"{certstore.selectors.missing.subject}":            "unknown",
+		"{certstore.selectors.partner.api.color}":          "unknown",
+	}
+	for input, want := range expected {
+		if got := repl.ReplaceAll(input, "unknown"); got != want {
+			t.Errorf("%s: expected %q, got %q", input, want, got)
+		}
+	}
+}
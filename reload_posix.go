@@ -0,0 +1,44 @@
+//go:build !windows
+
+package certstore
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// reloadSignals are the signals that may trigger re-selection. SIGUSR1 is
+// reserved by Caddy for config reloads, so it cannot be used.
+var reloadSignals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// watchReloadSignal calls reload each time the named signal is received,
+// until stop is closed.
+func watchReloadSignal(name string, reload func(), stop <-chan struct{}) error {
+	sig, ok := reloadSignals[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("unsupported reload signal '%s': must be SIGHUP or SIGUSR2", name)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-signals:
+				reload()
+			}
+		}
+	}()
+
+	return nil
+}
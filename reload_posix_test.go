@@ -0,0 +1,32 @@
+//go:build !windows
+
+package certstore
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchReloadSignal(t *testing.T) {
+	if err := watchReloadSignal("SIGUSR1", func() {}, make(chan struct{})); err == nil {
+		t.Fatal("expected SIGUSR1 to be rejected")
+	}
+
+	reloads := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if err := watchReloadSignal("sigusr2", func() { reloads <- struct{}{} }, stop); err != nil {
+		t.Fatalf("watchReloadSignal failed: %v", err)
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatalf("send signal: %v", err)
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload was not triggered by signal")
+	}
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package certstore
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// watchReloadSignal calls reload each time the named Windows event is set,
+// until stop is closed. Scripts signal the event, e.g. with
+// [System.Threading.EventWaitHandle]::OpenExisting(name).Set() in PowerShell.
+func watchReloadSignal(name string, reload func(), stop <-chan struct{}) error {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("invalid reload event name '%s': %w", name, err)
+	}
+
+	// Auto-reset event, so each Set triggers exactly one reload.
+	event, err := windows.CreateEvent(nil, 0, 0, namePtr)
+	if err != nil {
+		return fmt.Errorf("creating reload event '%s': %w", name, err)
+	}
+	stopEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		_ = windows.CloseHandle(event)
+		return fmt.Errorf("creating reload stop event: %w", err)
+	}
+
+	go func() {
+		<-stop
+		_ = windows.SetEvent(stopEvent)
+	}()
+
+	go func() {
+		defer windows.CloseHandle(event)
+		defer windows.CloseHandle(stopEvent)
+
+		handles := []windows.Handle{event, stopEvent}
+		for {
+			signaled, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE)
+			if err != nil || signaled != windows.WAIT_OBJECT_0 {
+				return
+			}
+			reload()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package certstore
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rolloverRetryInterval is how often the store is re-scanned for the
+// successor of a certificate due for rollover until one is found.
+const rolloverRetryInterval = time.Hour
+
+// maintainRollover re-scans the store once the cached certificate is within
+// the rollover window of its expiry, switching to a successor as soon as the
+// store holds one, until the cache entry is closed.
+func (cached *cachedCert) maintainRollover() {
+	for {
+		timer := time.NewTimer(cached.nextRollover())
+		select {
+		case <-cached.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		cached.rollover()
+	}
+}
+
+// nextRollover returns how long to wait before looking for a successor: until
+// the cached certificate is due, or rolloverRetryInterval once it is.
+func (cached *cachedCert) nextRollover() time.Duration {
+	cached.mu.RLock()
+	dueAt := cached.cert.Leaf.NotAfter.Add(-cached.selector.rollover)
+	cached.mu.RUnlock()
+
+	if wait := time.Until(dueAt); wait > 0 {
+		return wait
+	}
+	return rolloverRetryInterval
+}
+
+// rollover re-selects the cached certificate, logging whether a successor
+// replaced it.
+func (cached *cachedCert) rollover() {
+	cached.mu.RLock()
+	old := cached.cert.Leaf
+	cached.mu.RUnlock()
+
+	changed, err := cached.reselect(context.Background())
+
+	logger := cached.selector.logger
+	if logger == nil {
+		return
+	}
+	if err != nil {
+		logger.Error("looking for the successor of an expiring client certificate",
+			zap.String("serial_number", old.SerialNumber.String()),
+			zap.Time("not_after", old.NotAfter),
+			zap.Error(err),
+		)
+		return
+	}
+	if !changed {
+		logger.Warn("no successor found for expiring client certificate",
+			zap.String("serial_number", old.SerialNumber.String()),
+			zap.Time("not_after", old.NotAfter),
+			zap.Duration("retry_in", rolloverRetryInterval),
+		)
+		return
+	}
+
+	cached.mu.RLock()
+	current := cached.cert.Leaf
+	cached.mu.RUnlock()
+	logger.Info("rolled over expiring client certificate",
+		zap.String("old_serial_number", old.SerialNumber.String()),
+		zap.Time("old_not_after", old.NotAfter),
+		zap.String("new_serial_number", current.SerialNumber.String()),
+		zap.Time("new_not_after", current.NotAfter),
+	)
+}
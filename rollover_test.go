@@ -0,0 +1,108 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestCertSelector_RolloverWindow(t *testing.T) {
+	ca := newTestCA(t, "Rollover CA")
+	key := newTestKey(t)
+	now := time.Now()
+	// The successor is issued first, so the certificate due for rollover
+	// has the higher serial number preferred by default.
+	successor := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "rollover.example.test"},
+		NotAfter: now.Add(90 * 24 * time.Hour),
+	}, key.Public())
+	expiring := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "rollover.example.test"},
+		NotAfter: now.Add(24 * time.Hour),
+	}, key.Public())
+
+	tests := []struct {
+		window time.Duration
+		want   *x509.Certificate
+	}{
+		{window: 0, want: expiring},
+		{window: 30 * 24 * time.Hour, want: successor},
+	}
+	for _, tt := range tests {
+		t.Run("window "+tt.window.String(), func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(
+				newFakeIdentity(successor, newFakeSigner(key.Public(), []byte("ok"))),
+				newFakeIdentity(expiring, newFakeSigner(key.Public(), []byte("ok"))),
+			))
+
+			selector := newTestSelector("^rollover\\.example\\.test$")
+			selector.RolloverWindow = caddy.Duration(tt.window)
+			if err := selector.validate(); err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.SerialNumber.Cmp(tt.want.SerialNumber) != 0 {
+				t.Fatalf("expected serial %s, got %s", tt.want.SerialNumber, cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
+
+func TestCachedCertificate_RollsOverBeforeExpiry(t *testing.T) {
+	resetCertificateCache(t)
+
+	ca := newTestCA(t, "Rollover CA")
+	key := newTestKey(t)
+	window := time.Hour
+	// The certificate becomes due for rollover shortly after it is cached;
+	// NotAfter is encoded in whole seconds.
+	expiring := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "successor.example.test"},
+		NotAfter: time.Now().Add(window + 2*time.Second),
+	}, key.Public())
+	successor := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "successor.example.test"},
+		NotAfter: time.Now().Add(90 * 24 * time.Hour),
+	}, key.Public())
+	loads := []*fakeStoreLoad{
+		newFakeStoreLoad(expiring, newFakeSigner(key.Public(), []byte("expiring"))),
+		newFakeStoreLoadWithIdentities(
+			newFakeIdentity(expiring, newFakeSigner(key.Public(), []byte("expiring"))),
+			newFakeIdentity(successor, newFakeSigner(key.Public(), []byte("successor"))),
+		),
+	}
+	provider := withFakeStoreLoads(t, loads...)
+
+	selector := newTestSelector("^successor\\.example\\.test$")
+	selector.RolloverWindow = caddy.Duration(window)
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := selector.currentCertificate()
+		if err != nil {
+			t.Fatalf("current certificate failed: %v", err)
+		}
+		if current.Leaf.SerialNumber.Cmp(successor.SerialNumber) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected successor serial %s, still got %s", successor.SerialNumber, current.Leaf.SerialNumber)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if provider.openCount() != 2 {
+		t.Fatalf("expected one re-scan, got %d opens", provider.openCount())
+	}
+}
@@ -0,0 +1,162 @@
+package certstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a time matches if either does.
+	domAny, dowAny bool
+}
+
+// cronField describes the values allowed in one field of an expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// parseCronSchedule parses a standard cron expression such as "30 2 * * *".
+// Fields accept "*", values, ranges ("1-5"), steps ("*/15", "0-30/10") and
+// comma-separated lists. Day of week 7 is Sunday, like 0.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression '%s': expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %w", expr, err)
+		}
+		sets[i] = set
+	}
+
+	// Fold Sunday as 7 into Sunday as 0.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one comma-separated field into a bit set.
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var set uint64
+	for part := range strings.SplitSeq(field, ",") {
+		low, high, step, err := parseCronRange(part, spec)
+		if err != nil {
+			return 0, err
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// parseCronRange parses "*", "v", "a-b" or either followed by "/step".
+func parseCronRange(part string, spec cronField) (low, high, step int, err error) {
+	rangePart, stepPart, hasStep := strings.Cut(part, "/")
+	step = 1
+	if hasStep {
+		if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid step '%s' in %s field", stepPart, spec.name)
+		}
+	}
+	if low, high, err = parseCronBounds(rangePart, spec); err != nil {
+		return 0, 0, 0, err
+	}
+	if hasStep && !strings.Contains(rangePart, "-") {
+		// "v/step" runs from v to the end of the field.
+		high = spec.max
+	}
+	return low, high, step, nil
+}
+
+// parseCronBounds parses "*", "v" or "a-b" into its first and last values.
+func parseCronBounds(rangePart string, spec cronField) (low, high int, err error) {
+	if rangePart == "*" {
+		return spec.min, spec.max, nil
+	}
+	lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+	if low, err = parseCronValue(lowPart, spec); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return low, low, nil
+	}
+	if high, err = parseCronValue(highPart, spec); err != nil {
+		return 0, 0, err
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("invalid range '%s' in %s field", rangePart, spec.name)
+	}
+	return low, high, nil
+}
+
+func parseCronValue(value string, spec cronField) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < spec.min || n > spec.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field: must be %d-%d", value, spec.name, spec.min, spec.max)
+	}
+	return n, nil
+}
+
+// next returns the first time after t that matches the schedule, in t's
+// location. It returns the zero time if none matches within five years, as
+// with a schedule for February 30th.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule for the two day fields.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
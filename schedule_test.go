@@ -0,0 +1,48 @@
+package certstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	// Wednesday, 15 January 2025.
+	from := time.Date(2025, time.January, 15, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{expr: "* * * * *", expected: time.Date(2025, time.January, 15, 10, 21, 0, 0, time.UTC)},
+		{expr: "30 2 * * *", expected: time.Date(2025, time.January, 16, 2, 30, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", expected: time.Date(2025, time.January, 15, 10, 30, 0, 0, time.UTC)},
+		{expr: "0 9-17/4 * * *", expected: time.Date(2025, time.January, 15, 13, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 * *", expected: time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 6 * * 7", expected: time.Date(2025, time.January, 19, 6, 0, 0, 0, time.UTC)},
+		{expr: "0 6 * * 1,5", expected: time.Date(2025, time.January, 17, 6, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches.
+		{expr: "0 0 20 * 5", expected: time.Date(2025, time.January, 17, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", expected: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *", expected: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := parseCronSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if got := schedule.next(from); !got.Equal(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseCronSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "@daily"} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("expected an error for '%s'", expr)
+		}
+	}
+}
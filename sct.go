@@ -0,0 +1,79 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Policies for a selected certificate without embedded SCTs.
+const (
+	sctWarn = "warn"
+	sctFail = "fail"
+)
+
+// oidExtensionSCT identifies the embedded Signed Certificate Timestamp list
+// extension of RFC 6962.
+var oidExtensionSCT = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// errMissingSCT is returned when require_sct is "fail" and the selected
+// certificate has no embedded SCTs.
+var errMissingSCT = errors.New("selected certificate has no embedded SCTs")
+
+// validateSCTPolicy checks the require_sct setting.
+func (cs *CertSelector) validateSCTPolicy() error {
+	switch cs.RequireSCT {
+	case "", sctWarn, sctFail:
+		return nil
+	default:
+		return fmt.Errorf("unsupported require_sct value '%s': must be '%s' or '%s'", cs.RequireSCT, sctWarn, sctFail)
+	}
+}
+
+// checkSCTs applies the require_sct policy to the selected certificate,
+// failing or logging a warning when it carries no embedded SCTs.
+func (s selectorSnapshot) checkSCTs(cert *x509.Certificate) error {
+	if s.requireSCT == "" || embeddedSCTCount(cert) > 0 {
+		return nil
+	}
+	if s.requireSCT == sctFail {
+		return fmt.Errorf("%w: '%s' serial %s", errMissingSCT, cert.Subject.CommonName, cert.SerialNumber)
+	}
+	if s.logger != nil {
+		s.logger.Warn("selected certificate has no embedded SCTs",
+			zap.String("common_name", cert.Subject.CommonName),
+			zap.String("serial_number", cert.SerialNumber.String()),
+		)
+	}
+	return nil
+}
+
+// embeddedSCTCount returns the number of SCTs in the certificate's SCT list
+// extension. The extension holds an OCTET STRING wrapping a TLS-encoded list:
+// a two-byte length followed by SCTs, each prefixed by a two-byte length.
+func embeddedSCTCount(cert *x509.Certificate) int {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionSCT) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil || len(list) < 2 {
+			return 0
+		}
+		list = list[2:]
+		count := 0
+		for len(list) >= 2 {
+			n := int(list[0])<<8 | int(list[1])
+			if n == 0 || len(list) < 2+n {
+				return count
+			}
+			list = list[2+n:]
+			count++
+		}
+		return count
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// sctListExtension encodes an SCT list extension holding scts, whose
+// contents are opaque to the count.
+func sctListExtension(t *testing.T, scts ...[]byte) pkix.Extension {
+	t.Helper()
+
+	var entries []byte
+	for _, sct := range scts {
+		entries = append(entries, byte(len(sct)>>8), byte(len(sct)))
+		entries = append(entries, sct...)
+	}
+	list := append([]byte{byte(len(entries) >> 8), byte(len(entries))}, entries...)
+	value, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatalf("marshal SCT list: %v", err)
+	}
+	return pkix.Extension{Id: oidExtensionSCT, Value: value}
+}
+
+func TestEmbeddedSCTCount(t *testing.T) {
+	ca := newTestCA(t, "SCT CA")
+	key := newTestKey(t)
+
+	tests := []struct {
+		name       string
+		extensions []pkix.Extension
+		expected   int
+	}{
+		{name: "no extension", expected: 0},
+		{name: "two SCTs", extensions: []pkix.Extension{sctListExtension(t, []byte("first"), []byte("second"))}, expected: 2},
+		{name: "empty list", extensions: []pkix.Extension{sctListExtension(t)}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "sct.example.test"}, ExtraExtensions: tt.extensions}, key.Public())
+			if got := embeddedSCTCount(cert); got != tt.expected {
+				t.Fatalf("expected %d SCTs, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCertSelector_RequireSCT(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "nosct.example.test", key)
+
+	t.Run("fail", func(t *testing.T) {
+		resetCertificateCache(t)
+		withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+		selector := newTestSelector("^nosct\\.example\\.test$")
+		selector.RequireSCT = sctFail
+		if _, err := selector.loadCertificate(t.Context()); !errors.Is(err, errMissingSCT) {
+			t.Fatalf("expected errMissingSCT, got %v", err)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		resetCertificateCache(t)
+		withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok"))))
+
+		core, logs := observer.New(zapcore.WarnLevel)
+		selector := newTestSelector("^nosct\\.example\\.test$")
+		selector.RequireSCT = sctWarn
+		selector.logger = zap.New(core)
+		if _, err := selector.loadCertificate(t.Context()); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+		defer selector.release()
+		if logs.FilterMessage("selected certificate has no embedded SCTs").Len() != 1 {
+			t.Fatal("expected a warning about the missing SCTs")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		selector := &CertSelector{Pattern: "^nosct$", RequireSCT: "always"}
+		assertErrorContains(t, selector.validate(), "unsupported require_sct value")
+	})
+}
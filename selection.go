@@ -0,0 +1,36 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// Policies choosing between matches of the same rank.
+const (
+	selectNewest          = "newest"
+	selectLongestValidity = "longest_validity"
+)
+
+// validateSelectionPolicy checks the selection setting.
+func (cs *CertSelector) validateSelectionPolicy() error {
+	switch cs.Selection {
+	case "", selectNewest, selectLongestValidity:
+		return nil
+	default:
+		return fmt.Errorf("unsupported selection value '%s': must be '%s' or '%s'", cs.Selection, selectNewest, selectLongestValidity)
+	}
+}
+
+// compareBySelection compares two matching certificates under the selection
+// policy, returning a positive number when a is preferred, a negative one
+// when b is and 0 when the policy has no preference.
+func compareBySelection(policy string, a, b *x509.Certificate) int {
+	switch policy {
+	case selectNewest:
+		return a.NotBefore.Compare(b.NotBefore)
+	case selectLongestValidity:
+		return a.NotAfter.Compare(b.NotAfter)
+	default:
+		return 0
+	}
+}
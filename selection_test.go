@@ -0,0 +1,65 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestCertSelector_Selection(t *testing.T) {
+	ca := newTestCA(t, "Selection CA")
+	key := newTestKey(t)
+	issue := func(notBefore, notAfter time.Time) *x509.Certificate {
+		return ca.issue(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "renewed.example.test"},
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+		}, key.Public())
+	}
+	// The certificates are issued from the one preferred by longest_validity
+	// to the one preferred by default, with the highest serial number.
+	now := time.Now()
+	longLived := issue(now.Add(-48*time.Hour), now.Add(365*24*time.Hour))
+	renewal := issue(now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	original := issue(now.Add(-30*24*time.Hour), now.Add(24*time.Hour))
+
+	tests := []struct {
+		selection string
+		want      *x509.Certificate
+	}{
+		{selection: "", want: original},
+		{selection: selectNewest, want: renewal},
+		{selection: selectLongestValidity, want: longLived},
+	}
+	for _, tt := range tests {
+		t.Run("selection "+tt.selection, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(
+				newFakeIdentity(original, newFakeSigner(key.Public(), []byte("ok"))),
+				newFakeIdentity(renewal, newFakeSigner(key.Public(), []byte("ok"))),
+				newFakeIdentity(longLived, newFakeSigner(key.Public(), []byte("ok"))),
+			))
+
+			selector := newTestSelector("^renewed\\.example\\.test$")
+			selector.Selection = tt.selection
+			if err := selector.validate(); err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.SerialNumber.Cmp(tt.want.SerialNumber) != 0 {
+				t.Fatalf("expected serial %s, got %s", tt.want.SerialNumber, cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
+
+func TestCertSelector_SelectionValidation(t *testing.T) {
+	selector := newTestSelector("^renewed\\.example\\.test$")
+	selector.Selection = "random"
+	assertErrorContains(t, selector.validate(), "unsupported selection value 'random'")
+}
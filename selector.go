@@ -1,53 +1,733 @@
 package certstore
 
 import (
+	"cmp"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
-	"github.com/tailscale/certstore"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/caddyserver/caddy/v2"
 )
 
+// preferHardware is the Prefer value that favors hardware-backed keys.
+const preferHardware = "hardware"
+
+// defaultNotBeforeSkew is the clock skew tolerated for freshly issued
+// certificates.
+const defaultNotBeforeSkew = 5 * time.Minute
+
 // CertSelector specifies criteria for selecting a certificate from the store.
 type CertSelector struct {
-	// Pattern is the regex pattern to match against the certificate field.
-	// Required. Use anchors (^, $) for exact matches, e.g., "^exact\.match$"
+	// Pattern is matched against the certificate field, read as a regular
+	// expression, an exact value or a glob according to MatchMode. Required
+	// unless Thumbprint, Template, AllOf or AnyOf is set.
 	Pattern string `json:"pattern"`
 
+	// AllOf lists further field patterns a certificate must all match, so
+	// that, for example, both subject and issuer can be matched.
+	AllOf []*FieldMatch `json:"all_of,omitempty"`
+
+	// AnyOf lists field patterns of which a certificate must match at least
+	// one, in addition to Pattern and AllOf.
+	AnyOf []*FieldMatch `json:"any_of,omitempty"`
+
+	// Template limits matches to certificates issued from this Active
+	// Directory Certificate Services template, given by OID, as needed for
+	// version 2 and later templates, or by the name version 1 templates such
+	// as "Machine" stamp in the certificate. Names match case-insensitively.
+	Template string `json:"template,omitempty"`
+
+	// Thumbprint pins the certificate by its hex SHA-1 or SHA-256
+	// thumbprint, as shown by certmgr.msc or openssl, so that one of several
+	// renewals sharing a subject can be chosen unambiguously. Spaces and
+	// colons are ignored. When Pattern is also set, both must match.
+	Thumbprint string `json:"thumbprint,omitempty"`
+
+	// MatchMode sets how Pattern is interpreted: "regex" as a regular
+	// expression, "exact" as the whole field value, or "glob" where "*"
+	// matches any run of characters and "?" any single character. Exact and
+	// glob patterns match the whole value. Default: "regex"
+	MatchMode string `json:"match_mode,omitempty"`
+
 	// Field specifies which certificate field to match against.
-	// Valid values: "subject" (default), "issuer", "serial", "dns_names"
+	// Valid values: "subject" (default), "issuer", "issuer_dn", "serial",
+	// "dns_names", "ou", "organization". "ou" and "organization" match if
+	// any of the subject's organizational units or organizations does.
+	// "issuer" is the issuer's common name and "issuer_dn" its
+	// full distinguished name in RFC 4514 form, such as
+	// "CN=Issuing CA,OU=PKI,O=Example Corp,C=US", telling apart
+	// intermediates that share a common name. Serial numbers can be given
+	// in decimal or in hex as certutil and Keychain Access show them, bytes
+	// optionally separated by spaces or colons.
 	Field string `json:"field,omitempty"`
 
+	// ExtendedKeyUsages limits matches to certificates valid for all of
+	// these Enhanced Key Usages, given as the friendly names shown in
+	// certmgr.msc, such as "Client Authentication" or "Smart Card Logon", or
+	// as OIDs. A certificate without the extension is valid for all usages.
+	ExtendedKeyUsages []string `json:"extended_key_usages,omitempty"`
+
+	// ValidateEKUChain skips identities whose issuing CAs restrict Enhanced
+	// Key Usages to a set that does not permit the leaf's usages. Strict
+	// validators such as Windows Schannel reject such mis-issued
+	// certificates. Default: false
+	ValidateEKUChain bool `json:"validate_eku_chain,omitempty"`
+
+	// KeyType limits matches to certificates whose key is of this type:
+	// "rsa", "ecdsa" or "ed25519". Default: any type
+	KeyType string `json:"key_type,omitempty"`
+
+	// KeyProvider limits matches, on Windows, to identities whose private
+	// key is kept by this CNG key storage provider or CryptoAPI CSP, such
+	// as "Microsoft Software Key Storage Provider", telling apart copies of
+	// a certificate with keys in different providers. Matched
+	// case-insensitively. Default: any provider
+	KeyProvider string `json:"key_provider,omitempty"`
+
+	// KeyContainer limits matches, on Windows, to identities whose private
+	// key is in this key container. Matched case-insensitively.
+	// Default: any container
+	KeyContainer string `json:"key_container,omitempty"`
+
+	// MinKeyBits skips certificates with an RSA key shorter than this many
+	// bits, such as legacy 1024-bit certificates sharing the subject of
+	// their replacement. ECDSA and Ed25519 keys are not affected.
+	// Default: no minimum
+	MinKeyBits int `json:"min_key_bits,omitempty"`
+
 	// Location specifies which certificate store to use.
 	// On Windows: "user" (CurrentUser) or "machine" (LocalMachine)
-	// On macOS: "user" or "system" (no effect - Keychain searches both automatically)
+	// On macOS: "user" (the keychain search list) or "system" (only the
+	// System keychain)
+	// "any" enumerates both stores and selects from the union of their
+	// identities, preferring the user store on ties.
+	// "nssdb" opens an NSS shared database instead, see NSSDatabase, "piv"
+	// the YubiKeys connected, see PIVSlot, and "directory" a directory of
+	// certificate files, see Directory.
 	Location string `json:"location,omitempty"`
 
+	// StoreName opens a Windows logical store of the location other than
+	// Personal ("MY"), such as "WebHosting", "Remote Desktop" or a custom
+	// store. Only supported on Windows. Default: "" (Personal)
+	StoreName string `json:"store_name,omitempty"`
+
+	// Keychain searches only this keychain file, such as a dedicated
+	// "/Library/Keychains/caddy.keychain-db", instead of the keychain search
+	// list. Relative paths are resolved in ~/Library/Keychains. Only
+	// supported on macOS. Default: "" (search list)
+	Keychain string `json:"keychain,omitempty"`
+
+	// NSSDatabase is the directory of the NSS shared database opened by the
+	// "nssdb" location, with or without the "sql:" prefix certutil takes.
+	// Only supported on Linux builds with the nss build tag.
+	// Default: "" (~/.pki/nssdb)
+	NSSDatabase string `json:"nss_database,omitempty"`
+
+	// Directory is the directory of PEM and PKCS#12 files opened by the
+	// "directory" location, which works on every platform and build. PEM
+	// files hold a certificate chain and its private key, or have the key
+	// in a file of the same name ending in .key or -key.pem; PKCS#12 files
+	// must not have a password. Required by the "directory" location.
+	Directory string `json:"directory,omitempty"`
+
+	// PIVSlot limits the "piv" location to the identity of one PIV slot:
+	// "9a" (authentication), "9c" (signature), "9d" (key management) or
+	// "9e" (card authentication). Only supported in builds with the piv
+	// build tag. Default: "" (all four slots)
+	PIVSlot string `json:"piv_slot,omitempty"`
+
+	// PIN unlocks the private key of a token, such as a YubiKey PIV slot
+	// or, on Windows, a smart card, when it asks for one, so that signing
+	// does not prompt for it when Caddy runs headless. Use a placeholder
+	// such as {env.PIV_PIN} to keep it out of the configuration.
+	// Default: "" (no PIN)
+	PIN string `json:"pin,omitempty"`
+
+	// Impersonate retries loads the OS denies the account running Caddy,
+	// such as a service account without access to a LocalMachine key, on a
+	// thread impersonating this account instead. Only supported on Windows.
+	// Default: nil (no retry)
+	Impersonate *Impersonation `json:"impersonate,omitempty"`
+
+	// FetchOCSP enables fetching the OCSP response for the selected
+	// certificate from its responder. The response is stapled to the
+	// certificate and refreshed halfway through its validity window, and
+	// revocation is logged as an error.
+	FetchOCSP bool `json:"fetch_ocsp,omitempty"`
+
+	// RefreshInterval re-runs the selector against the store at this
+	// interval and swaps in the certificate it selects when it differs from
+	// the cached one, so a renewed or re-enrolled certificate is presented
+	// without a config reload. Handshakes already under way keep the
+	// previous certificate. Default: 0 (never)
+	RefreshInterval caddy.Duration `json:"refresh_interval,omitempty"`
+
+	// MatchAcceptableCAs presents, to a server whose certificate request
+	// lists CAs that did not issue the selected certificate, another
+	// identity matching the selector whose chain one of those CAs issued,
+	// so one selector works against upstreams with different trust anchors.
+	// The store is searched once per list of CAs; when it has no such
+	// identity, no certificate is sent, as without this option.
+	// Default: false
+	MatchAcceptableCAs bool `json:"match_acceptable_cas,omitempty"`
+
+	// Prefer breaks ties when several identities match. "hardware" chooses
+	// an identity whose private key is non-exportable and hardware-backed
+	// (TPM, smart card or Secure Enclave) over a software copy of the same
+	// certificate. Remaining ties go to the highest serial number, so the
+	// choice does not depend on enumeration order. Default: ""
+	Prefer string `json:"prefer,omitempty"`
+
+	// Selection chooses between matches that still tie after validity and
+	// Prefer, such as the original certificate and its renewal. "newest"
+	// chooses the latest NotBefore, "longest_validity" the latest NotAfter,
+	// so a renewal wins over the nearly expired original. Default: the
+	// highest serial number
+	Selection string `json:"selection,omitempty"`
+
+	// NotBeforeSkew is how far in the future a certificate's NotBefore may
+	// be and the certificate still count as valid, so one issued seconds ago
+	// by auto-enrollment is not passed over on hosts whose clock is slightly
+	// off. Valid certificates are chosen over expired or not yet valid
+	// matches. Default: 5m
+	NotBeforeSkew caddy.Duration `json:"not_before_skew,omitempty"`
+
+	// RolloverWindow is how long before its NotAfter a certificate is due
+	// for renewal, such as 720h for 30 days. Matches not yet due are chosen
+	// over valid matches that are, and once the cached certificate is due
+	// the store is re-scanned for a successor, hourly until one is found,
+	// and the rollover is logged. Default: 0 (no rollover)
+	RolloverWindow caddy.Duration `json:"rollover_window,omitempty"`
+
+	// RequireSCT checks that the selected certificate embeds Certificate
+	// Transparency SCTs, for organizations that mandate CT-logged
+	// certificates. "warn" logs a warning when it does not and "fail" fails
+	// selection. Default: "" (not checked)
+	RequireSCT string `json:"require_sct,omitempty"`
+
+	// DisableCache gives the selector a private cache entry, so its
+	// certificate is read from the store on every provision instead of being
+	// shared with identical selectors or adopted across config reloads. Use
+	// it for extremely short-lived certificates. Default: false
+	DisableCache bool `json:"disable_cache,omitempty"`
+
+	// FallbackFile is a PEM or PKCS#12 file holding the certificate to use
+	// when no identity in the store matches or the store cannot be opened,
+	// so the same config runs on developer laptops and in containers without
+	// the enterprise certificate. PKCS#12 files must not have a password.
+	// Default: "" (fail)
+	FallbackFile string `json:"fallback_file,omitempty"`
+
+	// FallbackKey is the PEM file holding the private key of a PEM
+	// FallbackFile. Default: the key in FallbackFile or in a file of the
+	// same name ending in .key or -key.pem
+	FallbackKey string `json:"fallback_key,omitempty"`
+
+	// Optional lets provisioning succeed when no identity matches: a
+	// warning is logged and no client certificate is presented, so a config
+	// shared between environments only requires mTLS where the certificate
+	// exists. Default: false
+	Optional bool `json:"optional,omitempty"`
+
+	// DevSelfSigned is a development option. When no identity matches, a
+	// self-signed client certificate with this common name is generated,
+	// imported into the user store and selected, much like Caddy's local CA.
+	// The name must match the pattern. Never use it in production.
+	DevSelfSigned string `json:"dev_self_signed,omitempty"`
+
+	// ExtraIntermediates adds certificates, from PEM files or the OS
+	// intermediate store, to the presented chain.
+	ExtraIntermediates *ExtraIntermediates `json:"extra_intermediates,omitempty"`
+
+	// VerifyChainLinkage checks the signatures linking the chain stored with
+	// the identity and drops certificates that do not chain to the leaf. The
+	// chain is always put in leaf-first order without duplicates, as some
+	// Windows providers return it unordered or with repeats. Default: false
+	VerifyChainLinkage bool `json:"verify_chain_linkage,omitempty"`
+
+	// SendRoot keeps self-signed roots in the presented chain. They are
+	// removed by default, since peers validate against roots they already
+	// hold and some TLS stacks reject chains that include one. Default: false
+	SendRoot bool `json:"send_root,omitempty"`
+
+	// ChainPreference chooses the presented path when the chain can build
+	// several, as with cross-signed roots. Default: the stored chain
+	ChainPreference *ChainPreference `json:"chain_preference,omitempty"`
+
+	// PinnedRoot requires the presented path to end at this root, failing
+	// selection when the chain cannot build such a path.
+	PinnedRoot *PinnedRoot `json:"pinned_root,omitempty"`
+
+	// OnInteractionDenied sets what happens when the keychain refuses access
+	// to the private key because it would need user interaction, as in
+	// headless macOS sessions. "fail" (default) fails the handshake, "retry"
+	// retries signing until interaction_retry_timeout elapses so the keychain
+	// can be unlocked, and "fallback" uses the fallback selector for the
+	// following handshakes.
+	OnInteractionDenied string `json:"on_interaction_denied,omitempty"`
+
+	// InteractionRetryTimeout bounds the "retry" policy. Default: 30s
+	InteractionRetryTimeout caddy.Duration `json:"interaction_retry_timeout,omitempty"`
+
+	// Fallback is the selector used by the "fallback" policy.
+	Fallback *CertSelector `json:"fallback,omitempty"`
+
+	// MaxCandidates stops matching with an error after examining this many
+	// identities without a match. Protects provisioning from pathological
+	// stores with thousands of entries. When a match was already found, it
+	// is used with a warning, even though an identity not yet examined may
+	// have ranked higher. Default: 0 (unlimited)
+	MaxCandidates int `json:"max_candidates,omitempty"`
+
+	// MaxEnumerationTime stops matching with an error once opening and
+	// scanning the store has taken this long. As with MaxCandidates, a match
+	// already found is used, and may be outranked by one not yet examined.
+	// Default: 0 (unlimited)
+	MaxEnumerationTime caddy.Duration `json:"max_enumeration_time,omitempty"`
+
+	// LogLevel is the minimum level of the selector's log entries, such as
+	// "warn" to quiet a noisy selector. It cannot lower the level of Caddy's
+	// logs; to debug one selector, include its logger name in a DEBUG log.
+	LogLevel string `json:"log_level,omitempty"`
+
 	// runtime resources kept for cleanup (unexported, not serialized)
-	cacheKey   string
-	cacheEntry *cachedCert
-	pattern    *regexp.Regexp
-	logger     *zap.Logger
+	name           string
+	owner          string
+	cacheNonce     string
+	extKeyUsages   []asn1.ObjectIdentifier
+	thumbprint     []byte
+	allOf          []fieldMatcher
+	anyOf          []fieldMatcher
+	template       templateMatcher
+	intermediates  []*x509.Certificate
+	pinnedRootSPKI []byte
+	cache          *certificateCache
+	cacheKey       string
+	cacheEntry     *cachedCert
+	pattern        *regexp.Regexp
+	logger         *zap.Logger
+	events         *eventEmitter
+
+	// acceptableCAs holds the selectors derived for match_acceptable_cas,
+	// and acceptableCAList the CAs a derived selector's chain must be
+	// issued by.
+	acceptableCAs    *acceptableCASelectors
+	acceptableCAList [][]byte
 }
 
 type selectorSnapshot struct {
 	patternString string
+	matchMode     string
 	pattern       *regexp.Regexp
 	field         string
+	thumbprint    []byte
+	allOf         []fieldMatcher
+	anyOf         []fieldMatcher
+	template      templateMatcher
+	extKeyUsages  []asn1.ObjectIdentifier
+	ekuChaining   bool
+	keyType       string
+	minKeyBits    int
+	keyProvider   string
+	keyContainer  string
 	location      string
+	storeName     string
+	pin           string
+	impersonate   *Impersonation
+	fetchOCSP     bool
+	refresh       time.Duration
+	prefer        string
+	selection     string
+	maxCandidates int
+	maxEnumTime   time.Duration
+	notBeforeSkew time.Duration
+	rollover      time.Duration
+	devCommonName string
+	fallbackFile  string
+	fallbackKey   string
+	requireSCT    string
+	acceptableCAs [][]byte
+	cacheNonce    string
 	logger        *zap.Logger
+	events        *eventEmitter
+
+	// name and logLevel set up logger, so selectors logging differently
+	// do not share a cache entry.
+	name     string
+	logLevel string
+
+	interactionPolicy       string
+	interactionRetryTimeout time.Duration
+
+	intermediateFiles      []*x509.Certificate
+	intermediatesFromStore bool
+	verifyChainLinkage     bool
+	sendRoot               bool
+
+	chainPolicy          string
+	chainRootCommonNames []string
+	pinnedRootName       string
+	pinnedRootSPKI       []byte
+}
+
+// provision applies the app's defaults, validates the selector, resolves
+// placeholders, compiles the pattern and loads the matching certificate into the app's cache.
+func (cs *CertSelector) provision(ctx caddy.Context, app *App) error {
+	app.Defaults.apply(cs)
+	if err := cs.validate(); err != nil {
+		return err
+	}
+
+	// Set up logger and cache for the cert selector
+	cs.logger = cs.selectorLogger(ctx)
+	cs.owner = cs.modulePath(ctx)
+	events, err := newEventEmitter(ctx)
+	if err != nil {
+		return err
+	}
+	cs.events = events
+	cs.cache = app.cache
+	if cs.MatchAcceptableCAs {
+		cs.acceptableCAs = new(acceptableCASelectors)
+	}
+	if cs.DisableCache {
+		// A nonce in the cache key keeps the entry private to this selector.
+		cs.cacheNonce = rand.Text()
+	}
+
+	if err := cs.resolve(ctx); err != nil {
+		return err
+	}
+
+	if cs.Fallback != nil {
+		if cs.name != "" {
+			cs.Fallback.name = cs.name + ".fallback"
+		}
+		if err := cs.Fallback.provision(ctx, app); err != nil {
+			return fmt.Errorf("provisioning fallback selector: %w", err)
+		}
+	}
+
+	// Load certificate from cache (or load and cache it)
+	if _, err := cs.loadCertificate(ctx); err != nil {
+		if cs.Optional && errors.Is(err, errNoMatchingIdentity) {
+			cs.logger.Warn("no client certificate found for optional selector, continuing without one",
+				zap.String("location", cs.Location),
+				zap.String("pattern", cs.Pattern),
+				zap.Error(err),
+			)
+			return nil
+		}
+		return fmt.Errorf("no client certificate found in: %s matching pattern: %s: %w", cs.Location, cs.Pattern, err)
+	}
+
+	return nil
+}
+
+// validate checks the selector configuration.
+func (cs *CertSelector) validate() error {
+	if !cs.hasCriteria() {
+		return fmt.Errorf("client_certificate must set 'pattern', 'thumbprint', 'template', 'all_of' or 'any_of' property")
+	}
+	if err := cs.validatePolicies(); err != nil {
+		return err
+	}
+	if err := cs.validateChainOptions(); err != nil {
+		return err
+	}
+	var err error
+	if cs.extKeyUsages, err = parseExtKeyUsages(cs.ExtendedKeyUsages); err != nil {
+		return err
+	}
+	if cs.MaxCandidates < 0 {
+		return fmt.Errorf("max_candidates must not be negative")
+	}
+	if cs.MaxEnumerationTime < 0 {
+		return fmt.Errorf("max_enumeration_time must not be negative")
+	}
+	if cs.NotBeforeSkew < 0 {
+		return fmt.Errorf("not_before_skew must not be negative")
+	}
+	if cs.RolloverWindow < 0 {
+		return fmt.Errorf("rollover_window must not be negative")
+	}
+	if cs.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval must not be negative")
+	}
+	if cs.LogLevel != "" {
+		if _, err := zapcore.ParseLevel(cs.LogLevel); err != nil {
+			return fmt.Errorf("invalid log_level '%s': %w", cs.LogLevel, err)
+		}
+	}
+	return nil
+}
+
+// hasCriteria reports whether the selector sets any criterion choosing its
+// certificate.
+func (cs *CertSelector) hasCriteria() bool {
+	return cs.Pattern != "" || cs.Thumbprint != "" || cs.Template != "" || len(cs.AllOf) > 0 || len(cs.AnyOf) > 0
+}
+
+// validateStore checks the settings naming the store of the location.
+func (cs *CertSelector) validateStore() error {
+	if cs.StoreName != "" && cs.Keychain != "" {
+		return fmt.Errorf("store_name and keychain are mutually exclusive")
+	}
+	location := normalizeStoreLocation(cs.Location)
+	if slices.Contains(portableLocations, location) && (cs.StoreName != "" || cs.Keychain != "") {
+		return fmt.Errorf("store_name and keychain cannot be used with location '%s'", location)
+	}
+	if location != "nssdb" && cs.NSSDatabase != "" {
+		return fmt.Errorf("nss_database requires location 'nssdb'")
+	}
+	if err := cs.validateDirectory(); err != nil {
+		return err
+	}
+	if err := cs.validateFallbackFile(); err != nil {
+		return err
+	}
+	return cs.validatePIVSlot()
+}
+
+// validatePolicies checks the settings choosing the store and between its
+// identities, and handling their failures.
+func (cs *CertSelector) validatePolicies() error {
+	if err := cs.validateStore(); err != nil {
+		return err
+	}
+	if err := cs.validateImpersonation(); err != nil {
+		return err
+	}
+	if cs.Prefer != "" && cs.Prefer != preferHardware {
+		return fmt.Errorf("unsupported prefer value '%s': must be '%s'", cs.Prefer, preferHardware)
+	}
+	if err := cs.validateSelectionPolicy(); err != nil {
+		return err
+	}
+	if err := cs.validateKeyPolicy(); err != nil {
+		return err
+	}
+	if err := cs.validateSCTPolicy(); err != nil {
+		return err
+	}
+	return cs.validateInteractionPolicy()
+}
+
+// selectorLogger returns the selector's logger. Selectors defined in the app
+// get their own logger, such as certstore.selectors.banking, so that logs can
+// be filtered per selector.
+func (cs *CertSelector) selectorLogger(ctx caddy.Context) *zap.Logger {
+	logger := ctx.Logger()
+	if cs.name != "" {
+		logger = logger.Named("selectors." + cs.name)
+	}
+	if cs.LogLevel != "" {
+		// validate has already parsed the level.
+		level, _ := zapcore.ParseLevel(cs.LogLevel)
+		logger = logger.WithOptions(zap.IncreaseLevel(level))
+	}
+	return logger
+}
+
+// modulePath describes the module that provisioned the selector, such as
+// "http.handlers.reverse_proxy > http.reverse_proxy.transport.certstore" or
+// "certstore > selectors.banking", so leaked cache references name their
+// owner.
+func (cs *CertSelector) modulePath(ctx caddy.Context) string {
+	var path []string
+	for _, module := range ctx.Modules() {
+		path = append(path, string(module.CaddyModule().ID))
+	}
+	if cs.name != "" {
+		path = append(path, "selectors."+cs.name)
+	}
+	if len(path) == 0 {
+		return "unknown"
+	}
+	return strings.Join(path, " > ")
+}
+
+// resolve replaces placeholders, compiles the pattern, reads the
+// intermediates files and checks the options that depend on the pattern.
+func (cs *CertSelector) resolve(ctx caddy.Context) error {
+	// Support placeholders:
+	repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+
+	cs.Pattern = repl.ReplaceKnown(cs.Pattern, "")
+	cs.Thumbprint = repl.ReplaceKnown(cs.Thumbprint, "")
+	cs.Field = repl.ReplaceKnown(cs.Field, "")
+	cs.Location = repl.ReplaceKnown(cs.Location, "")
+	cs.StoreName = repl.ReplaceKnown(cs.StoreName, "")
+	cs.Keychain = repl.ReplaceKnown(cs.Keychain, "")
+	cs.NSSDatabase = repl.ReplaceKnown(cs.NSSDatabase, "")
+	cs.Directory = repl.ReplaceKnown(cs.Directory, "")
+	cs.FallbackFile = repl.ReplaceKnown(cs.FallbackFile, "")
+	cs.FallbackKey = repl.ReplaceKnown(cs.FallbackKey, "")
+	cs.PIVSlot = repl.ReplaceKnown(cs.PIVSlot, "")
+	cs.PIN = repl.ReplaceKnown(cs.PIN, "")
+	if cs.Impersonate != nil {
+		cs.Impersonate.User = repl.ReplaceKnown(cs.Impersonate.User, "")
+		cs.Impersonate.Password = repl.ReplaceKnown(cs.Impersonate.Password, "")
+	}
+
+	if err := cs.compileCriteria(repl); err != nil {
+		return err
+	}
+
+	var err error
+	if cs.ExtraIntermediates != nil {
+		for i, file := range cs.ExtraIntermediates.Files {
+			cs.ExtraIntermediates.Files[i] = repl.ReplaceKnown(file, "")
+		}
+		cs.intermediates, err = loadIntermediateFiles(cs.ExtraIntermediates.Files)
+		if err != nil {
+			return err
+		}
+	}
+	return cs.validateDevSelfSigned()
+}
+
+// compileCriteria compiles the pattern, the composite criteria, the template
+// and the thumbprint.
+func (cs *CertSelector) compileCriteria(repl *caddy.Replacer) error {
+	var err error
+	cs.pattern, err = compileFieldPattern(normalizeSelectorField(cs.Field), cs.Pattern, cs.MatchMode)
+	if err != nil {
+		return err
+	}
+	if cs.Pattern == "" {
+		// The thumbprint or composite criteria alone select the
+		// certificate.
+		cs.pattern = regexp.MustCompile("")
+	}
+
+	for _, match := range slices.Concat(cs.AllOf, cs.AnyOf) {
+		match.Pattern = repl.ReplaceKnown(match.Pattern, "")
+	}
+	if cs.allOf, err = compileFieldMatches("all_of", cs.AllOf, cs.MatchMode); err != nil {
+		return err
+	}
+	if cs.anyOf, err = compileFieldMatches("any_of", cs.AnyOf, cs.MatchMode); err != nil {
+		return err
+	}
+
+	cs.Template = repl.ReplaceKnown(cs.Template, "")
+	cs.template = newTemplateMatcher(cs.Template)
+
+	if cs.Thumbprint != "" {
+		if cs.thumbprint, err = parseThumbprint(cs.Thumbprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateChainOptions validates the chain preference and decodes the pinned
+// root.
+func (cs *CertSelector) validateChainOptions() error {
+	if cs.ChainPreference != nil {
+		if err := cs.ChainPreference.validate(); err != nil {
+			return err
+		}
+	}
+	if cs.PinnedRoot != nil {
+		var err error
+		if cs.pinnedRootSPKI, err = cs.PinnedRoot.decodeSPKI(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loaded reports whether the selector has a certificate, which an optional
+// selector lacks when no identity matched.
+func (cs *CertSelector) loaded() bool {
+	return cs.cacheEntry != nil
+}
+
+// release drops the selector's reference to its cached certificate.
+func (cs *CertSelector) release() {
+	if cs.cacheKey != "" {
+		cs.cache.release(cs.cacheKey, cs.owner)
+		cs.cacheKey = ""
+	}
+	cs.releaseAcceptableCAMatches()
+	if cs.Fallback != nil {
+		cs.Fallback.release()
+	}
 }
 
 func (cs *CertSelector) snapshot() selectorSnapshot {
-	return selectorSnapshot{
+	snapshot := selectorSnapshot{
 		patternString: cs.Pattern,
+		matchMode:     cmp.Or(cs.MatchMode, matchRegex),
 		pattern:       cs.pattern,
 		field:         normalizeSelectorField(cs.Field),
+		thumbprint:    cs.thumbprint,
+		allOf:         cs.allOf,
+		anyOf:         cs.anyOf,
+		template:      cs.template,
+		extKeyUsages:  cs.extKeyUsages,
+		ekuChaining:   cs.ValidateEKUChain,
+		keyType:       cs.KeyType,
+		minKeyBits:    cs.MinKeyBits,
+		keyProvider:   cs.KeyProvider,
+		keyContainer:  cs.KeyContainer,
 		location:      normalizeStoreLocation(cs.Location),
+		storeName:     cmp.Or(cs.StoreName, cs.Keychain, cs.NSSDatabase, cs.Directory, strings.ToLower(cs.PIVSlot)),
+		pin:           cs.PIN,
+		impersonate:   cs.Impersonate,
+		fetchOCSP:     cs.FetchOCSP,
+		refresh:       time.Duration(cs.RefreshInterval),
+		prefer:        cs.Prefer,
+		selection:     cs.Selection,
+		maxCandidates: cs.MaxCandidates,
+		maxEnumTime:   time.Duration(cs.MaxEnumerationTime),
+		notBeforeSkew: cmp.Or(time.Duration(cs.NotBeforeSkew), defaultNotBeforeSkew),
+		rollover:      time.Duration(cs.RolloverWindow),
+		devCommonName: cs.DevSelfSigned,
+		fallbackFile:  cs.FallbackFile,
+		fallbackKey:   cs.FallbackKey,
+		requireSCT:    cs.RequireSCT,
+		acceptableCAs: cs.acceptableCAList,
+		cacheNonce:    cs.cacheNonce,
 		logger:        cs.logger,
+		events:        cs.events,
+
+		name:     cs.name,
+		logLevel: cs.LogLevel,
+
+		interactionPolicy:       cs.interactionPolicy(),
+		interactionRetryTimeout: cmp.Or(time.Duration(cs.InteractionRetryTimeout), defaultInteractionRetryTimeout),
+
+		intermediateFiles:      cs.intermediates,
+		intermediatesFromStore: cs.ExtraIntermediates != nil && cs.ExtraIntermediates.FromStore,
+		verifyChainLinkage:     cs.VerifyChainLinkage,
+		sendRoot:               cs.SendRoot,
 	}
+	if cs.ChainPreference != nil {
+		snapshot.chainPolicy = cs.ChainPreference.Policy
+		snapshot.chainRootCommonNames = cs.ChainPreference.RootCommonNames
+	}
+	if cs.PinnedRoot != nil {
+		snapshot.pinnedRootName = cs.PinnedRoot.CommonName
+		snapshot.pinnedRootSPKI = cs.pinnedRootSPKI
+	}
+	return snapshot
 }
 
 func normalizeSelectorField(field string) string {
@@ -57,35 +737,103 @@ func normalizeSelectorField(field string) string {
 	return field
 }
 
+// portableLocations are the normalized locations available on every
+// platform, which do not name their store with store_name or keychain.
+var portableLocations = []string{"nssdb", "piv", "directory"}
+
 func normalizeStoreLocation(location string) string {
-	if strings.EqualFold(location, "user") {
+	switch strings.ToLower(location) {
+	case "user":
 		return "user"
+	case "any":
+		return "any"
+	case "nssdb":
+		return "nssdb"
+	case "piv":
+		return "piv"
+	case "directory":
+		return "directory"
+	default:
+		return "system"
 	}
-	return "system"
 }
 
 // loadCertificateWithResources loads a certificate from the store and returns
 // the certificate along with the store and identity handles for resource management.
-func (s selectorSnapshot) loadCertificateWithResources() (tls.Certificate, certstore.Store, certstore.Identity, error) {
-	var cert tls.Certificate
+// Store calls cannot be interrupted, so the load runs in the background: when ctx
+// is done first, the context's error is returned and the background load closes
+// whatever resources it acquires once the OS calls return.
+func (s selectorSnapshot) loadCertificateWithResources(ctx context.Context) (tls.Certificate, Store, Identity, error) {
+	type loadResult struct {
+		cert     tls.Certificate
+		store    Store
+		identity Identity
+		err      error
+	}
 
-	storeLocation := getStoreLocation(s.location)
+	results := make(chan loadResult, 1)
+	go func() {
+		cert, store, identity, err := s.loadFromStore(ctx)
+		results <- loadResult{cert: cert, store: store, identity: identity, err: err}
+	}()
 
-	store, err := openCertStore(storeLocation, certstore.ReadOnly)
-	if err != nil {
-		return cert, nil, nil, err
+	select {
+	case result := <-results:
+		return result.cert, result.store, result.identity, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-results; result.err == nil {
+				closeCertificateResources(result.identity, result.store)
+			}
+		}()
+		return tls.Certificate{}, nil, nil, ctx.Err()
 	}
+}
 
-	identities, err := store.Identities()
+// loadFromStore finds the matching identity and builds its certificate.
+// When the OS denies access and impersonate is set, the load is retried as
+// that account.
+func (s selectorSnapshot) loadFromStore(ctx context.Context) (tls.Certificate, Store, Identity, error) {
+	cert, store, identity, err := s.loadIdentity(ctx)
+	if err == nil || !isAccessDenied(err) {
+		return cert, store, identity, err
+	}
+	if s.impersonate == nil {
+		return cert, nil, nil, fmt.Errorf("%w (Caddy runs as %s; set impersonate to retry as an account with access)", err, currentAccount())
+	}
+
+	if s.logger != nil {
+		s.logger.Info("access denied, retrying as the impersonated account",
+			zap.String("location", s.location),
+			zap.String("user", s.impersonate.User),
+		)
+	}
+	err = impersonateAccount(s.impersonate.User, s.impersonate.Password, func() error {
+		cert, store, identity, err = s.loadIdentity(ctx)
+		return err
+	})
 	if err != nil {
-		store.Close()
-		return cert, nil, nil, err
+		return cert, nil, nil, fmt.Errorf("retrying as %s: %w", s.impersonate.User, err)
 	}
+	return cert, store, identity, nil
+}
+
+// loadIdentity finds the matching identity and builds its certificate as
+// the account of the calling thread.
+func (s selectorSnapshot) loadIdentity(ctx context.Context) (tls.Certificate, Store, Identity, error) {
+	var cert tls.Certificate
 
-	identity, err := findMatchingIdentity(identities, s.pattern, s.field)
+	store, identity, err := s.findIdentity(ctx)
+	if errors.Is(err, errNoMatchingIdentity) && s.devCommonName != "" {
+		if err = s.generateDevIdentity(); err == nil {
+			store, identity, err = s.findIdentity(ctx)
+		}
+	}
 	if err != nil {
-		store.Close()
-		return cert, nil, nil, fmt.Errorf("%w in %s store", err, s.location)
+		store, identity, err = s.findFallbackIdentity(ctx, err)
+	}
+	if err != nil {
+		return cert, nil, nil, err
 	}
 
 	// Log the certificate details if logger is available
@@ -106,8 +854,20 @@ func (s selectorSnapshot) loadCertificateWithResources() (tls.Certificate, certs
 		}
 	}
 
-	cert, err = buildTLSCertificate(identity)
+	if err := unlockIdentity(identity, s.pin); err != nil {
+		identity.Close()
+		store.Close()
+		return cert, nil, nil, err
+	}
+	cert, err = buildTLSCertificate(identity, s.verifyChainLinkage)
 	if err != nil {
+		identity.Close()
+		store.Close()
+		err = translatePlatformError(err)
+		recordAccessDenied(s.logger, s.location, "load_key", err)
+		return cert, nil, nil, err
+	}
+	if err := s.presentChain(&cert, store); err != nil {
 		identity.Close()
 		store.Close()
 		return cert, nil, nil, err
@@ -116,10 +876,149 @@ func (s selectorSnapshot) loadCertificateWithResources() (tls.Certificate, certs
 	return cert, store, identity, nil
 }
 
+// findIdentity opens the stores of the selector's location, enumerates their
+// identities and returns the identity the selector chooses along with the
+// store it belongs to. Matching stops once ctx is done. The time taken is
+// recorded as the enumeration duration metric.
+func (s selectorSnapshot) findIdentity(ctx context.Context) (store Store, identity Identity, err error) {
+	start := time.Now()
+	budget := enumerationBudget{maxCandidates: s.maxCandidates}
+	if s.maxEnumTime > 0 {
+		budget.deadline = start.Add(s.maxEnumTime)
+	}
+	defer func() {
+		certstoreMetrics.enumerationDuration.
+			WithLabelValues(s.location, enumerationResult(err)).
+			Observe(time.Since(start).Seconds())
+	}()
+
+	stores, identities, owners, err := s.enumerateStores(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	criteria := matchCriteria{
+		pattern:        s.pattern,
+		field:          s.field,
+		thumbprint:     s.thumbprint,
+		allOf:          s.allOf,
+		anyOf:          s.anyOf,
+		template:       s.template,
+		extKeyUsages:   s.extKeyUsages,
+		ekuChaining:    s.ekuChaining,
+		keyType:        s.keyType,
+		minKeyBits:     s.minKeyBits,
+		keyProvider:    s.keyProvider,
+		keyContainer:   s.keyContainer,
+		preferHardware: s.prefer == preferHardware,
+		selection:      s.selection,
+		validAt:        start,
+		notBeforeSkew:  s.notBeforeSkew,
+		rollover:       s.rollover,
+		acceptableCAs:  s.acceptableCAs,
+		logger:         s.logger,
+	}
+	identity, err = findMatchingIdentity(ctx, identities, criteria, budget)
+	if err != nil {
+		closeStores(stores)
+		return nil, nil, fmt.Errorf("%w in %s store", err, s.location)
+	}
+
+	// Keep only the store the chosen identity belongs to.
+	store = owners[slices.Index(identities, identity)]
+	for _, other := range stores {
+		if other != store {
+			other.Close()
+		}
+	}
+
+	return store, identity, nil
+}
+
+// enumerateStores opens the stores of the selector's location and returns
+// them with the union of their identities and, for each identity, the store
+// it came from. With location "any", a store that fails to open is skipped as
+// long as another one opens.
+func (s selectorSnapshot) enumerateStores(ctx context.Context) (stores []Store, identities []Identity, owners []Store, err error) {
+	var errs []error
+	for _, location := range storeLocations(s.location) {
+		store, storeIdentities, err := openStoreIdentities(ctx, location, s.storeName)
+		if err != nil {
+			recordAccessDenied(s.logger, string(location), "open_store", err)
+			errs = append(errs, err)
+			continue
+		}
+		certstoreMetrics.storeIdentities.WithLabelValues(string(location), s.storeName).Set(float64(len(storeIdentities)))
+		stores = append(stores, store)
+		identities = append(identities, storeIdentities...)
+		for range storeIdentities {
+			owners = append(owners, store)
+		}
+	}
+
+	if len(stores) == 0 {
+		return nil, nil, nil, errors.Join(errs...)
+	}
+	if err := ctx.Err(); err != nil {
+		closeIdentities(identities)
+		closeStores(stores)
+		return nil, nil, nil, err
+	}
+	if len(errs) > 0 && s.logger != nil {
+		s.logger.Warn("skipping certificate store that failed to open", zap.Error(errors.Join(errs...)))
+	}
+	return stores, identities, owners, nil
+}
+
+// openStoreIdentities opens the store at location, or its logical store name
+// when set, and lists its identities, waiting for a slot when
+// max_concurrent_store_opens is reached.
+func openStoreIdentities(ctx context.Context, location StoreLocation, name string) (Store, []Identity, error) {
+	release, err := storeOpens.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	store, err := openCertStore(location, name)
+	if err != nil {
+		return nil, nil, translatePlatformError(err)
+	}
+	identities, err := store.Identities()
+	if err != nil {
+		store.Close()
+		return nil, nil, translatePlatformError(err)
+	}
+	return store, identities, nil
+}
+
+// closeStores releases stores that will not be used.
+func closeStores(stores []Store) {
+	for _, store := range stores {
+		store.Close()
+	}
+}
+
+// enumerationResult labels the outcome of a store enumeration for metrics.
+func enumerationResult(err error) string {
+	switch {
+	case err == nil:
+		return "matched"
+	case errors.Is(err, errNoMatchingIdentity):
+		return "no_match"
+	case errors.Is(err, errEnumerationBudgetExceeded):
+		return "budget_exceeded"
+	case isAccessDenied(err):
+		return "access_denied"
+	default:
+		return "error"
+	}
+}
+
 // loadCertificate loads a certificate from the store matching the configured name/pattern.
 // This is kept for backward compatibility but internally uses the cached version.
-func (cs *CertSelector) loadCertificate() (tls.Certificate, error) {
-	cert, cacheKey, err := cs.getCachedCertificate()
+func (cs *CertSelector) loadCertificate(ctx context.Context) (tls.Certificate, error) {
+	cert, cacheKey, err := cs.getCachedCertificate(ctx)
 	if err != nil {
 		return cert, err
 	}
@@ -0,0 +1,440 @@
+package certstore
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCertSelector_EnumerationBudget(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxCandidates  int
+		maxEnumeration time.Duration
+		matchIndex     int
+		expectErr      bool
+	}{
+		{name: "unbounded", matchIndex: 2},
+		{name: "match within max_candidates", maxCandidates: 2, matchIndex: 1},
+		{name: "match beyond max_candidates", maxCandidates: 2, matchIndex: 2, expectErr: true},
+		{name: "max_enumeration_time elapsed", maxEnumeration: time.Nanosecond, matchIndex: 0, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			identities := make([]*fakeIdentity, 3)
+			for i := range identities {
+				key := newTestKey(t)
+				commonName := "other.example.test"
+				if i == tt.matchIndex {
+					commonName = "budget.example.test"
+				}
+				identities[i] = newFakeIdentity(newTestCertificate(t, commonName, key), newFakeSigner(key.Public(), []byte("ok")))
+			}
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+			selector := newTestSelector("^budget\\.example\\.test$")
+			selector.MaxCandidates = tt.maxCandidates
+			selector.MaxEnumerationTime = caddy.Duration(tt.maxEnumeration)
+
+			_, err := selector.loadCertificate(t.Context())
+			if tt.expectErr {
+				if !errors.Is(err, errEnumerationBudgetExceeded) {
+					t.Fatalf("expected enumeration budget error, got %v", err)
+				}
+				for i, identity := range identities {
+					if identity.closeCount() != 1 {
+						t.Fatalf("expected identity %d to be closed once, got %d", i, identity.closeCount())
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			selector.release()
+
+			for i, identity := range identities {
+				if identity.closeCount() != 1 {
+					t.Fatalf("expected identity %d to be closed once, got %d", i, identity.closeCount())
+				}
+			}
+		})
+	}
+}
+
+func TestCertSelector_EnumerationBudgetPartialMatch(t *testing.T) {
+	resetCertificateCache(t)
+
+	identities := make([]*fakeIdentity, 3)
+	for i := range identities {
+		key := newTestKey(t)
+		identities[i] = newFakeIdentity(newTestCertificate(t, "budget.example.test", key), newFakeSigner(key.Public(), []byte("ok")))
+	}
+	withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	selector := newTestSelector("^budget\\.example\\.test$")
+	selector.MaxCandidates = 1
+	selector.logger = zap.New(core)
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("expected the match found within the budget, got %v", err)
+	}
+	defer selector.release()
+
+	entries := logs.FilterMessageSnippet("enumeration budget exhausted").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one warning about the partial scan, got %d", len(entries))
+	}
+	if got, _ := entries[0].ContextMap()["error"].(string); !strings.Contains(got, errEnumerationBudgetExceeded.Error()) {
+		t.Fatalf("expected the warning to carry the budget error, got %q", got)
+	}
+}
+
+func TestCertSelector_LoadCanceled(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "canceled.example.test", key)
+	load := newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("ok")))
+	load.store.entered = make(chan struct{})
+	load.store.block = make(chan struct{})
+	withFakeStoreLoads(t, load)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errs := make(chan error, 1)
+	selector := newTestSelector("^canceled\\.example\\.test$")
+	go func() {
+		_, err := selector.loadCertificate(ctx)
+		errs <- err
+	}()
+
+	// Cancel while the load is stuck inside the store call.
+	<-load.store.entered
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("load did not return after cancellation")
+	}
+
+	// Let the stuck store call return; the abandoned load must not leak handles.
+	close(load.store.block)
+	deadline := time.Now().Add(5 * time.Second)
+	for load.identity.closeCount() != 1 || load.store.closeCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected abandoned resources to be closed, got identity=%d store=%d", load.identity.closeCount(), load.store.closeCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if infos := defaultApp.cache.info(); len(infos) != 0 {
+		t.Fatalf("expected canceled load not to be cached, got %d entries", len(infos))
+	}
+}
+
+func TestCertSelector_PrefersValidCertificates(t *testing.T) {
+	ca := newTestCA(t, "Validity CA")
+	now := time.Now()
+	expired := &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}
+	fresh := &x509.Certificate{NotBefore: now.Add(time.Minute), NotAfter: now.Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		skew     caddy.Duration
+		expected int
+	}{
+		{name: "fresh certificate within default skew", expected: 0},
+		{name: "fresh certificate beyond skew ties on serial", skew: caddy.Duration(30 * time.Second), expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			var identities []*fakeIdentity
+			for _, validity := range []*x509.Certificate{fresh, expired} {
+				key := newTestKey(t)
+				cert := ca.issue(t, &x509.Certificate{
+					Subject:   pkix.Name{CommonName: "validity.example.test"},
+					NotBefore: validity.NotBefore,
+					NotAfter:  validity.NotAfter,
+				}, key.Public())
+				identities = append(identities, newFakeIdentity(cert, newFakeSigner(key.Public(), []byte("ok"))))
+			}
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(identities...))
+
+			selector := newTestSelector("^validity\\.example\\.test$")
+			selector.NotBeforeSkew = tt.skew
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+
+			if !cert.Leaf.Equal(identities[tt.expected].cert) {
+				t.Fatalf("expected identity %d to be selected", tt.expected)
+			}
+		})
+	}
+}
+
+func TestCertSelector_PreferHardware(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefer   string
+		hardware []bool
+		expected int
+	}{
+		{name: "first match without preference", hardware: []bool{false, true, false}, expected: 0},
+		{name: "hardware match preferred", prefer: preferHardware, hardware: []bool{false, true, false}, expected: 1},
+		{name: "first match when none is hardware", prefer: preferHardware, hardware: []bool{false, false, false}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			key := newTestKey(t)
+			cert := newTestCertificate(t, "prefer.example.test", key)
+			identities := make([]*fakeIdentity, len(tt.hardware))
+			for i, hardware := range tt.hardware {
+				identities[i] = newFakeIdentity(cert, newFakeSigner(key.Public(), []byte("ok")))
+				identities[i].hardware = hardware
+			}
+			load := newFakeStoreLoadWithIdentities(identities...)
+			withFakeStoreLoads(t, load)
+
+			selector := newTestSelector("^prefer\\.example\\.test$")
+			selector.Prefer = tt.prefer
+			if _, err := selector.loadCertificate(t.Context()); err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+
+			for i, identity := range identities {
+				expectedCloses := int32(1)
+				if i == tt.expected {
+					expectedCloses = 0
+				}
+				if identity.closeCount() != expectedCloses {
+					t.Fatalf("expected identity %d to be closed %d times, got %d", i, expectedCloses, identity.closeCount())
+				}
+			}
+			selector.release()
+		})
+	}
+}
+
+func TestCertSelector_TieBreakBySerial(t *testing.T) {
+	ca := newTestCA(t, "Tie CA")
+	var identities []*fakeIdentity
+	for range 3 {
+		key := newTestKey(t)
+		cert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "tie.example.test"}}, key.Public())
+		identities = append(identities, newFakeIdentity(cert, newFakeSigner(key.Public(), []byte("ok"))))
+	}
+	highest := identities[2].cert
+
+	for _, order := range [][]int{{0, 1, 2}, {2, 0, 1}, {1, 2, 0}} {
+		resetCertificateCache(t)
+
+		var enumerated []*fakeIdentity
+		for _, i := range order {
+			enumerated = append(enumerated, newFakeIdentity(identities[i].cert, identities[i].signer))
+		}
+		withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(enumerated...))
+
+		selector := newTestSelector("^tie\\.example\\.test$")
+		cert, err := selector.loadCertificate(t.Context())
+		if err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+		if !cert.Leaf.Equal(highest) {
+			t.Fatalf("expected the highest serial to be selected for order %v, got serial %s", order, cert.Leaf.SerialNumber)
+		}
+		selector.release()
+	}
+}
+
+func TestCertSelector_LocationAny(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("location any opens a single keychain store on macOS")
+	}
+	resetCertificateCache(t)
+
+	userKey := newTestKey(t)
+	machineKey := newTestKey(t)
+	userLoad := newFakeStoreLoad(newTestCertificate(t, "user.example.test", userKey), newFakeSigner(userKey.Public(), []byte("user")))
+	machineLoad := newFakeStoreLoad(newTestCertificate(t, "machine.example.test", machineKey), newFakeSigner(machineKey.Public(), []byte("machine")))
+	provider := withFakeStoreLoads(t, userLoad, machineLoad)
+
+	selector := newTestSelector("^machine\\.example\\.test$")
+	selector.Location = "any"
+	cert, err := selector.loadCertificate(t.Context())
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cert.Leaf.Subject.CommonName != "machine.example.test" {
+		t.Fatalf("expected certificate from the machine store, got %q", cert.Leaf.Subject.CommonName)
+	}
+	if provider.openCount() != 2 {
+		t.Fatalf("expected both stores to be opened, got %d opens", provider.openCount())
+	}
+	if userLoad.store.closeCount() != 1 || userLoad.identity.closeCount() != 1 {
+		t.Fatal("expected the store without the chosen identity to be closed")
+	}
+	if machineLoad.store.closeCount() != 0 {
+		t.Fatal("expected the store of the chosen identity to stay open")
+	}
+
+	selector.release()
+	if machineLoad.store.closeCount() != 1 || machineLoad.identity.closeCount() != 1 {
+		t.Fatal("expected the chosen identity and its store to be closed on release")
+	}
+}
+
+// namedStoreBackend opens load for any logical store, recording the names.
+type namedStoreBackend struct {
+	load  *fakeStoreLoad
+	names []string
+}
+
+func (b *namedStoreBackend) OpenStore(StoreLocation) (Store, error) {
+	return nil, errors.New("unexpected open of the default store")
+}
+
+func (b *namedStoreBackend) OpenNamedStore(location StoreLocation, name string) (Store, error) {
+	b.names = append(b.names, string(location)+`\`+name)
+	return b.load.store, nil
+}
+
+func TestCertSelector_StoreName(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	backend := &namedStoreBackend{load: newFakeStoreLoad(newTestCertificate(t, "web.example.test", key), newFakeSigner(key.Public(), []byte("ok")))}
+	t.Cleanup(SetBackend(backend))
+
+	selector := newTestSelector("^web\\.example\\.test$")
+	selector.StoreName = "WebHosting"
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if len(backend.names) != 1 || backend.names[0] != `user\WebHosting` {
+		t.Fatalf("expected the WebHosting store of the user location to be opened, got %v", backend.names)
+	}
+}
+
+func TestCertSelector_StoreNameUnsupportedBackend(t *testing.T) {
+	resetCertificateCache(t)
+	withFakeStoreLoads(t)
+
+	selector := newTestSelector("^web\\.example\\.test$")
+	selector.StoreName = "WebHosting"
+	_, err := selector.loadCertificate(t.Context())
+	assertErrorContains(t, err, "cannot open logical store 'WebHosting'")
+}
+
+func TestCertSelector_Keychain(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	backend := &namedStoreBackend{load: newFakeStoreLoad(newTestCertificate(t, "kc.example.test", key), newFakeSigner(key.Public(), []byte("ok")))}
+	t.Cleanup(SetBackend(backend))
+
+	selector := newTestSelector("^kc\\.example\\.test$")
+	selector.Keychain = "/Library/Keychains/caddy.keychain-db"
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if len(backend.names) != 1 || backend.names[0] != `user\/Library/Keychains/caddy.keychain-db` {
+		t.Fatalf("expected the keychain file to be opened, got %v", backend.names)
+	}
+
+	selector.StoreName = "WebHosting"
+	assertErrorContains(t, selector.validate(), "store_name and keychain are mutually exclusive")
+}
+
+func TestCertSelector_NSSDatabasePath(t *testing.T) {
+	resetCertificateCache(t)
+
+	key := newTestKey(t)
+	backend := &namedStoreBackend{load: newFakeStoreLoad(newTestCertificate(t, "nss.example.test", key), newFakeSigner(key.Public(), []byte("ok")))}
+	t.Cleanup(SetBackend(backend))
+
+	selector := newTestSelector("^nss\\.example\\.test$")
+	selector.Location, selector.NSSDatabase = "nssdb", "/etc/pki/nssdb"
+	if err := selector.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	if len(backend.names) != 1 || backend.names[0] != `nssdb\/etc/pki/nssdb` {
+		t.Fatalf("expected the NSS database to be opened, got %v", backend.names)
+	}
+
+	selector.StoreName = "WebHosting"
+	assertErrorContains(t, selector.validate(), "cannot be used with location 'nssdb'")
+
+	selector.Location, selector.StoreName = "user", ""
+	assertErrorContains(t, selector.validate(), "nss_database requires location 'nssdb'")
+}
+
+func TestCertSelector_SkipsInaccessibleKey(t *testing.T) {
+	key := newTestKey(t)
+	accessible := newFakeIdentity(newTestCertificate(t, "acl.example.test", key), newFakeSigner(key.Public(), []byte("ok")))
+	// The denied identity has the higher serial number and would be chosen
+	// if its key were not probed.
+	denied := newFakeIdentity(newTestCertificate(t, "acl.example.test", key), nil)
+	denied.signerErr = errors.New("access denied")
+
+	t.Run("next match is used", func(t *testing.T) {
+		resetCertificateCache(t)
+		withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(accessible, denied))
+
+		selector := newTestSelector("^acl\\.example\\.test$")
+		cert, err := selector.loadCertificate(t.Context())
+		if err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+		defer selector.release()
+		if cert.Leaf != accessible.cert {
+			t.Fatalf("expected the identity with an accessible key, got serial %s", cert.Leaf.SerialNumber)
+		}
+		if denied.closeCount() != 1 {
+			t.Fatal("expected the skipped identity to be closed")
+		}
+	})
+
+	t.Run("no accessible match", func(t *testing.T) {
+		resetCertificateCache(t)
+		withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(denied))
+
+		selector := newTestSelector("^acl\\.example\\.test$")
+		_, err := selector.loadCertificate(t.Context())
+		if !errors.Is(err, errNoMatchingIdentity) {
+			t.Fatalf("expected no matching identity, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package certstore
+
+import (
+	"cmp"
+	"regexp"
+	"strings"
+)
+
+// pastedSerial matches a serial number as certutil, the certificates MMC
+// snap-in, Keychain Access and openssl display it: hex bytes in either case,
+// optionally separated by spaces or colons.
+var pastedSerial = regexp.MustCompile(`^[0-9A-Fa-f]{2}([ :]?[0-9A-Fa-f]{2})*$`)
+
+// compileFieldPattern compiles a pattern matched against field. A serial
+// number pasted in hex form is rewritten to the canonical hex form the
+// "serial" field is also matched against, anchored in regex mode since it is
+// a complete serial number. Decimal digits alone keep matching the decimal
+// form.
+func compileFieldPattern(field, pattern, mode string) (*regexp.Regexp, error) {
+	if field != "serial" || !pastedSerial.MatchString(pattern) || !strings.ContainsAny(pattern, "abcdefABCDEF :") {
+		return compilePattern(pattern, mode)
+	}
+	hex := strings.ToLower(strings.NewReplacer(" ", "", ":", "").Replace(pattern))
+	hex = cmp.Or(strings.TrimLeft(hex, "0"), "0")
+	if cmp.Or(mode, matchRegex) == matchRegex {
+		hex = "^" + hex + "$"
+	}
+	return compilePattern(hex, mode)
+}
@@ -0,0 +1,45 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+)
+
+func TestFieldMatches_Serial(t *testing.T) {
+	serial, _ := new(big.Int).SetString("ab12cd34ef", 16)
+	cert := &x509.Certificate{SerialNumber: serial}
+
+	tests := []struct {
+		mode, pattern string
+		want          bool
+	}{
+		{"", "ab 12 cd 34 ef", true},
+		{"", "00:AB:12:CD:34:EF", true},
+		{"", "AB12CD34EF", true},
+		{"exact", "ab:12:cd:34:ef", true},
+		{"exact", serial.String(), true},
+		{"", "^" + serial.String() + "$", true},
+		{"", "12 cd 34", false},
+		{"exact", "ab:12:cd:34", false},
+	}
+	for _, tt := range tests {
+		pattern, err := compileFieldPattern("serial", tt.pattern, tt.mode)
+		if err != nil {
+			t.Fatalf("compileFieldPattern(%q) failed: %v", tt.pattern, err)
+		}
+		if got := fieldMatches("serial", pattern, cert); got != tt.want {
+			t.Errorf("%s pattern %q: expected %t, got %t", tt.mode, tt.pattern, tt.want, got)
+		}
+	}
+}
+
+func TestCompileFieldPattern_OtherFields(t *testing.T) {
+	pattern, err := compileFieldPattern("subject", "AB 12", "")
+	if err != nil {
+		t.Fatalf("compileFieldPattern failed: %v", err)
+	}
+	if pattern.String() != "AB 12" {
+		t.Fatalf("expected patterns of other fields to be left as is, got %q", pattern)
+	}
+}
@@ -0,0 +1,72 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// rotatingSessionCache wraps the transport's TLS client session cache so
+// that sessions established with a previous client certificate are not
+// resumed: when the certificate of any of the transport's selectors changes,
+// the cache is replaced by an empty one. tls.ClientSessionCache cannot be
+// cleared, so the replacement is an LRU cache of the default capacity.
+type rotatingSessionCache struct {
+	selectors []*CertSelector
+
+	mu         sync.Mutex
+	generation uint64
+	cache      tls.ClientSessionCache
+}
+
+func newRotatingSessionCache(selectors []*CertSelector, cache tls.ClientSessionCache) *rotatingSessionCache {
+	c := &rotatingSessionCache{
+		selectors: selectors,
+		cache:     cache,
+	}
+	c.generation = c.certificateGeneration()
+	return c
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *rotatingSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return c.current().Get(sessionKey)
+}
+
+// Put implements tls.ClientSessionCache.
+func (c *rotatingSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.current().Put(sessionKey, cs)
+}
+
+// certificateGeneration changes whenever the certificate of one of the
+// selectors is replaced, since each selector's generation only grows.
+func (c *rotatingSessionCache) certificateGeneration() uint64 {
+	var generation uint64
+	for _, selector := range c.selectors {
+		generation += selector.certificateGeneration()
+	}
+	return generation
+}
+
+// current returns the session cache for the selectors' current certificates.
+func (c *rotatingSessionCache) current() tls.ClientSessionCache {
+	generation := c.certificateGeneration()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		c.generation = generation
+		c.cache = tls.NewLRUClientSessionCache(0)
+		if logger := c.selectors[0].logger; logger != nil {
+			logger.Info("cleared TLS session cache after client certificate rotation",
+				zap.Uint64("generation", generation),
+			)
+		}
+	}
+	return c.cache
+}
+
+// Interface guards
+var _ tls.ClientSessionCache = (*rotatingSessionCache)(nil)
@@ -0,0 +1,42 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestRotatingSessionCache_ClearsOnRotation(t *testing.T) {
+	resetCertificateCache(t)
+
+	oldKey := newTestKey(t)
+	newKey := newTestKey(t)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(newTestCertificate(t, "session.example.test", oldKey), newFakeSigner(oldKey.Public(), []byte("old"))),
+		newFakeStoreLoad(newTestCertificate(t, "session.example.test", newKey), newFakeSigner(newKey.Public(), []byte("new"))),
+	)
+
+	selector := newTestSelector("^session\\.example\\.test$")
+	if _, err := selector.loadCertificate(t.Context()); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	defer selector.release()
+
+	cache := newRotatingSessionCache([]*CertSelector{selector}, tls.NewLRUClientSessionCache(0))
+	cache.Put("upstream", new(tls.ClientSessionState))
+	if _, ok := cache.Get("upstream"); !ok {
+		t.Fatal("expected the session to be cached before rotation")
+	}
+
+	changed, err := selector.cacheEntry.reselect(t.Context())
+	if err != nil || !changed {
+		t.Fatalf("expected re-selection to rotate the certificate, changed=%v err=%v", changed, err)
+	}
+	if _, ok := cache.Get("upstream"); ok {
+		t.Fatal("expected the session cache to be cleared after rotation")
+	}
+
+	cache.Put("upstream", new(tls.ClientSessionState))
+	if _, ok := cache.Get("upstream"); !ok {
+		t.Fatal("expected sessions of the new certificate to be cached")
+	}
+}
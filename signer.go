@@ -0,0 +1,89 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"io"
+)
+
+// SignerProvider is implemented by the certstore app. Other Caddy modules use
+// it to reuse the store-backed identity of a named selector, for example to
+// sign JWTs or to authenticate webhooks with mTLS:
+//
+//	appIface, err := ctx.App("certstore")
+//	...
+//	signer, err := appIface.(certstore.SignerProvider).Signer("banking")
+//
+// The app must be configured for its selectors to exist; the caller's
+// module should load it during provisioning so it is started first.
+type SignerProvider interface {
+	// Certificate returns the current certificate of the named selector.
+	// Its PrivateKey is a crypto.Signer backed by the OS store.
+	Certificate(name string) (*tls.Certificate, error)
+
+	// Signer returns a crypto.Signer for the private key of the named
+	// selector. It follows the selector across certificate rotations, so
+	// its public key changes when the certificate does.
+	Signer(name string) (crypto.Signer, error)
+}
+
+// Certificate implements SignerProvider.
+func (a *App) Certificate(name string) (*tls.Certificate, error) {
+	selector, err := a.selector(name)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := selector.currentCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Signer implements SignerProvider.
+func (a *App) Signer(name string) (crypto.Signer, error) {
+	selector, err := a.selector(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := selector.currentCertificate(); err != nil {
+		return nil, err
+	}
+	return selectorSigner{selector: selector}, nil
+}
+
+// selectorSigner signs with the current certificate of a selector.
+type selectorSigner struct {
+	selector *CertSelector
+}
+
+// Public returns the public key of the selector's current certificate, or
+// nil when the certificate is unavailable.
+func (s selectorSigner) Public() crypto.PublicKey {
+	cert, err := s.selector.currentCertificate()
+	if err != nil {
+		return nil
+	}
+	return cert.Leaf.PublicKey
+}
+
+// Sign signs digest with the private key of the selector's current
+// certificate.
+func (s selectorSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	cert, err := s.selector.currentCertificate()
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("certificate private key is not a crypto.Signer")
+	}
+	return signer.Sign(rand, digest, opts)
+}
+
+// Interface guards
+var (
+	_ SignerProvider = (*App)(nil)
+	_ crypto.Signer  = selectorSigner{}
+)
@@ -0,0 +1,58 @@
+package certstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestApp_SignerProvider(t *testing.T) {
+	key := newTestKey(t)
+	cert := newTestCertificate(t, "signer.example.test", key)
+	withFakeStoreLoads(t, newFakeStoreLoad(cert, newFakeSigner(key.Public(), []byte("signature"))))
+
+	app := &App{
+		Selectors: map[string]*CertSelector{
+			"webhooks": {Pattern: "^signer\\.example\\.test$", Location: "user"},
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer func() {
+		if err := app.Cleanup(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	var provider SignerProvider = app
+	current, err := provider.Certificate("webhooks")
+	if err != nil {
+		t.Fatalf("Certificate failed: %v", err)
+	}
+	if !current.Leaf.Equal(cert) {
+		t.Fatal("expected the selector's certificate")
+	}
+
+	signer, err := provider.Signer("webhooks")
+	if err != nil {
+		t.Fatalf("Signer failed: %v", err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("expected the signer to expose the certificate's public key")
+	}
+	sig, err := signer.Sign(rand.Reader, make([]byte, 32), crypto.SHA256)
+	if err != nil || string(sig) != "signature" {
+		t.Fatalf("expected the store-backed signature, got %q, %v", sig, err)
+	}
+
+	if _, err := provider.Signer("missing"); err == nil {
+		t.Fatal("expected error for unknown selector name")
+	}
+}
@@ -0,0 +1,51 @@
+package certstore
+
+import (
+	"context"
+	"sync"
+)
+
+// storeOpens bounds how many stores are opened and enumerated at once. Some
+// smart card middleware fails sporadically when dozens of transports
+// provisioned together open the keychain or CAPI stores in parallel.
+var storeOpens storeOpenLimiter
+
+// storeOpenLimiter is a semaphore whose size can change between configs. A
+// zero value places no limit.
+type storeOpenLimiter struct {
+	mu    sync.Mutex
+	slots chan struct{}
+}
+
+// setLimit sets the number of concurrent store opens, 0 meaning unlimited.
+// Opens already holding a slot of the previous limit release it there.
+func (l *storeOpenLimiter) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n <= 0 {
+		l.slots = nil
+		return
+	}
+	if cap(l.slots) != n {
+		l.slots = make(chan struct{}, n)
+	}
+}
+
+// acquire waits for a slot and returns the function releasing it. It returns
+// ctx's error if ctx is done first.
+func (l *storeOpenLimiter) acquire(ctx context.Context) (release func(), err error) {
+	l.mu.Lock()
+	slots := l.slots
+	l.mu.Unlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package certstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreOpenLimiter(t *testing.T) {
+	var limiter storeOpenLimiter
+	limiter.setLimit(1)
+
+	release, err := limiter.acquire(t.Context())
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		second, err := limiter.acquire(context.Background())
+		if err == nil {
+			acquired <- second
+		}
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the second open to wait for a slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := limiter.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled wait to fail, got %v", err)
+	}
+
+	release()
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second open to acquire the released slot")
+	}
+
+	limiter.setLimit(0)
+	for range 3 {
+		if _, err := limiter.acquire(ctx); err != nil {
+			t.Fatalf("expected no limit, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strings"
+)
+
+var (
+	// oidExtensionTemplateName identifies the certificate type extension of
+	// version 1 templates, holding the template name.
+	oidExtensionTemplateName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2}
+
+	// oidExtensionTemplate identifies the certificate template extension of
+	// version 2 and later templates, holding the template OID.
+	oidExtensionTemplate = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 7}
+)
+
+// certificateTemplate is the value of the certificate template extension.
+type certificateTemplate struct {
+	ID           asn1.ObjectIdentifier
+	MajorVersion int `asn1:"optional"`
+	MinorVersion int `asn1:"optional"`
+}
+
+// templateMatcher matches the Active Directory Certificate Services template
+// a certificate was issued from, by OID or by name. The zero value matches
+// every certificate.
+type templateMatcher struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}
+
+// newTemplateMatcher matches template, a dotted OID or a template name.
+func newTemplateMatcher(template string) templateMatcher {
+	if oid, ok := parseDottedOID(template); ok {
+		return templateMatcher{oid: oid}
+	}
+	return templateMatcher{name: template}
+}
+
+// matches reports whether the certificate was issued from the template.
+// Certificates only carry the name of version 1 templates, such as "User"
+// or "Machine"; those of later versions are identified by OID.
+func (m templateMatcher) matches(cert *x509.Certificate) bool {
+	switch {
+	case m.oid != nil:
+		return certificateTemplateOID(cert).Equal(m.oid)
+	case m.name != "":
+		return strings.EqualFold(certificateTemplateName(cert), m.name)
+	default:
+		return true
+	}
+}
+
+func (m templateMatcher) String() string {
+	if m.oid != nil {
+		return m.oid.String()
+	}
+	return m.name
+}
+
+// certificateTemplateOID returns the OID of the certificate's template, or
+// nil if it has no certificate template extension.
+func certificateTemplateOID(cert *x509.Certificate) asn1.ObjectIdentifier {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionTemplate) {
+			continue
+		}
+		var template certificateTemplate
+		if _, err := asn1.Unmarshal(ext.Value, &template); err != nil {
+			return nil
+		}
+		return template.ID
+	}
+	return nil
+}
+
+// certificateTemplateName returns the template name of the certificate's
+// certificate type extension, or "" if it has none.
+func certificateTemplateName(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionTemplateName) {
+			continue
+		}
+		var name string
+		if _, err := asn1.Unmarshal(ext.Value, &name); err != nil {
+			return ""
+		}
+		return name
+	}
+	return ""
+}
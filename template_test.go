@@ -0,0 +1,61 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestCertSelector_Template(t *testing.T) {
+	ca := newTestCA(t, "Template CA")
+	key := newTestKey(t)
+	workstationAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1234, 5678}
+	webServer := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 21, 8, 1234, 9999}
+
+	issue := func(ext pkix.Extension) *x509.Certificate {
+		return ca.issue(t, &x509.Certificate{
+			Subject:         pkix.Name{CommonName: "host.example.test"},
+			ExtraExtensions: []pkix.Extension{ext},
+		}, key.Public())
+	}
+	templateExtension := func(oid asn1.ObjectIdentifier) pkix.Extension {
+		value, err := asn1.Marshal(certificateTemplate{ID: oid, MajorVersion: 100, MinorVersion: 3})
+		if err != nil {
+			t.Fatalf("marshal template: %v", err)
+		}
+		return pkix.Extension{Id: oidExtensionTemplate, Value: value}
+	}
+	// Version 1 templates store their name as a BMPString.
+	machineName := []byte{0x1e, 0x0e, 0, 'M', 0, 'a', 0, 'c', 0, 'h', 0, 'i', 0, 'n', 0, 'e'}
+
+	workstationCert := issue(templateExtension(workstationAuth))
+	machineCert := issue(pkix.Extension{Id: oidExtensionTemplateName, Value: machineName})
+	webServerCert := issue(templateExtension(webServer))
+
+	tests := map[string]*x509.Certificate{
+		"1.3.6.1.4.1.311.21.8.1234.5678": workstationCert,
+		"machine":                        machineCert,
+	}
+	for template, want := range tests {
+		t.Run(template, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(
+				newFakeIdentity(workstationCert, newFakeSigner(key.Public(), []byte("ok"))),
+				newFakeIdentity(machineCert, newFakeSigner(key.Public(), []byte("ok"))),
+				newFakeIdentity(webServerCert, newFakeSigner(key.Public(), []byte("ok"))),
+			))
+
+			selector := newTestSelector("")
+			selector.template = newTemplateMatcher(template)
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.SerialNumber.Cmp(want.SerialNumber) != 0 {
+				t.Fatalf("expected serial %s, got %s", want.SerialNumber, cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // SHA-1 thumbprints identify certificates, as in certmgr.msc
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseThumbprint decodes a hex SHA-1 or SHA-256 certificate thumbprint.
+// Spaces and colons are ignored, and so are the invisible direction marks
+// certmgr.msc puts in front of the thumbprint it shows, so that it can be
+// copied from there or from openssl output as is.
+func parseThumbprint(thumbprint string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ':', '\u200e', '\u200f':
+			return -1
+		}
+		return r
+	}, thumbprint)
+
+	digest, err := hex.DecodeString(cleaned)
+	if err != nil || len(digest) != sha1.Size && len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid thumbprint '%s': must be a hex SHA-1 or SHA-256 digest", thumbprint)
+	}
+	return digest, nil
+}
+
+// thumbprintMatches reports whether the certificate has the thumbprint,
+// whose length tells whether it is a SHA-1 or SHA-256 digest. An empty
+// thumbprint matches every certificate.
+func thumbprintMatches(cert *x509.Certificate, thumbprint []byte) bool {
+	switch len(thumbprint) {
+	case 0:
+		return true
+	case sha1.Size:
+		digest := sha1.Sum(cert.Raw) //nolint:gosec // see import
+		return bytes.Equal(digest[:], thumbprint)
+	default:
+		digest := sha256.Sum256(cert.Raw)
+		return bytes.Equal(digest[:], thumbprint)
+	}
+}
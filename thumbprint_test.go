@@ -0,0 +1,70 @@
+package certstore
+
+import (
+	"crypto/sha1" //nolint:gosec // SHA-1 thumbprints identify certificates
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseThumbprint(t *testing.T) {
+	tests := map[string]int{
+		"0123456789abcdef0123456789abcdef01234567":                         sha1.Size,
+		"01 23 45 67 89 AB CD EF 01 23 45 67 89 AB CD EF 01 23 45 67":      sha1.Size,
+		"\u200e0123456789abcdef0123456789abcdef01234567":                   sha1.Size,
+		strings.Repeat("01:", 31) + "01":                                   sha256.Size,
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef": sha256.Size,
+	}
+	for input, size := range tests {
+		digest, err := parseThumbprint(input)
+		if err != nil {
+			t.Fatalf("parseThumbprint(%q) failed: %v", input, err)
+		}
+		if len(digest) != size {
+			t.Errorf("parseThumbprint(%q): expected %d bytes, got %d", input, size, len(digest))
+		}
+	}
+
+	for _, input := range []string{"", "0123", "xyz", strings.Repeat("ab", 48)} {
+		_, err := parseThumbprint(input)
+		assertErrorContains(t, err, "invalid thumbprint")
+	}
+}
+
+func TestCertSelector_Thumbprint(t *testing.T) {
+	key := newTestKey(t)
+	older := newTestCertificate(t, "renewed.example.test", key)
+	newer := newTestCertificate(t, "renewed.example.test", key)
+	sha1Digest := sha1.Sum(older.Raw) //nolint:gosec // see import
+	sha256Digest := sha256.Sum256(older.Raw)
+
+	for name, thumbprint := range map[string]string{
+		"sha1":   hex.EncodeToString(sha1Digest[:]),
+		"sha256": hex.EncodeToString(sha256Digest[:]),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resetCertificateCache(t)
+			withFakeStoreLoads(t, newFakeStoreLoadWithIdentities(
+				newFakeIdentity(older, newFakeSigner(key.Public(), []byte("older"))),
+				newFakeIdentity(newer, newFakeSigner(key.Public(), []byte("newer"))),
+			))
+
+			// Without the pin the higher serial number, the newer
+			// certificate, would be chosen.
+			selector := newTestSelector("")
+			var err error
+			if selector.thumbprint, err = parseThumbprint(thumbprint); err != nil {
+				t.Fatalf("parseThumbprint failed: %v", err)
+			}
+			cert, err := selector.loadCertificate(t.Context())
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			defer selector.release()
+			if cert.Leaf.SerialNumber.Cmp(older.SerialNumber) != 0 {
+				t.Fatalf("expected the pinned certificate, got serial %s", cert.Leaf.SerialNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// trustOS is the Trust value verifying upstream servers against the OS
+// trust store.
+const trustOS = "os"
+
+// systemCertPool returns the OS trust store. Tests replace it.
+var systemCertPool = x509.SystemCertPool
+
+// provisionTrust checks the trust setting and, for "os", makes the transport
+// verify upstream servers against the OS trust store.
+func (h *HTTPTransport) provisionTrust() error {
+	switch h.Trust {
+	case "":
+		return nil
+	case trustOS:
+	default:
+		return fmt.Errorf("unsupported trust value '%s': must be '%s'", h.Trust, trustOS)
+	}
+	if t := h.HTTPTransport.TLS; t != nil {
+		if len(t.CARaw) > 0 || len(t.RootCAPool) > 0 || len(t.RootCAPEMFiles) > 0 {
+			return fmt.Errorf("trust '%s' cannot be combined with the tls ca, root_ca_pool or root_ca_pem_files options", trustOS)
+		}
+		if t.InsecureSkipVerify {
+			return fmt.Errorf("trust '%s' cannot be combined with tls insecure_skip_verify", trustOS)
+		}
+	}
+
+	pool, err := systemCertPool()
+	if err != nil {
+		return fmt.Errorf("loading the OS trust store: %w", err)
+	}
+	h.rootCAs = pool
+	if h.Transport.TLSClientConfig == nil {
+		h.Transport.TLSClientConfig = new(tls.Config)
+	}
+	h.applyTrust(h.Transport.TLSClientConfig)
+	return nil
+}
+
+// applyTrust makes cfg verify upstream servers against the roots of the
+// trust setting, if any.
+func (h *HTTPTransport) applyTrust(cfg *tls.Config) {
+	if h.rootCAs != nil {
+		cfg.RootCAs = h.rootCAs
+	}
+}
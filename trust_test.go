@@ -0,0 +1,82 @@
+package certstore
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+func withSystemCertPool(t *testing.T, pool *x509.CertPool) {
+	t.Helper()
+
+	old := systemCertPool
+	systemCertPool = func() (*x509.CertPool, error) { return pool, nil }
+	t.Cleanup(func() { systemCertPool = old })
+}
+
+func TestHTTPTransport_TrustOS(t *testing.T) {
+	pool := x509.NewCertPool()
+	pool.AddCert(newTestCA(t, "Internal Root").cert)
+	withSystemCertPool(t, pool)
+
+	h := &HTTPTransport{
+		HTTPTransport:           &reverseproxy.HTTPTransport{},
+		Trust:                   "os",
+		HealthCheckNoClientCert: true,
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer func() {
+		if err := h.Cleanup(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	if h.Transport.TLSClientConfig == nil || h.Transport.TLSClientConfig.RootCAs != pool {
+		t.Fatal("expected the transport to verify upstreams against the OS trust store")
+	}
+	if h.healthCheckTransport.TLSClientConfig.RootCAs != pool {
+		t.Fatal("expected health checks to verify upstreams against the OS trust store")
+	}
+}
+
+func TestHTTPTransport_TrustErrors(t *testing.T) {
+	withSystemCertPool(t, x509.NewCertPool())
+
+	tests := map[string]struct {
+		transport *HTTPTransport
+		want      string
+	}{
+		"unknown value": {
+			transport: &HTTPTransport{HTTPTransport: &reverseproxy.HTTPTransport{}, Trust: "bundle"},
+			want:      "unsupported trust value",
+		},
+		"with a CA pool": {
+			transport: &HTTPTransport{
+				HTTPTransport: &reverseproxy.HTTPTransport{TLS: &reverseproxy.TLSConfig{RootCAPEMFiles: []string{"ca.pem"}}},
+				Trust:         "os",
+			},
+			want: "cannot be combined with the tls ca",
+		},
+		"with insecure_skip_verify": {
+			transport: &HTTPTransport{
+				HTTPTransport: &reverseproxy.HTTPTransport{TLS: &reverseproxy.TLSConfig{InsecureSkipVerify: true}},
+				Trust:         "os",
+			},
+			want: "insecure_skip_verify",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertErrorContains(t, tt.transport.provisionTrust(), tt.want)
+		})
+	}
+}
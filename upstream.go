@@ -0,0 +1,139 @@
+package certstore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+// upstreamSelector is a provisioned entry of UpstreamClientCerts.
+type upstreamSelector struct {
+	pattern  string
+	selector *CertSelector
+}
+
+// validateUpstreamPatterns checks the syntax of the upstream patterns of the
+// named option.
+func validateUpstreamPatterns(option string, patterns []string) error {
+	for _, pattern := range patterns {
+		host, _ := splitUpstreamPattern(pattern)
+		if _, err := path.Match(host, ""); err != nil {
+			return fmt.Errorf("invalid %s pattern '%s': %w", option, pattern, err)
+		}
+	}
+	return nil
+}
+
+// provisionUpstreamSelectors provisions the selectors of UpstreamClientCerts
+// and orders them from the most specific pattern to the least.
+func (h *HTTPTransport) provisionUpstreamSelectors(ctx caddy.Context) error {
+	patterns := slices.SortedFunc(maps.Keys(h.UpstreamClientCerts), compareUpstreamPatterns)
+	if err := validateUpstreamPatterns("upstream_client_certificates", patterns); err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		inline := h.UpstreamClientCerts[pattern]
+		if inline == nil {
+			return fmt.Errorf("upstream_client_certificates '%s': selector is required", pattern)
+		}
+		selector, err := resolveSelector(ctx, inline, "")
+		if err != nil {
+			return fmt.Errorf("upstream_client_certificates '%s': %w", pattern, err)
+		}
+		h.upstreamSelectors = append(h.upstreamSelectors, upstreamSelector{pattern: pattern, selector: selector})
+	}
+	return nil
+}
+
+// upstreamSelector returns the selector of the most specific
+// UpstreamClientCerts pattern matching the upstream being dialed with ctx,
+// or nil when none matches or the upstream is unknown.
+func (h *HTTPTransport) upstreamSelector(ctx context.Context) *CertSelector {
+	if len(h.upstreamSelectors) == 0 || ctx == nil {
+		return nil
+	}
+	dialInfo, ok := reverseproxy.GetDialInfo(ctx)
+	if !ok {
+		return nil
+	}
+	for _, upstream := range h.upstreamSelectors {
+		if matchUpstream(upstream.pattern, dialInfo) {
+			return upstream.selector
+		}
+	}
+	return nil
+}
+
+// compareUpstreamPatterns orders upstream patterns from the most specific to
+// the least: an exact host before a glob, a pattern with a port before one
+// without, a longer pattern before a shorter one, and then alphabetically.
+func compareUpstreamPatterns(a, b string) int {
+	return cmp.Or(
+		cmp.Compare(upstreamSpecificity(b), upstreamSpecificity(a)),
+		cmp.Compare(len(b), len(a)),
+		strings.Compare(a, b),
+	)
+}
+
+// upstreamSpecificity scores how narrowly pattern matches upstreams.
+func upstreamSpecificity(pattern string) int {
+	host, port := splitUpstreamPattern(pattern)
+	score := 0
+	if !strings.ContainsAny(host, `*?[\`) {
+		score += 2
+	}
+	if port != "" && port != "*" {
+		score++
+	}
+	return score
+}
+
+// presentsClientCertificate reports whether the client certificate is
+// presented on the connection being dialed with ctx. Without upstream
+// patterns it always is; otherwise the upstream being dialed must match one
+// of them, and connections whose upstream is unknown go without client auth.
+func (h *HTTPTransport) presentsClientCertificate(ctx context.Context) bool {
+	if len(h.ClientCertUpstreams) == 0 {
+		return true
+	}
+	if ctx == nil {
+		return false
+	}
+	dialInfo, ok := reverseproxy.GetDialInfo(ctx)
+	if !ok {
+		return false
+	}
+	for _, pattern := range h.ClientCertUpstreams {
+		if matchUpstream(pattern, dialInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUpstream reports whether the dialed upstream matches pattern, a host
+// glob optionally followed by a port, such as "*.partner.com:8443".
+func matchUpstream(pattern string, dialInfo reverseproxy.DialInfo) bool {
+	host, port := splitUpstreamPattern(pattern)
+	if port != "" && port != "*" && port != dialInfo.Port {
+		return false
+	}
+	matched, _ := path.Match(strings.ToLower(host), strings.ToLower(dialInfo.Host))
+	return matched
+}
+
+// splitUpstreamPattern splits pattern into its host glob and optional port.
+func splitUpstreamPattern(pattern string) (host, port string) {
+	if host, port, err := net.SplitHostPort(pattern); err == nil {
+		return host, port
+	}
+	return pattern, ""
+}
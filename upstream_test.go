@@ -0,0 +1,147 @@
+package certstore
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+func dialContext(host, port string) context.Context {
+	vars := map[string]any{
+		"reverse_proxy.dial_info": reverseproxy.DialInfo{Network: "tcp", Address: host + ":" + port, Host: host, Port: port},
+	}
+	return context.WithValue(context.Background(), caddyhttp.VarsCtxKey, vars)
+}
+
+func TestHTTPTransport_PresentsClientCertificate(t *testing.T) {
+	h := &HTTPTransport{ClientCertUpstreams: []string{"*.partner.com:8443", "api.example.com"}}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"host glob and port", dialContext("eu.partner.com", "8443"), true},
+		{"case insensitive", dialContext("EU.Partner.com", "8443"), true},
+		{"wrong port", dialContext("eu.partner.com", "443"), false},
+		{"any port", dialContext("api.example.com", "9000"), true},
+		{"other host", dialContext("internal.example.com", "443"), false},
+		{"unknown upstream", context.Background(), false},
+		{"no context", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.presentsClientCertificate(tt.ctx); got != tt.want {
+				t.Fatalf("presentsClientCertificate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !(&HTTPTransport{}).presentsClientCertificate(nil) {
+		t.Fatal("expected the certificate to be presented to every upstream without patterns")
+	}
+}
+
+func TestValidateUpstreamPatterns(t *testing.T) {
+	if err := validateUpstreamPatterns("client_certificate_upstreams", []string{"*.partner.com:8443", "[::1]:443"}); err != nil {
+		t.Fatalf("expected valid patterns: %v", err)
+	}
+	assertErrorContains(t, validateUpstreamPatterns("client_certificate_upstreams", []string{"[partner.com"}), "invalid client_certificate_upstreams pattern")
+}
+
+func TestHTTPTransport_UpstreamClientCertificates(t *testing.T) {
+	resetCertificateCache(t)
+
+	defaultKey, apiKey, partnerKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	withFakeStoreLoads(t,
+		newFakeStoreLoad(newTestCertificate(t, "default.example.test", defaultKey), newFakeSigner(defaultKey.Public(), []byte("default"))),
+		newFakeStoreLoad(newTestCertificate(t, "api.example.test", apiKey), newFakeSigner(apiKey.Public(), []byte("api"))),
+		newFakeStoreLoad(newTestCertificate(t, "partner.example.test", partnerKey), newFakeSigner(partnerKey.Public(), []byte("partner"))),
+	)
+
+	h := &HTTPTransport{
+		HTTPTransport: &reverseproxy.HTTPTransport{},
+		ClientCert:    newTestSelector("^default\\.example\\.test$"),
+		UpstreamClientCerts: map[string]*CertSelector{
+			"*.partner.com:8443": newTestSelector("^partner\\.example\\.test$"),
+			"api.partner.com":    newTestSelector("^api\\.example\\.test$"),
+		},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := h.Provision(ctx); err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	defer func() {
+		if err := h.Cleanup(); err != nil {
+			t.Errorf("Cleanup failed: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want *CertSelector
+	}{
+		{"exact host over glob", dialContext("api.partner.com", "8443"), h.UpstreamClientCerts["api.partner.com"]},
+		{"glob and port", dialContext("eu.partner.com", "8443"), h.UpstreamClientCerts["*.partner.com:8443"]},
+		{"no matching pattern", dialContext("eu.partner.com", "443"), nil},
+		{"unknown upstream", context.Background(), nil},
+		{"no context", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.upstreamSelector(tt.ctx); got != tt.want {
+				t.Fatalf("upstreamSelector = %p, want %p", got, tt.want)
+			}
+		})
+	}
+
+	cert, err := h.getClientCertificate(supportedCertificateRequestInfo())
+	if err != nil {
+		t.Fatalf("getClientCertificate failed: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "default.example.test" {
+		t.Fatalf("expected upstreams matching no pattern to get the transport's certificate, got %+v", cert.Leaf)
+	}
+	finishHandshake(*cert)
+}
+
+func TestHTTPTransport_UpstreamClientCertificatesErrors(t *testing.T) {
+	tests := map[string]map[string]*CertSelector{
+		"invalid pattern":  {"[partner.com": newTestSelector("^partner$")},
+		"missing selector": {"partner.com": nil},
+	}
+
+	for name, selectors := range tests {
+		t.Run(name, func(t *testing.T) {
+			resetCertificateCache(t)
+
+			h := &HTTPTransport{HTTPTransport: &reverseproxy.HTTPTransport{}, UpstreamClientCerts: selectors}
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			err := h.Provision(ctx)
+			assertErrorContains(t, err, "upstream_client_certificates")
+			if err := h.Cleanup(); err != nil {
+				t.Errorf("Cleanup failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompareUpstreamPatterns(t *testing.T) {
+	patterns := []string{"*", "*.partner.com", "*.partner.com:8443", "partner.com", "api.partner.com", "api.partner.com:443"}
+	slices.SortFunc(patterns, compareUpstreamPatterns)
+
+	want := []string{"api.partner.com:443", "api.partner.com", "partner.com", "*.partner.com:8443", "*.partner.com", "*"}
+	if !slices.Equal(patterns, want) {
+		t.Fatalf("unexpected order %v, want %v", patterns, want)
+	}
+}